@@ -0,0 +1,303 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// SpecAnnotation keeps the v1beta1 spec of a Flipper read as v1alpha1 when
+// it has fields v1alpha1 cannot represent, so that writing the Flipper back
+// as v1alpha1 does not drop them.
+const SpecAnnotation = "flipper.io/v1beta1-spec"
+
+// StatusAnnotation does the same as SpecAnnotation for the status.
+const StatusAnnotation = "flipper.io/v1beta1-status"
+
+var _ conversion.Convertible = &Flipper{}
+
+// ConvertTo converts this Flipper to the Hub version (v1beta1).
+func (src *Flipper) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.Flipper)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	// Fields only v1beta1 knows come from the annotations, everything
+	// v1alpha1 knows from the v1alpha1 object, which may have been edited
+	// since.
+	spec := v1beta1.FlipperSpec{}
+	err := unstash(dst, SpecAnnotation, &spec)
+	if err != nil {
+		return err
+	}
+
+	status := v1beta1.FlipperStatus{}
+	err = unstash(dst, StatusAnnotation, &status)
+	if err != nil {
+		return err
+	}
+
+	convertSpecTo(&src.Spec, &spec)
+	dst.Spec = spec
+
+	convertStatusTo(&src.Status, &status)
+	dst.Status = status
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version.
+func (dst *Flipper) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.Flipper)
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	convertSpecFrom(&src.Spec, &dst.Spec)
+	convertStatusFrom(&src.Status, &dst.Status)
+
+	restored := v1beta1.FlipperSpec{}
+	convertSpecTo(&dst.Spec, &restored)
+	if !equality.Semantic.DeepEqual(restored, src.Spec) {
+		err := stash(dst, SpecAnnotation, src.Spec)
+		if err != nil {
+			return err
+		}
+	}
+
+	restoredStatus := v1beta1.FlipperStatus{}
+	convertStatusTo(&dst.Status, &restoredStatus)
+	if !equality.Semantic.DeepEqual(restoredStatus, src.Status) {
+		err := stash(dst, StatusAnnotation, src.Status)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stash keeps value in the annotation key of flipper.
+func stash(flipper *Flipper, key string, value interface{}) error {
+	stashed, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("%w. failed to marshal %s annotation of flipper: %s in namespace: %s", err, key, flipper.Name, flipper.Namespace)
+	}
+
+	if flipper.Annotations == nil {
+		flipper.Annotations = make(map[string]string)
+	}
+	flipper.Annotations[key] = string(stashed)
+
+	return nil
+}
+
+// unstash reads the annotation key of flipper into value, if it is set, and
+// removes it.
+func unstash(flipper *v1beta1.Flipper, key string, value interface{}) error {
+	stashed, ok := flipper.Annotations[key]
+	if !ok {
+		return nil
+	}
+
+	err := json.Unmarshal([]byte(stashed), value)
+	if err != nil {
+		return fmt.Errorf("%w. invalid %s annotation of flipper: %s in namespace: %s", err, key, flipper.Name, flipper.Namespace)
+	}
+
+	delete(flipper.Annotations, key)
+	if len(flipper.Annotations) == 0 {
+		flipper.Annotations = nil
+	}
+
+	return nil
+}
+
+// convertSpecTo sets every field of dst that v1alpha1 knows from src.
+func convertSpecTo(src *FlipperSpec, dst *v1beta1.FlipperSpec) {
+	dst.Interval = src.Interval
+	dst.Schedule = src.Schedule
+	dst.TimeZone = src.TimeZone
+	dst.Priority = src.Priority
+	dst.CoalesceWindow = src.CoalesceWindow
+
+	dst.Windows = nil
+	for _, window := range src.Windows {
+		dst.Windows = append(dst.Windows, v1beta1.MaintenanceWindow(window))
+	}
+
+	dst.Blackouts = nil
+	for _, blackout := range src.Blackouts {
+		dst.Blackouts = append(dst.Blackouts, v1beta1.Blackout(blackout))
+	}
+
+	dst.Match.Kinds = nil
+	for _, kind := range src.Match.Kinds {
+		dst.Match.Kinds = append(dst.Match.Kinds, v1beta1.WorkloadKind(kind))
+	}
+
+	// Custom kinds keep the health checks only v1beta1 knows from the kind of
+	// dst they were converted from, if any.
+	previousKinds := make(map[string]v1beta1.CustomKind, len(dst.Match.CustomKinds))
+	for _, kind := range dst.Match.CustomKinds {
+		previousKinds[fmt.Sprintf("%s/%s", kind.APIVersion, kind.Kind)] = kind
+	}
+
+	dst.Match.CustomKinds = nil
+	for _, kind := range src.Match.CustomKinds {
+		customKind := v1beta1.CustomKind{
+			APIVersion:              kind.APIVersion,
+			Kind:                    kind.Kind,
+			TemplateAnnotationsPath: kind.TemplateAnnotationsPath,
+		}
+		if kind.Health != nil {
+			health := v1beta1.HealthCheck{}
+			if previous := previousKinds[fmt.Sprintf("%s/%s", kind.APIVersion, kind.Kind)]; previous.Health != nil {
+				health = *previous.Health
+			}
+			health.ConditionType = kind.Health.ConditionType
+			health.ReadyPath = kind.Health.ReadyPath
+			health.DesiredPath = kind.Health.DesiredPath
+			customKind.Health = &health
+		}
+		dst.Match.CustomKinds = append(dst.Match.CustomKinds, customKind)
+	}
+
+	dst.Match.Labels = src.Match.Labels
+	dst.Match.Selector = src.Match.Selector
+	dst.Match.Namespace = src.Match.Namespace
+	dst.Match.NamespaceSelector = src.Match.NamespaceSelector
+	dst.Match.AllNamespaces = src.Match.AllNamespaces
+	dst.Match.Exclude = src.Match.Exclude
+}
+
+// convertSpecFrom sets every field of dst from src.
+func convertSpecFrom(src *v1beta1.FlipperSpec, dst *FlipperSpec) {
+	dst.Interval = src.Interval
+	dst.Schedule = src.Schedule
+	dst.TimeZone = src.TimeZone
+	dst.Priority = src.Priority
+	dst.CoalesceWindow = src.CoalesceWindow
+
+	dst.Windows = nil
+	for _, window := range src.Windows {
+		dst.Windows = append(dst.Windows, MaintenanceWindow(window))
+	}
+
+	dst.Blackouts = nil
+	for _, blackout := range src.Blackouts {
+		dst.Blackouts = append(dst.Blackouts, Blackout(blackout))
+	}
+
+	dst.Match.Kinds = nil
+	for _, kind := range src.Match.Kinds {
+		dst.Match.Kinds = append(dst.Match.Kinds, WorkloadKind(kind))
+	}
+
+	dst.Match.CustomKinds = nil
+	for _, kind := range src.Match.CustomKinds {
+		customKind := CustomKind{
+			APIVersion:              kind.APIVersion,
+			Kind:                    kind.Kind,
+			TemplateAnnotationsPath: kind.TemplateAnnotationsPath,
+		}
+		if kind.Health != nil {
+			customKind.Health = &HealthCheck{
+				ConditionType: kind.Health.ConditionType,
+				ReadyPath:     kind.Health.ReadyPath,
+				DesiredPath:   kind.Health.DesiredPath,
+			}
+		}
+		dst.Match.CustomKinds = append(dst.Match.CustomKinds, customKind)
+	}
+
+	dst.Match.Labels = src.Match.Labels
+	dst.Match.Selector = src.Match.Selector
+	dst.Match.Namespace = src.Match.Namespace
+	dst.Match.NamespaceSelector = src.Match.NamespaceSelector
+	dst.Match.AllNamespaces = src.Match.AllNamespaces
+	dst.Match.Exclude = src.Match.Exclude
+}
+
+// convertStatusTo sets every field of dst that v1alpha1 knows from src.
+func convertStatusTo(src *FlipperStatus, dst *v1beta1.FlipperStatus) {
+	dst.ObservedGeneration = src.ObservedGeneration
+	dst.LastRunTime = src.LastRunTime
+	dst.NextRunTime = src.NextRunTime
+	dst.Conditions = src.Conditions
+
+	dst.DeferredRun = nil
+	if src.DeferredRun != nil {
+		deferredRun := v1beta1.DeferredRun(*src.DeferredRun)
+		dst.DeferredRun = &deferredRun
+	}
+
+	// Targets keep the fields only v1beta1 knows from the target of dst
+	// they were converted from, if any.
+	previous := make(map[string]v1beta1.TargetStatus, len(dst.Targets))
+	for _, target := range dst.Targets {
+		previous[fmt.Sprintf("%s/%s/%s/%s", target.APIVersion, target.Kind, target.Namespace, target.Name)] = target
+	}
+
+	dst.Targets = nil
+	for _, target := range src.Targets {
+		status := previous[fmt.Sprintf("%s/%s/%s/%s", target.APIVersion, target.Kind, target.Namespace, target.Name)]
+		status.APIVersion = target.APIVersion
+		status.Kind = target.Kind
+		status.Namespace = target.Namespace
+		status.Name = target.Name
+		status.LastRestartTime = target.LastRestartTime
+		status.Result = target.Result
+		status.Message = target.Message
+		status.ConflictsWith = target.ConflictsWith
+		status.ClaimedBy = target.ClaimedBy
+		dst.Targets = append(dst.Targets, status)
+	}
+}
+
+// convertStatusFrom sets every field of dst from src.
+func convertStatusFrom(src *v1beta1.FlipperStatus, dst *FlipperStatus) {
+	dst.ObservedGeneration = src.ObservedGeneration
+	dst.LastRunTime = src.LastRunTime
+	dst.NextRunTime = src.NextRunTime
+	dst.Conditions = src.Conditions
+
+	dst.DeferredRun = nil
+	if src.DeferredRun != nil {
+		deferredRun := DeferredRun(*src.DeferredRun)
+		dst.DeferredRun = &deferredRun
+	}
+
+	dst.Targets = nil
+	for _, target := range src.Targets {
+		dst.Targets = append(dst.Targets, TargetStatus{
+			APIVersion:      target.APIVersion,
+			Kind:            target.Kind,
+			Namespace:       target.Namespace,
+			Name:            target.Name,
+			LastRestartTime: target.LastRestartTime,
+			Result:          target.Result,
+			Message:         target.Message,
+			ConflictsWith:   target.ConflictsWith,
+			ClaimedBy:       target.ClaimedBy,
+		})
+	}
+}
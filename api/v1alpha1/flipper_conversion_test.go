@@ -0,0 +1,137 @@
+package v1alpha1
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Flipper conversion", func() {
+	start := metav1.NewTime(time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC))
+	lastRun := metav1.NewTime(time.Date(2021, 7, 1, 3, 30, 0, 0, time.UTC))
+
+	newFlipper := func() *Flipper {
+		return &Flipper{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "nightly", Annotations: map[string]string{"team": "web"}},
+			Spec: FlipperSpec{
+				Interval:  "12h",
+				TimeZone:  "Europe/Berlin",
+				Windows:   []MaintenanceWindow{{Start: "0 22 * * *", Duration: "4h"}},
+				Blackouts: []Blackout{{Start: start, End: metav1.NewTime(start.Add(72 * time.Hour)), Reason: "holidays"}},
+				Priority:  10,
+				Match: Match{
+					Kinds: []WorkloadKind{"Deployment"},
+					CustomKinds: []CustomKind{{
+						APIVersion: "argoproj.io/v1alpha1",
+						Kind:       "Rollout",
+						Health:     &HealthCheck{ReadyPath: ".status.readyReplicas", DesiredPath: ".spec.replicas"},
+					}},
+					Labels:  map[string]string{"app": "web"},
+					Exclude: []string{"apps/db"},
+				},
+			},
+			Status: FlipperStatus{
+				ObservedGeneration: 3,
+				LastRunTime:        &lastRun,
+				Targets:            []TargetStatus{{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "apps", Name: "web", Result: RestartSucceeded}},
+				Conditions:         []metav1.Condition{{Type: ConditionReady, Status: metav1.ConditionTrue, Reason: "Scheduled", LastTransitionTime: lastRun}},
+			},
+		}
+	}
+
+	It("moves the interval from foo to interval", func() {
+		hub := &v1beta1.Flipper{}
+		Expect(newFlipper().ConvertTo(hub)).To(Succeed())
+		Expect(hub.Spec.Interval).To(Equal("12h"))
+
+		data, err := json.Marshal(hub.Spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(ContainSubstring(`"interval":"12h"`))
+		Expect(string(data)).NotTo(ContainSubstring(`"foo"`))
+	})
+
+	It("converts to v1beta1 and back losslessly", func() {
+		hub := &v1beta1.Flipper{}
+		Expect(newFlipper().ConvertTo(hub)).To(Succeed())
+
+		flipper := &Flipper{}
+		Expect(flipper.ConvertFrom(hub)).To(Succeed())
+		Expect(flipper).To(Equal(newFlipper()))
+	})
+
+	It("keeps fields only v1beta1 knows across a round trip", func() {
+		hub := &v1beta1.Flipper{}
+		Expect(newFlipper().ConvertTo(hub)).To(Succeed())
+		hub.Spec.Strategy = v1beta1.RestartStrategy{Type: v1beta1.StrategyMaxConcurrent, MaxConcurrent: 2, Order: v1beta1.OrderPriority}
+		hub.Spec.Match.CustomKinds[0].Health.UpdatedPath = ".status.updatedReplicas"
+
+		flipper := &Flipper{}
+		Expect(flipper.ConvertFrom(hub)).To(Succeed())
+		Expect(flipper.Annotations).To(HaveKey(SpecAnnotation))
+
+		roundTripped := &v1beta1.Flipper{}
+		Expect(flipper.ConvertTo(roundTripped)).To(Succeed())
+		Expect(roundTripped).To(Equal(hub))
+	})
+
+	It("lets edits made in v1alpha1 win over the kept v1beta1 spec", func() {
+		hub := &v1beta1.Flipper{}
+		Expect(newFlipper().ConvertTo(hub)).To(Succeed())
+
+		flipper := &Flipper{}
+		Expect(flipper.ConvertFrom(hub)).To(Succeed())
+
+		stashed, err := json.Marshal(hub.Spec)
+		Expect(err).NotTo(HaveOccurred())
+		flipper.Annotations[SpecAnnotation] = string(stashed)
+		flipper.Spec.Interval = "6h"
+
+		Expect(flipper.ConvertTo(hub)).To(Succeed())
+		Expect(hub.Spec.Interval).To(Equal("6h"))
+		Expect(hub.Annotations).NotTo(HaveKey(SpecAnnotation))
+		Expect(hub.Annotations).To(HaveKeyWithValue("team", "web"))
+	})
+
+	It("keeps status fields only v1beta1 knows across a round trip", func() {
+		hub := &v1beta1.Flipper{}
+		Expect(newFlipper().ConvertTo(hub)).To(Succeed())
+		missedRun := metav1.NewTime(lastRun.Add(-24 * time.Hour))
+		rolledBack := metav1.NewTime(lastRun.Add(time.Minute))
+		hub.Status.MissedRunTime = &missedRun
+		hub.Status.ActiveRun = &v1beta1.ActiveRun{StartTime: lastRun, Total: 2, Completed: 1, InProgress: []string{"Deployment apps/api"}}
+		hub.Status.LastRunNowToken = "2021-07-01T03:30:00Z"
+		hub.Status.StuckTarget = "Deployment apps/api"
+		hub.Status.Targets = append(hub.Status.Targets, v1beta1.TargetStatus{
+			APIVersion:     "apps/v1",
+			Kind:           "Deployment",
+			Namespace:      "apps",
+			Name:           "api",
+			Result:         v1beta1.RestartRolledBack,
+			RolledBackTime: &rolledBack,
+		})
+
+		flipper := &Flipper{}
+		Expect(flipper.ConvertFrom(hub)).To(Succeed())
+		Expect(flipper.Annotations).To(HaveKey(StatusAnnotation))
+		Expect(flipper.Annotations).NotTo(HaveKey(SpecAnnotation))
+
+		// Times come back from the annotation in the local time zone.
+		roundTripped := &v1beta1.Flipper{}
+		Expect(flipper.ConvertTo(roundTripped)).To(Succeed())
+		Expect(equality.Semantic.DeepEqual(roundTripped, hub)).To(BeTrue())
+
+		// Status written as v1alpha1 still wins for the fields it knows.
+		flipper.Status.Targets = flipper.Status.Targets[1:]
+		Expect(flipper.ConvertTo(roundTripped)).To(Succeed())
+		Expect(roundTripped.Status.Targets).To(HaveLen(1))
+		Expect(roundTripped.Status.Targets[0].RolledBackTime.Equal(&rolledBack)).To(BeTrue())
+		Expect(equality.Semantic.DeepEqual(roundTripped.Status.ActiveRun, hub.Status.ActiveRun)).To(BeTrue())
+	})
+})
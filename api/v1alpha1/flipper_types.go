@@ -23,9 +23,115 @@ import (
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
+// MaintenanceWindow is a recurring period of time in which restarts are allowed.
+type MaintenanceWindow struct {
+	// Start is a cron expression, evaluated in TimeZone, at which the window
+	// opens, such as "0 22 * * 1-5".
+	// +kubebuilder:validation:Pattern=`^(@(annually|yearly|monthly|weekly|daily|midnight|hourly)|([0-9A-Za-z*/,?-]+ +){4}[0-9A-Za-z*/,?-]+)$`
+	Start string `json:"start"`
+
+	// Duration is how long the window stays open, as a Go duration such as
+	// "4h".
+	Duration string `json:"duration"`
+}
+
+// Blackout is an absolute period of time in which no restarts happen.
+type Blackout struct {
+	Start metav1.Time `json:"start"`
+	End   metav1.Time `json:"end"`
+
+	// Reason describes the blackout, such as "release freeze".
+	// +optional
+	Reason string `json:"reason,omitempty"`
+}
+
+// WorkloadKind is a kind of workload a Flipper can restart.
+// +kubebuilder:validation:Enum=Deployment;StatefulSet;DaemonSet
+type WorkloadKind string
+
+// HealthCheck tells how to read the health of a workload from its status.
+// A workload is healthy when every check that is set passes.
+type HealthCheck struct {
+	// ConditionType is the type of a status condition that is True while the
+	// workload is healthy, such as "Available".
+	// +optional
+	ConditionType string `json:"conditionType,omitempty"`
+
+	// ReadyPath is a JSONPath to the number of ready replicas, such as
+	// ".status.readyReplicas". It has to reach the number at DesiredPath.
+	// +optional
+	ReadyPath string `json:"readyPath,omitempty"`
+
+	// DesiredPath is a JSONPath to the number of desired replicas, such as
+	// ".spec.replicas".
+	// +optional
+	DesiredPath string `json:"desiredPath,omitempty"`
+}
+
+// CustomKind is a kind of workload with a pod template that is not built in,
+// such as an Argo Rollout or an OpenShift DeploymentConfig. The controller
+// has to be allowed to get, list, watch and patch it.
+type CustomKind struct {
+	// APIVersion is the group and version of the kind, such as
+	// "argoproj.io/v1alpha1".
+	APIVersion string `json:"apiVersion"`
+
+	// Kind is the name of the kind, such as "Rollout".
+	Kind string `json:"kind"`
+
+	// TemplateAnnotationsPath is the path to the annotations of the pod
+	// template. Defaults to ".spec.template.metadata.annotations".
+	// +optional
+	// +kubebuilder:validation:Pattern=`^(\.[A-Za-z0-9_-]+)+$`
+	TemplateAnnotationsPath string `json:"templateAnnotationsPath,omitempty"`
+
+	// Health tells how to read the health of the workload from its status.
+	// Workloads without one are healthy as soon as they are restarted.
+	// +optional
+	Health *HealthCheck `json:"health,omitempty"`
+}
+
+// Match selects the workloads restarted by a Flipper. A workload has to be of
+// one of the Kinds or CustomKinds, match both Labels and Selector, and live in
+// one of the selected namespaces.
 type Match struct {
-	Labels    map[string]string `json:"labels"`
-	Namespace string            `json:"namespace"`
+	// Kinds are the built in kinds of workloads to select. Defaults to
+	// Deployment when no CustomKinds are set either.
+	// +optional
+	Kinds []WorkloadKind `json:"kinds,omitempty"`
+
+	// CustomKinds are further kinds of workloads to select.
+	// +optional
+	CustomKinds []CustomKind `json:"customKinds,omitempty"`
+
+	// Labels selects workloads whose labels equal all of the given ones.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Selector selects workloads by their labels, including set based
+	// matchExpressions.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Namespace is the namespace to select workloads from. Defaults to the
+	// namespace of the Flipper when neither NamespaceSelector nor
+	// AllNamespaces is set.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// NamespaceSelector selects the namespaces to select workloads from by
+	// their labels.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// AllNamespaces selects workloads from every namespace.
+	// +optional
+	AllNamespaces bool `json:"allNamespaces,omitempty"`
+
+	// Exclude lists workloads that are never restarted, either as "name"
+	// in any of the selected namespaces or as "namespace/name".
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
 }
 
 // FlipperSpec defines the desired state of Flipper
@@ -33,19 +139,165 @@ type FlipperSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// Foo is an example field of Flipper. Edit flipper_types.go to remove/update
+	// Interval is the time between two restarts, as a Go duration such as
+	// "12h". It is serialized under the "foo" key for compatibility with
+	// existing manifests, v1beta1 serializes it under "interval". Exactly one
+	// of Interval and Schedule must be set.
+	// +optional
 	Interval string `json:"foo,omitempty"`
-	Match    `json:"match"`
+
+	// Schedule is a cron expression in the standard five field format, or
+	// one of the @hourly, @daily, @weekly, @monthly, @yearly descriptors,
+	// at which the matched workloads are restarted.
+	// +optional
+	// +kubebuilder:validation:Pattern=`^(@(annually|yearly|monthly|weekly|daily|midnight|hourly)|([0-9A-Za-z*/,?-]+ +){4}[0-9A-Za-z*/,?-]+)$`
+	Schedule string `json:"schedule,omitempty"`
+
+	// TimeZone is the IANA name of the time zone Schedule and Windows are
+	// evaluated in, such as "Europe/Berlin". Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Windows are the recurring periods restarts are allowed in. A restart
+	// that comes due outside of them is deferred until the next one opens.
+	// Restarts are allowed at any time when no windows are set.
+	// +optional
+	Windows []MaintenanceWindow `json:"windows,omitempty"`
+
+	// Blackouts are the periods no restarts are allowed in, even inside a
+	// window. A restart that comes due in one is deferred until it ends.
+	// +optional
+	Blackouts []Blackout `json:"blackouts,omitempty"`
+
+	// Priority decides which Flipper restarts a workload matched by several
+	// of them. The highest priority wins, then the Flipper that restarts
+	// most often, then the one whose namespace and name sort first. The
+	// others leave the workload alone and report the conflict.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// CoalesceWindow is a Go duration such as "5m". A workload that was
+	// already restarted within it, by any Flipper, is not restarted again.
+	// Defaults to "1m".
+	// +optional
+	CoalesceWindow string `json:"coalesceWindow,omitempty"`
+
+	Match `json:"match"`
+}
+
+// DeferredRun describes a run that came due when restarts were not allowed.
+type DeferredRun struct {
+	// DueTime is when the run came due.
+	DueTime metav1.Time `json:"dueTime"`
+
+	// Until is the next time the run is allowed to happen.
+	Until metav1.Time `json:"until"`
+
+	// Reason is either OutsideMaintenanceWindow or Blackout.
+	Reason string `json:"reason"`
+
+	// Message explains the deferral in a human readable way.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	// ConditionReady is true while the spec is valid and runs are scheduled.
+	ConditionReady = "Ready"
+	// ConditionDegraded is true while some targets failed their last restart.
+	ConditionDegraded = "Degraded"
+	// ConditionSuspended is true while no runs are scheduled on purpose.
+	ConditionSuspended = "Suspended"
+	// ConditionConflicted is true while some targets are matched by other
+	// Flippers too.
+	ConditionConflicted = "Conflicted"
+)
+
+const (
+	// RestartSucceeded is the result of a target that restarted fine.
+	RestartSucceeded = "Succeeded"
+	// RestartFailed is the result of a target that could not be restarted.
+	RestartFailed = "Failed"
+	// RestartCoalesced is the result of a target that was left alone because
+	// it had just been restarted.
+	RestartCoalesced = "Coalesced"
+)
+
+// TargetStatus is the last restart of a workload matched by a Flipper.
+type TargetStatus struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+
+	// LastRestartTime is when the workload was last restarted.
+	// +optional
+	LastRestartTime *metav1.Time `json:"lastRestartTime,omitempty"`
+
+	// Result is either Succeeded, Failed or Coalesced, and empty before the
+	// first restart.
+	// +optional
+	Result string `json:"result,omitempty"`
+
+	// Message explains a failed or coalesced restart.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// ConflictsWith lists the other Flippers matching the workload, as
+	// "namespace/name".
+	// +optional
+	ConflictsWith []string `json:"conflictsWith,omitempty"`
+
+	// ClaimedBy is the Flipper that restarts the workload instead of this
+	// one, as "namespace/name".
+	// +optional
+	ClaimedBy string `json:"claimedBy,omitempty"`
 }
 
 // FlipperStatus defines the observed state of Flipper
 type FlipperStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// ObservedGeneration is the generation of the spec the status reflects.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastRunTime is when the matched workloads were last restarted.
+	// +optional
+	LastRunTime *metav1.Time `json:"lastRunTime,omitempty"`
+
+	// NextRunTime is when the matched workloads are restarted next.
+	// +optional
+	NextRunTime *metav1.Time `json:"nextRunTime,omitempty"`
+
+	// DeferredRun is set while a due run waits for the next allowed slot.
+	// +optional
+	DeferredRun *DeferredRun `json:"deferredRun,omitempty"`
+
+	// Targets are the workloads currently matched.
+	// +optional
+	Targets []TargetStatus `json:"targets,omitempty"`
+
+	// Conditions are the Ready, Degraded, Suspended and Conflicted
+	// conditions.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:deprecatedversion:warning="flipper.flipper.io/v1alpha1 Flipper is deprecated, use flipper.flipper.io/v1beta1 Flipper, which serializes the interval as interval instead of foo"
+//+kubebuilder:printcolumn:name="Schedule",type=string,JSONPath=`.spec.schedule`
+//+kubebuilder:printcolumn:name="Interval",type=string,JSONPath=`.spec.foo`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Degraded",type=string,JSONPath=`.status.conditions[?(@.type=="Degraded")].status`
+//+kubebuilder:printcolumn:name="Conflicted",type=string,JSONPath=`.status.conditions[?(@.type=="Conflicted")].status`,priority=1
+//+kubebuilder:printcolumn:name="Suspended",type=string,JSONPath=`.status.conditions[?(@.type=="Suspended")].status`,priority=1
+//+kubebuilder:printcolumn:name="Last Run",type=date,JSONPath=`.status.lastRunTime`
+//+kubebuilder:printcolumn:name="Next Run",type=date,JSONPath=`.status.nextRunTime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Flipper is the Schema for the flippers API
 type Flipper struct {
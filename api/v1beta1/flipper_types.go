@@ -0,0 +1,616 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MaintenanceWindow is a recurring period of time in which restarts are allowed.
+type MaintenanceWindow struct {
+	// Start is a cron expression, evaluated in TimeZone, at which the window
+	// opens, such as "0 22 * * 1-5".
+	// +kubebuilder:validation:Pattern=`^(@(annually|yearly|monthly|weekly|daily|midnight|hourly)|([0-9A-Za-z*/,?-]+ +){4}[0-9A-Za-z*/,?-]+)$`
+	Start string `json:"start"`
+
+	// Duration is how long the window stays open, as a Go duration such as
+	// "4h".
+	Duration string `json:"duration"`
+}
+
+// Blackout is an absolute period of time in which no restarts happen.
+type Blackout struct {
+	Start metav1.Time `json:"start"`
+	End   metav1.Time `json:"end"`
+
+	// Reason describes the blackout, such as "release freeze".
+	// +optional
+	Reason string `json:"reason,omitempty"`
+}
+
+// WorkloadKind is a kind of workload a Flipper can restart.
+// +kubebuilder:validation:Enum=Deployment;StatefulSet;DaemonSet
+type WorkloadKind string
+
+// HealthCheck tells how to read the health of a workload from its status.
+// A workload is healthy when every check that is set passes.
+type HealthCheck struct {
+	// ConditionType is the type of a status condition that is True while the
+	// workload is healthy, such as "Available".
+	// +optional
+	ConditionType string `json:"conditionType,omitempty"`
+
+	// ReadyPath is a JSONPath to the number of ready replicas, such as
+	// ".status.readyReplicas". It has to reach the number at DesiredPath.
+	// +optional
+	ReadyPath string `json:"readyPath,omitempty"`
+
+	// DesiredPath is a JSONPath to the number of desired replicas, such as
+	// ".spec.replicas".
+	// +optional
+	DesiredPath string `json:"desiredPath,omitempty"`
+
+	// UpdatedPath is a JSONPath to the number of replicas running the latest
+	// template, such as ".status.updatedReplicas". A rollout is complete once
+	// it reaches the number at DesiredPath.
+	// +optional
+	UpdatedPath string `json:"updatedPath,omitempty"`
+}
+
+// CustomKind is a kind of workload with a pod template that is not built in,
+// such as an Argo Rollout or an OpenShift DeploymentConfig. The controller
+// has to be allowed to get, list, watch and patch it.
+type CustomKind struct {
+	// APIVersion is the group and version of the kind, such as
+	// "argoproj.io/v1alpha1".
+	APIVersion string `json:"apiVersion"`
+
+	// Kind is the name of the kind, such as "Rollout".
+	Kind string `json:"kind"`
+
+	// TemplateAnnotationsPath is the path to the annotations of the pod
+	// template. Defaults to ".spec.template.metadata.annotations".
+	// +optional
+	// +kubebuilder:validation:Pattern=`^(\.[A-Za-z0-9_-]+)+$`
+	TemplateAnnotationsPath string `json:"templateAnnotationsPath,omitempty"`
+
+	// Health tells how to read the health of the workload from its status.
+	// Workloads without one are healthy as soon as they are restarted.
+	// +optional
+	Health *HealthCheck `json:"health,omitempty"`
+}
+
+// Match selects the workloads restarted by a Flipper. A workload has to be of
+// one of the Kinds or CustomKinds, match both Labels and Selector, and live in
+// one of the selected namespaces.
+type Match struct {
+	// Kinds are the built in kinds of workloads to select. Defaults to
+	// Deployment when no CustomKinds are set either.
+	// +optional
+	Kinds []WorkloadKind `json:"kinds,omitempty"`
+
+	// CustomKinds are further kinds of workloads to select.
+	// +optional
+	CustomKinds []CustomKind `json:"customKinds,omitempty"`
+
+	// Labels selects workloads whose labels equal all of the given ones.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Selector selects workloads by their labels, including set based
+	// matchExpressions.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Namespace is the namespace to select workloads from. Defaults to the
+	// namespace of the Flipper when neither NamespaceSelector nor
+	// AllNamespaces is set.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// NamespaceSelector selects the namespaces to select workloads from by
+	// their labels.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// AllNamespaces selects workloads from every namespace.
+	// +optional
+	AllNamespaces bool `json:"allNamespaces,omitempty"`
+
+	// Exclude lists workloads that are never restarted, either as "name"
+	// in any of the selected namespaces or as "namespace/name".
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// StrategyType is how many targets of a run are restarted at the same time.
+// +kubebuilder:validation:Enum=Parallel;Sequential;MaxConcurrent
+type StrategyType string
+
+const (
+	// StrategyParallel restarts every target at the same time.
+	StrategyParallel StrategyType = "Parallel"
+	// StrategySequential restarts one target after the other.
+	StrategySequential StrategyType = "Sequential"
+	// StrategyMaxConcurrent restarts up to MaxConcurrent targets at the same
+	// time.
+	StrategyMaxConcurrent StrategyType = "MaxConcurrent"
+)
+
+// OrderType is the order the targets of a run are restarted in.
+// +kubebuilder:validation:Enum=Name;OldestRestartFirst;Priority
+type OrderType string
+
+const (
+	// OrderName restarts targets by namespace and name.
+	OrderName OrderType = "Name"
+	// OrderOldestRestartFirst restarts the targets that were restarted the
+	// longest time ago first, and those never restarted before them.
+	OrderOldestRestartFirst OrderType = "OldestRestartFirst"
+	// OrderPriority restarts targets by the integer in their
+	// flipper.io/restart-priority annotation, highest first. Targets
+	// without one have a priority of 0.
+	OrderPriority OrderType = "Priority"
+)
+
+// RestartMethod is how a target is restarted.
+// +kubebuilder:validation:Enum=Patch;Evict
+type RestartMethod string
+
+const (
+	// RestartMethodPatch stamps the restart into the pod template, which
+	// rolls out the target like any other change of its template.
+	RestartMethodPatch RestartMethod = "Patch"
+	// RestartMethodEvict evicts the pods of the target one by one through
+	// the Eviction API, waiting for each replacement to become ready. It
+	// leaves the rollout history alone and honours PodDisruptionBudgets, but
+	// drops a replica at a time rather than surging.
+	RestartMethodEvict RestartMethod = "Evict"
+)
+
+// DisruptionBudgetPolicy decides what happens to a target whose
+// PodDisruptionBudgets do not allow a disruption.
+// +kubebuilder:validation:Enum=Wait;Skip
+type DisruptionBudgetPolicy string
+
+const (
+	// DisruptionBudgetWait waits for the budgets to allow a disruption, for
+	// as long as a rollout may take, and skips the target after that.
+	DisruptionBudgetWait DisruptionBudgetPolicy = "Wait"
+	// DisruptionBudgetSkip skips the target right away.
+	DisruptionBudgetSkip DisruptionBudgetPolicy = "Skip"
+)
+
+// CatchUpPolicy decides what happens to the runs a Flipper missed while it was
+// suspended.
+// +kubebuilder:validation:Enum=Skip;RunOnce
+type CatchUpPolicy string
+
+const (
+	// CatchUpSkip drops the missed runs, the Flipper resumes with the next
+	// one on its schedule.
+	CatchUpSkip CatchUpPolicy = "Skip"
+	// CatchUpRunOnce runs once as soon as the Flipper is resumed, however
+	// many runs it missed.
+	CatchUpRunOnce CatchUpPolicy = "RunOnce"
+)
+
+// NotificationEvent is a run event notifications are sent for.
+// +kubebuilder:validation:Enum=RunStarted;RunSucceeded;RunFailed;RolledBack
+type NotificationEvent string
+
+const (
+	// NotifyRunStarted is sent when a run starts.
+	NotifyRunStarted NotificationEvent = "RunStarted"
+	// NotifyRunSucceeded is sent when a run completes without failures.
+	NotifyRunSucceeded NotificationEvent = "RunSucceeded"
+	// NotifyRunFailed is sent when a run completes with failures.
+	NotifyRunFailed NotificationEvent = "RunFailed"
+	// NotifyRolledBack is sent when a run rolls targets back.
+	NotifyRolledBack NotificationEvent = "RolledBack"
+)
+
+// NotificationFormat is how the body of a notification is rendered.
+// +kubebuilder:validation:Enum=CloudEvents;Template
+type NotificationFormat string
+
+const (
+	// NotificationCloudEvents sends notifications as structured CloudEvents
+	// 1.0.
+	NotificationCloudEvents NotificationFormat = "CloudEvents"
+	// NotificationTemplate renders the body of notifications with Template.
+	NotificationTemplate NotificationFormat = "Template"
+)
+
+// Notification is an HTTP endpoint that runs of a Flipper are POSTed to.
+type Notification struct {
+	// SecretName is the Secret, in the namespace of the Flipper, holding
+	// the url of the endpoint under the "url" key, and optionally a bearer
+	// token under the "token" key.
+	SecretName string `json:"secretName"`
+
+	// Events are the events notifications are sent for. Defaults to all of
+	// RunStarted, RunSucceeded, RunFailed and RolledBack.
+	// +optional
+	Events []NotificationEvent `json:"events,omitempty"`
+
+	// Format is either CloudEvents or Template. Defaults to CloudEvents.
+	// +optional
+	Format NotificationFormat `json:"format,omitempty"`
+
+	// Template is a Go template rendering the JSON body of a notification
+	// from the event, whose fields are Type, Namespace, Flipper, Run,
+	// Trigger, DryRun, Time, Targets and Message. Required with the
+	// Template format.
+	// +optional
+	Template string `json:"template,omitempty"`
+}
+
+// PriorityAnnotation orders the targets of Flippers with the Priority order.
+const PriorityAnnotation = "flipper.io/restart-priority"
+
+// RunNowAnnotation requests a run of a Flipper outside of its schedule. Each
+// new value, such as a timestamp or a ticket number, starts one run.
+const RunNowAnnotation = "flipper.io/run-now"
+
+// RestartStrategy decides how the targets of a run are restarted.
+type RestartStrategy struct {
+	// Type is one of Parallel, Sequential and MaxConcurrent. Defaults to
+	// Parallel.
+	// +optional
+	Type StrategyType `json:"type,omitempty"`
+
+	// MaxConcurrent is how many targets are restarted at the same time with
+	// the MaxConcurrent type.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	MaxConcurrent int32 `json:"maxConcurrent,omitempty"`
+
+	// Order is one of Name, OldestRestartFirst and Priority. Ties are
+	// broken by name. Defaults to Name.
+	// +optional
+	Order OrderType `json:"order,omitempty"`
+
+	// RolloutTimeout is how long a run waits for the rollout of a target to
+	// complete before it stops, as a Go duration such as "15m". Defaults to
+	// the progressDeadlineSeconds of Deployments, which report on their own
+	// when they are stuck, and to 10m for other kinds.
+	// +optional
+	RolloutTimeout string `json:"rolloutTimeout,omitempty"`
+
+	// RollbackOnFailure restores the previous pod template of a target whose
+	// rollout does not complete, as `kubectl rollout undo` would. The target
+	// gets a flipper.io/rolled-back annotation and is not restarted again
+	// until someone removes it.
+	// +optional
+	RollbackOnFailure bool `json:"rollbackOnFailure,omitempty"`
+
+	// DisruptionBudgetPolicy is either Wait or Skip, and decides what
+	// happens to a target when a PodDisruptionBudget covering its pods does
+	// not allow a disruption. Defaults to Wait.
+	// +optional
+	DisruptionBudgetPolicy DisruptionBudgetPolicy `json:"disruptionBudgetPolicy,omitempty"`
+
+	// RestartMethod is either Patch or Evict. Defaults to Patch.
+	// +optional
+	RestartMethod RestartMethod `json:"restartMethod,omitempty"`
+
+	// OrderByPodDeletionCost evicts the pods of a target with the lowest
+	// controller.kubernetes.io/pod-deletion-cost first with the Evict
+	// method. Pods are evicted from the highest StatefulSet ordinal down
+	// otherwise.
+	// +optional
+	OrderByPodDeletionCost bool `json:"orderByPodDeletionCost,omitempty"`
+}
+
+// FlipperSpec defines the desired state of Flipper
+type FlipperSpec struct {
+	// Interval is the time between two restarts, as a Go duration such as
+	// "12h". Exactly one of Interval and Schedule must be set, unless
+	// MaxPodAge or ReloadOnConfigChange is.
+	// +optional
+	Interval string `json:"interval,omitempty"`
+
+	// Schedule is a cron expression in the standard five field format, or
+	// one of the @hourly, @daily, @weekly, @monthly, @yearly descriptors,
+	// at which the matched workloads are restarted.
+	// +optional
+	// +kubebuilder:validation:Pattern=`^(@(annually|yearly|monthly|weekly|daily|midnight|hourly)|([0-9A-Za-z*/,?-]+ +){4}[0-9A-Za-z*/,?-]+)$`
+	Schedule string `json:"schedule,omitempty"`
+
+	// TimeZone is the IANA name of the time zone Schedule and Windows are
+	// evaluated in, such as "Europe/Berlin". Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Windows are the recurring periods restarts are allowed in. A restart
+	// that comes due outside of them is deferred until the next one opens.
+	// Restarts are allowed at any time when no windows are set.
+	// +optional
+	Windows []MaintenanceWindow `json:"windows,omitempty"`
+
+	// Blackouts are the periods no restarts are allowed in, even inside a
+	// window. A restart that comes due in one is deferred until it ends.
+	// +optional
+	Blackouts []Blackout `json:"blackouts,omitempty"`
+
+	// Priority decides which Flipper restarts a workload matched by several
+	// of them. The highest priority wins, then the Flipper that restarts
+	// most often, then the one whose namespace and name sort first. The
+	// others leave the workload alone and report the conflict.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// CoalesceWindow is a Go duration such as "5m". A workload that was
+	// already restarted within it, by any Flipper, is not restarted again.
+	// Defaults to "1m".
+	// +optional
+	CoalesceWindow string `json:"coalesceWindow,omitempty"`
+
+	// Strategy decides how many targets are restarted at the same time and
+	// in which order.
+	// +optional
+	Strategy RestartStrategy `json:"strategy,omitempty"`
+
+	// MaxPodAge is a Go duration such as "168h". When it is set, a run only
+	// restarts the targets that have a pod started longer ago than that,
+	// and with the Evict restart method only evicts those pods. Interval or
+	// Schedule then decide how often the pods are checked, every hour unless
+	// one of them is set.
+	// +optional
+	MaxPodAge string `json:"maxPodAge,omitempty"`
+
+	// ReloadOnConfigChange rolls out the matched Deployments whenever the
+	// content of a ConfigMap or Secret their pod template references through
+	// env, envFrom or volumes changes. A Flipper that sets neither Interval
+	// nor Schedule only does that. It may only be set on Flippers that match
+	// Deployments alone.
+	// +optional
+	ReloadOnConfigChange bool `json:"reloadOnConfigChange,omitempty"`
+
+	// Suspend stops the Flipper from starting runs, on its schedule, on
+	// request or on config changes, while it is true. A run that is already
+	// in progress is not stopped.
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// CatchUpPolicy is either Skip or RunOnce, and decides what happens to
+	// the runs missed while the Flipper was suspended once it is resumed.
+	// Defaults to Skip.
+	// +optional
+	CatchUpPolicy CatchUpPolicy `json:"catchUpPolicy,omitempty"`
+
+	// DryRun evaluates the targets, windows and everything else a restart
+	// depends on when runs come due, and records which targets would have
+	// been restarted in the status and in events, without restarting any.
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// SuccessfulRunsHistoryLimit is how many FlipperRuns of succeeded runs
+	// are kept. Defaults to 3.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	SuccessfulRunsHistoryLimit *int32 `json:"successfulRunsHistoryLimit,omitempty"`
+
+	// FailedRunsHistoryLimit is how many FlipperRuns of failed runs are
+	// kept. Defaults to 1.
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	FailedRunsHistoryLimit *int32 `json:"failedRunsHistoryLimit,omitempty"`
+
+	// Notifications are the HTTP endpoints the runs of the Flipper are
+	// reported to, on top of the one the manager is configured with.
+	// +optional
+	Notifications []Notification `json:"notifications,omitempty"`
+
+	Match `json:"match"`
+}
+
+// DeferredRun describes a run that came due when restarts were not allowed.
+type DeferredRun struct {
+	// DueTime is when the run came due.
+	DueTime metav1.Time `json:"dueTime"`
+
+	// Until is the next time the run is allowed to happen.
+	Until metav1.Time `json:"until"`
+
+	// Reason is either OutsideMaintenanceWindow or Blackout.
+	Reason string `json:"reason"`
+
+	// Message explains the deferral in a human readable way.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	// ConditionReady is true while the spec is valid and runs are scheduled.
+	ConditionReady = "Ready"
+	// ConditionDegraded is true while some targets failed their last restart.
+	ConditionDegraded = "Degraded"
+	// ConditionSuspended is true while no runs are scheduled on purpose.
+	ConditionSuspended = "Suspended"
+	// ConditionConflicted is true while some targets are matched by other
+	// Flippers too.
+	ConditionConflicted = "Conflicted"
+)
+
+const (
+	// RestartSucceeded is the result of a target that restarted fine.
+	RestartSucceeded = "Succeeded"
+	// RestartFailed is the result of a target that could not be restarted.
+	RestartFailed = "Failed"
+	// RestartCoalesced is the result of a target that was left alone because
+	// it had just been restarted.
+	RestartCoalesced = "Coalesced"
+	// RestartRolledBack is the result of a target that was rolled back as its
+	// rollout did not complete.
+	RestartRolledBack = "RolledBack"
+	// RestartBlocked is the result of a target that was not restarted because
+	// its PodDisruptionBudgets did not allow a disruption.
+	RestartBlocked = "Blocked"
+	// RestartNotDue is the result of a target that was not restarted because
+	// none of its pods was older than the MaxPodAge.
+	RestartNotDue = "NotDue"
+	// RestartSkipped is the result of a target that was not restarted because
+	// the run stopped on a stuck rollout first.
+	RestartSkipped = "Skipped"
+	// RestartWouldRestart is the result of a target that a dry run would
+	// have restarted.
+	RestartWouldRestart = "WouldRestart"
+)
+
+// ActiveRun describes a run that is restarting targets.
+type ActiveRun struct {
+	// StartTime is when the run started.
+	StartTime metav1.Time `json:"startTime"`
+
+	// Total is how many targets the run restarts.
+	Total int32 `json:"total"`
+
+	// Completed is how many targets the run finished restarting.
+	Completed int32 `json:"completed"`
+
+	// InProgress lists the targets being restarted, as "Kind namespace/name".
+	// +optional
+	InProgress []string `json:"inProgress,omitempty"`
+}
+
+// TargetStatus is the last restart of a workload matched by a Flipper.
+type TargetStatus struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+
+	// LastRestartTime is when the workload was last restarted.
+	// +optional
+	LastRestartTime *metav1.Time `json:"lastRestartTime,omitempty"`
+
+	// Result is one of Succeeded, Failed, Coalesced, Blocked, NotDue,
+	// RolledBack, Skipped and WouldRestart, and empty before the first
+	// restart.
+	// +optional
+	Result string `json:"result,omitempty"`
+
+	// Message explains a failed or coalesced restart, or when a dry run
+	// would have restarted the workload.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// ConflictsWith lists the other Flippers matching the workload, as
+	// "namespace/name".
+	// +optional
+	ConflictsWith []string `json:"conflictsWith,omitempty"`
+
+	// ClaimedBy is the Flipper that restarts the workload instead of this
+	// one, as "namespace/name".
+	// +optional
+	ClaimedBy string `json:"claimedBy,omitempty"`
+
+	// RolledBackTime is when the workload was rolled back. Its restarts are
+	// paused until its flipper.io/rolled-back annotation is removed.
+	// +optional
+	RolledBackTime *metav1.Time `json:"rolledBackTime,omitempty"`
+}
+
+// FlipperStatus defines the observed state of Flipper
+type FlipperStatus struct {
+	// ObservedGeneration is the generation of the spec the status reflects.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// LastRunTime is when the matched workloads were last restarted, or
+	// evaluated by a dry run.
+	// +optional
+	LastRunTime *metav1.Time `json:"lastRunTime,omitempty"`
+
+	// NextRunTime is when the matched workloads are restarted next.
+	// +optional
+	NextRunTime *metav1.Time `json:"nextRunTime,omitempty"`
+
+	// MissedRunTime is when the first run missed while the Flipper was
+	// suspended came due.
+	// +optional
+	MissedRunTime *metav1.Time `json:"missedRunTime,omitempty"`
+
+	// DeferredRun is set while a due run waits for the next allowed slot.
+	// +optional
+	DeferredRun *DeferredRun `json:"deferredRun,omitempty"`
+
+	// ActiveRun is set while a run restarts targets.
+	// +optional
+	ActiveRun *ActiveRun `json:"activeRun,omitempty"`
+
+	// LastRunNowToken is the value of the flipper.io/run-now annotation that
+	// last started a run, so that it is not run again.
+	// +optional
+	LastRunNowToken string `json:"lastRunNowToken,omitempty"`
+
+	// StuckTarget names the workload, as "Kind namespace/name", whose rollout
+	// did not complete and stopped the last run. Workloads restarted at the
+	// same time that got stuck too are listed after it, separated by commas.
+	// +optional
+	StuckTarget string `json:"stuckTarget,omitempty"`
+
+	// Targets are the workloads currently matched.
+	// +optional
+	Targets []TargetStatus `json:"targets,omitempty"`
+
+	// Conditions are the Ready, Degraded, Suspended and Conflicted
+	// conditions.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Schedule",type=string,JSONPath=`.spec.schedule`
+//+kubebuilder:printcolumn:name="Interval",type=string,JSONPath=`.spec.interval`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Degraded",type=string,JSONPath=`.status.conditions[?(@.type=="Degraded")].status`
+//+kubebuilder:printcolumn:name="Conflicted",type=string,JSONPath=`.status.conditions[?(@.type=="Conflicted")].status`,priority=1
+//+kubebuilder:printcolumn:name="Suspended",type=string,JSONPath=`.status.conditions[?(@.type=="Suspended")].status`,priority=1
+//+kubebuilder:printcolumn:name="Last Run",type=date,JSONPath=`.status.lastRunTime`
+//+kubebuilder:printcolumn:name="Next Run",type=date,JSONPath=`.status.nextRunTime`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Flipper is the Schema for the flippers API
+type Flipper struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FlipperSpec   `json:"spec,omitempty"`
+	Status FlipperStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FlipperList contains a list of Flipper
+type FlipperList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Flipper `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Flipper{}, &FlipperList{})
+}
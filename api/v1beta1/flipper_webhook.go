@@ -0,0 +1,126 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+const (
+	// DefaultTimeZone is the time zone schedules and windows are evaluated in
+	// unless the Flipper says otherwise.
+	DefaultTimeZone = "UTC"
+	// DefaultCoalesceWindow is the coalesce window of a Flipper unless it says
+	// otherwise.
+	DefaultCoalesceWindow = "1m"
+	// DefaultTemplateAnnotationsPath is where the pod template annotations of
+	// a custom kind live unless it says otherwise.
+	DefaultTemplateAnnotationsPath = ".spec.template.metadata.annotations"
+	// DefaultPodAgeCheckInterval is how often the pods of a Flipper with a
+	// MaxPodAge are checked unless it sets an interval or a schedule.
+	DefaultPodAgeCheckInterval = "1h"
+	// DefaultSuccessfulRunsHistoryLimit is how many FlipperRuns of succeeded
+	// runs are kept unless the Flipper says otherwise.
+	DefaultSuccessfulRunsHistoryLimit = 3
+	// DefaultFailedRunsHistoryLimit is how many FlipperRuns of failed runs
+	// are kept unless the Flipper says otherwise.
+	DefaultFailedRunsHistoryLimit = 1
+)
+
+// log is for logging in this package.
+var flipperlog = logf.Log.WithName("flipper-resource")
+
+// SetupWebhookWithManager registers the defaulting and conversion webhooks of
+// Flippers. They are validated by the webhooks package.
+func (r *Flipper) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-flipper-flipper-io-v1beta1-flipper,mutating=true,failurePolicy=fail,sideEffects=None,groups=flipper.flipper.io,resources=flippers,verbs=create;update,versions=v1beta1,name=mflipper.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Defaulter = &Flipper{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *Flipper) Default() {
+	flipperlog.Info("default", "name", r.Name)
+
+	if r.Spec.TimeZone == "" && (r.Spec.Schedule != "" || len(r.Spec.Windows) > 0) {
+		r.Spec.TimeZone = DefaultTimeZone
+	}
+
+	if r.Spec.MaxPodAge != "" && r.Spec.Interval == "" && r.Spec.Schedule == "" {
+		r.Spec.Interval = DefaultPodAgeCheckInterval
+	}
+
+	if r.Spec.CoalesceWindow == "" {
+		r.Spec.CoalesceWindow = DefaultCoalesceWindow
+	}
+
+	if r.Spec.Strategy.Type == "" {
+		r.Spec.Strategy.Type = StrategyParallel
+	}
+
+	if r.Spec.Strategy.Order == "" {
+		r.Spec.Strategy.Order = OrderName
+	}
+
+	if r.Spec.Strategy.DisruptionBudgetPolicy == "" {
+		r.Spec.Strategy.DisruptionBudgetPolicy = DisruptionBudgetWait
+	}
+
+	if r.Spec.Strategy.RestartMethod == "" {
+		r.Spec.Strategy.RestartMethod = RestartMethodPatch
+	}
+
+	if r.Spec.CatchUpPolicy == "" {
+		r.Spec.CatchUpPolicy = CatchUpSkip
+	}
+
+	if r.Spec.SuccessfulRunsHistoryLimit == nil {
+		limit := int32(DefaultSuccessfulRunsHistoryLimit)
+		r.Spec.SuccessfulRunsHistoryLimit = &limit
+	}
+
+	if r.Spec.FailedRunsHistoryLimit == nil {
+		limit := int32(DefaultFailedRunsHistoryLimit)
+		r.Spec.FailedRunsHistoryLimit = &limit
+	}
+
+	for idx := range r.Spec.Notifications {
+		if r.Spec.Notifications[idx].Format == "" {
+			r.Spec.Notifications[idx].Format = NotificationCloudEvents
+		}
+	}
+
+	if len(r.Spec.Match.Kinds) == 0 && len(r.Spec.Match.CustomKinds) == 0 {
+		r.Spec.Match.Kinds = []WorkloadKind{"Deployment"}
+	}
+
+	for idx := range r.Spec.Match.CustomKinds {
+		if r.Spec.Match.CustomKinds[idx].TemplateAnnotationsPath == "" {
+			r.Spec.Match.CustomKinds[idx].TemplateAnnotationsPath = DefaultTemplateAnnotationsPath
+		}
+	}
+
+	if r.Spec.Match.Namespace == "" && r.Spec.Match.NamespaceSelector == nil && !r.Spec.Match.AllNamespaces {
+		r.Spec.Match.Namespace = r.Namespace
+	}
+}
@@ -0,0 +1,66 @@
+package v1beta1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newFlipper() *Flipper {
+	return &Flipper{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "nightly"},
+		Spec: FlipperSpec{
+			Schedule: "30 3 * * *",
+			Match:    Match{Labels: map[string]string{"app": "web"}},
+		},
+	}
+}
+
+var _ = Describe("Flipper webhook", func() {
+	It("defaults every optional field", func() {
+		flipper := newFlipper()
+		flipper.Spec.Match.CustomKinds = []CustomKind{{APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout"}}
+		flipper.Spec.Notifications = []Notification{{SecretName: "slack"}}
+
+		flipper.Default()
+
+		Expect(flipper.Spec.TimeZone).To(Equal(DefaultTimeZone))
+		Expect(flipper.Spec.CoalesceWindow).To(Equal(DefaultCoalesceWindow))
+		Expect(flipper.Spec.Strategy).To(Equal(RestartStrategy{Type: StrategyParallel, Order: OrderName, DisruptionBudgetPolicy: DisruptionBudgetWait, RestartMethod: RestartMethodPatch}))
+		Expect(flipper.Spec.CatchUpPolicy).To(Equal(CatchUpSkip))
+		Expect(*flipper.Spec.SuccessfulRunsHistoryLimit).To(BeEquivalentTo(DefaultSuccessfulRunsHistoryLimit))
+		Expect(*flipper.Spec.FailedRunsHistoryLimit).To(BeEquivalentTo(DefaultFailedRunsHistoryLimit))
+		Expect(flipper.Spec.Notifications[0].Format).To(Equal(NotificationCloudEvents))
+		Expect(flipper.Spec.Match.Kinds).To(BeEmpty())
+		Expect(flipper.Spec.Match.CustomKinds[0].TemplateAnnotationsPath).To(Equal(DefaultTemplateAnnotationsPath))
+		Expect(flipper.Spec.Match.Namespace).To(Equal("apps"))
+
+		flipper = newFlipper()
+		flipper.Spec.Match.AllNamespaces = true
+		flipper.Default()
+
+		Expect(flipper.Spec.Match.Kinds).To(ConsistOf(WorkloadKind("Deployment")))
+		Expect(flipper.Spec.Match.Namespace).To(BeEmpty())
+	})
+
+	It("checks the pods of flippers with a max pod age every hour", func() {
+		flipper := newFlipper()
+		flipper.Spec.Schedule = ""
+		flipper.Spec.MaxPodAge = "168h"
+
+		flipper.Default()
+
+		Expect(flipper.Spec.Interval).To(Equal(DefaultPodAgeCheckInterval))
+	})
+
+	It("leaves flippers that only reload on config changes without a schedule", func() {
+		flipper := newFlipper()
+		flipper.Spec.Schedule = ""
+		flipper.Spec.ReloadOnConfigChange = true
+
+		flipper.Default()
+
+		Expect(flipper.Spec.Interval).To(BeEmpty())
+	})
+})
@@ -0,0 +1,140 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RunTrigger is what started a run.
+// +kubebuilder:validation:Enum=Schedule;Manual;Event
+type RunTrigger string
+
+const (
+	// TriggerSchedule is a run that came due on the schedule of the Flipper.
+	TriggerSchedule RunTrigger = "Schedule"
+	// TriggerManual is a run requested through the flipper.io/run-now
+	// annotation.
+	TriggerManual RunTrigger = "Manual"
+	// TriggerEvent is a rollout started by a change to the config of a
+	// target.
+	TriggerEvent RunTrigger = "Event"
+)
+
+// RunPhase is where a run is at.
+// +kubebuilder:validation:Enum=Running;Succeeded;Failed
+type RunPhase string
+
+const (
+	// RunRunning is a run that is restarting targets.
+	RunRunning RunPhase = "Running"
+	// RunSucceeded is a run none of whose targets failed.
+	RunSucceeded RunPhase = "Succeeded"
+	// RunFailed is a run with targets that failed, were rolled back, were
+	// skipped or were blocked by a PodDisruptionBudget, or that was
+	// interrupted.
+	RunFailed RunPhase = "Failed"
+)
+
+// FlipperRunSpec describes what started a run of a Flipper.
+type FlipperRunSpec struct {
+	// FlipperName is the Flipper, in the same namespace, the run belongs to.
+	FlipperName string `json:"flipperName"`
+
+	// Trigger is one of Schedule, Manual and Event.
+	Trigger RunTrigger `json:"trigger"`
+
+	// Token is the value of the flipper.io/run-now annotation that requested
+	// a Manual run.
+	// +optional
+	Token string `json:"token,omitempty"`
+
+	// DryRun is set for runs that did not restart anything.
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// RunTarget is the outcome of a target of a run.
+type RunTarget struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+
+	// Result is one of the results of TargetStatus.
+	Result string `json:"result"`
+
+	// Message explains the result, including the error of failed restarts.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// FlipperRunStatus is the progress and outcome of a run.
+type FlipperRunStatus struct {
+	// Phase is one of Running, Succeeded and Failed.
+	// +optional
+	Phase RunPhase `json:"phase,omitempty"`
+
+	// StartTime is when the run started.
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the run ended.
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Targets are the outcomes of the targets of the run.
+	// +optional
+	Targets []RunTarget `json:"targets,omitempty"`
+
+	// Message explains why the run failed, when it is not down to its
+	// targets alone.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Flipper",type=string,JSONPath=`.spec.flipperName`
+//+kubebuilder:printcolumn:name="Trigger",type=string,JSONPath=`.spec.trigger`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Dry Run",type=boolean,JSONPath=`.spec.dryRun`,priority=1
+//+kubebuilder:printcolumn:name="Started",type=date,JSONPath=`.status.startTime`
+//+kubebuilder:printcolumn:name="Completed",type=date,JSONPath=`.status.completionTime`
+
+// FlipperRun records a run of a Flipper, what started it and how each of its
+// targets fared.
+type FlipperRun struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FlipperRunSpec   `json:"spec,omitempty"`
+	Status FlipperRunStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FlipperRunList contains a list of FlipperRun
+type FlipperRunList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FlipperRun `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FlipperRun{}, &FlipperRunList{})
+}
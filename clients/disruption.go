@@ -0,0 +1,144 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anmolbabu/kraft-controller/models"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// ErrDisruptionBudget is returned for workloads that are not restarted because
+// a PodDisruptionBudget covering their pods does not allow a disruption.
+var ErrDisruptionBudget = errors.New("blocked by a pod disruption budget")
+
+// IsBlockedByDisruptionBudget reports whether err is, or wraps,
+// ErrDisruptionBudget.
+func IsBlockedByDisruptionBudget(err error) bool {
+	return errors.Is(err, ErrDisruptionBudget)
+}
+
+// CheckDisruptionBudgets returns ErrDisruptionBudget, naming the blocking
+// budgets, when the PodDisruptionBudgets covering the pods of workload do not
+// allow a disruption right now, for restarts that do not go through
+// RestartWorkload.
+func (kraftClient *KraftClients) CheckDisruptionBudgets(ctx context.Context, workload models.Workload) error {
+	resourceClient, err := kraftClient.resourceFor(workload)
+	if err != nil {
+		return err
+	}
+
+	object, err := resourceClient.Get(ctx, workload.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("%w. failed to fetch %s: %s in namespace: %s", err, workload.Type.Kind, workload.Name, workload.Namespace)
+	}
+
+	return kraftClient.checkDisruptionBudgets(ctx, workload, object, RestartOptions{})
+}
+
+// checkDisruptionBudgets makes sure that the PodDisruptionBudgets covering the
+// pods of object allow a disruption before it is restarted. With
+// options.WaitForDisruptionBudgets it waits for them to allow one, for as long
+// as a rollout may take, otherwise, and in dry runs, it gives up right away.
+// Either way it returns ErrDisruptionBudget, naming the blocking budgets, when
+// they do not.
+func (kraftClient *KraftClients) checkDisruptionBudgets(ctx context.Context, workload models.Workload, object *unstructured.Unstructured, options RestartOptions) error {
+	selector, found, err := workloadSelector(workload, object)
+	if err != nil || !found {
+		// Without a selector there is no telling which pods are disrupted.
+		return err
+	}
+
+	var blocking []string
+	check := func() (bool, error) {
+		blocking, err = kraftClient.blockingBudgets(ctx, workload.Namespace, selector)
+		if err != nil {
+			return false, fmt.Errorf("%w. failed to check the pod disruption budgets of %s: %s in namespace: %s", err, workload.Type.Kind, workload.Name, workload.Namespace)
+		}
+
+		return len(blocking) == 0, nil
+	}
+
+	if !options.WaitForDisruptionBudgets || options.DryRun {
+		allowed, err := check()
+		if err != nil || allowed {
+			return err
+		}
+
+		return fmt.Errorf("%w. %s: %s in namespace: %s was skipped as no disruption is allowed by: %s", ErrDisruptionBudget, workload.Type.Kind, workload.Name, workload.Namespace, strings.Join(blocking, ", "))
+	}
+
+	timeout := options.RolloutTimeout
+	if timeout <= 0 {
+		timeout = DefaultRolloutTimeout
+	}
+
+	err = wait.PollImmediate(podPollInterval, timeout, check)
+	if errors.Is(err, wait.ErrWaitTimeout) {
+		return fmt.Errorf("%w. %s: %s in namespace: %s was skipped as no disruption was allowed within %s by: %s", ErrDisruptionBudget, workload.Type.Kind, workload.Name, workload.Namespace, timeout, strings.Join(blocking, ", "))
+	}
+
+	return err
+}
+
+// blockingBudgets returns the names of the PodDisruptionBudgets in namespace
+// that cover a pod matching selector and do not allow a disruption right now.
+func (kraftClient *KraftClients) blockingBudgets(ctx context.Context, namespace string, selector *metav1.LabelSelector) ([]string, error) {
+	pods, err := kraftClient.selectedPods(ctx, namespace, selector)
+	if err != nil || len(pods) == 0 {
+		return nil, err
+	}
+
+	budgetList, err := kraftClient.kubeClient.PolicyV1().PodDisruptionBudgets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var blocking []string
+	for _, budget := range budgetList.Items {
+		if budget.Status.DisruptionsAllowed > 0 {
+			continue
+		}
+
+		covers, err := budgetCovers(budget, pods)
+		if err != nil {
+			return nil, err
+		}
+		if covers {
+			blocking = append(blocking, budget.Name)
+		}
+	}
+	sort.Strings(blocking)
+
+	return blocking, nil
+}
+
+// budgetCovers reports whether budget selects any of pods.
+func budgetCovers(budget policyv1.PodDisruptionBudget, pods []corev1.Pod) (bool, error) {
+	// A budget without a selector selects no pods.
+	if budget.Spec.Selector == nil {
+		return false, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+	if err != nil {
+		return false, fmt.Errorf("%w. invalid selector of pod disruption budget: %s in namespace: %s", err, budget.Name, budget.Namespace)
+	}
+
+	for _, pod := range pods {
+		if selector.Matches(labels.Set(pod.Labels)) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
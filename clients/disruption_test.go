@@ -0,0 +1,63 @@
+package clients
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// newBudget returns a PodDisruptionBudget selecting the pods labeled app: app
+// that allows disruptionsAllowed disruptions.
+func newBudget(name string, app string, disruptionsAllowed int32) *policyv1.PodDisruptionBudget {
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: name},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}},
+		Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: disruptionsAllowed},
+	}
+}
+
+var _ = Describe("Disruption budgets", func() {
+	ctx := context.Background()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "web-0", Labels: map[string]string{"app": "web"}}}
+
+	for _, tc := range []struct {
+		name    string
+		objects []runtime.Object
+		blocked bool
+	}{
+		{name: "skips deployments whose pods a budget allows no disruption of", objects: []runtime.Object{pod, newBudget("web", "web", 0)}, blocked: true},
+		{name: "restarts deployments whose budgets allow a disruption", objects: []runtime.Object{pod, newBudget("web", "web", 1)}},
+		{name: "ignores budgets covering other pods", objects: []runtime.Object{pod, newBudget("api", "api", 0)}},
+		{name: "ignores budgets of deployments without pods", objects: []runtime.Object{newBudget("web", "web", 0)}},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			kraftClients, dynamicClient := newTestClients([]*appsv1.Deployment{newDeployment("web", nil)}, tc.objects...)
+
+			err := kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{})
+			if tc.blocked {
+				Expect(IsBlockedByDisruptionBudget(err)).To(BeTrue(), "%v", err)
+				Expect(templateAnnotations(dynamicClient, "web")).NotTo(HaveKey(RestartTimeAnnotation))
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(templateAnnotations(dynamicClient, "web")).To(HaveKey(RestartTimeAnnotation))
+			}
+
+			By("checking the budgets without restarting")
+			kraftClients, dynamicClient = newTestClients([]*appsv1.Deployment{newDeployment("web", nil)}, tc.objects...)
+			err = kraftClients.CheckDisruptionBudgets(ctx, deploymentWorkload("web"))
+			Expect(IsBlockedByDisruptionBudget(err)).To(Equal(tc.blocked), "%v", err)
+			if !tc.blocked {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(templateAnnotations(dynamicClient, "web")).To(BeEmpty())
+		})
+	}
+})
@@ -0,0 +1,139 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/anmolbabu/kraft-controller/models"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// Available reports whether workload has caught up with its spec and is
+// available according to the health checks of its type, whether or not its
+// rollout is complete.
+func (kraftClient *KraftClients) Available(ctx context.Context, workload models.Workload) (bool, error) {
+	resourceClient, err := kraftClient.resourceFor(workload)
+	if err != nil {
+		return false, err
+	}
+
+	object, err := resourceClient.Get(ctx, workload.Name, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("%w. failed to fetch %s: %s in namespace: %s", err, workload.Type.Kind, workload.Name, workload.Namespace)
+	}
+
+	return IsHealthy(object, workload.Type.Health.Availability())
+}
+
+// IsHealthy evaluates health against object. Objects whose controller has not
+// observed their latest generation yet, or whose rollout is not complete
+// according to health, are never healthy.
+func IsHealthy(object *unstructured.Unstructured, health models.Health) (bool, error) {
+	observedGeneration, found, _ := unstructured.NestedInt64(object.Object, "status", "observedGeneration")
+	if found && observedGeneration < object.GetGeneration() {
+		return false, nil
+	}
+
+	if health.ConditionType != "" && !conditionTrue(object, health.ConditionType) {
+		return false, nil
+	}
+
+	if health.ReadyPath == "" || health.DesiredPath == "" {
+		return true, nil
+	}
+
+	ready, err := readCount(object, health.ReadyPath)
+	if err != nil {
+		return false, err
+	}
+
+	desired, err := readCount(object, health.DesiredPath)
+	if err != nil {
+		return false, err
+	}
+
+	if ready < desired {
+		return false, nil
+	}
+
+	if health.UpdatedPath != "" {
+		updated, err := readCount(object, health.UpdatedPath)
+		if err != nil {
+			return false, err
+		}
+
+		// Pods below the partition of a StatefulSet are never updated.
+		partition, _, _ := unstructured.NestedInt64(object.Object, "spec", "updateStrategy", "rollingUpdate", "partition")
+		if updated < desired-partition {
+			return false, nil
+		}
+	}
+
+	if health.TotalPath != "" {
+		// Pods of the old template are still around while there are more
+		// pods than desired.
+		total, err := readCount(object, health.TotalPath)
+		if err != nil {
+			return false, err
+		}
+
+		if total > desired {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// conditionTrue reports whether the status condition of conditionType is True.
+func conditionTrue(object *unstructured.Unstructured, conditionType string) bool {
+	conditions, _, _ := unstructured.NestedSlice(object.Object, "status", "conditions")
+	for _, condition := range conditions {
+		conditionMap, ok := condition.(map[string]interface{})
+		if !ok || conditionMap["type"] != conditionType {
+			continue
+		}
+
+		return conditionMap["status"] == string(metav1.ConditionTrue)
+	}
+
+	return false
+}
+
+// readCount reads the number at the JSONPath path of object. A missing value
+// counts as zero, as the API server omits counts that are zero.
+func readCount(object *unstructured.Unstructured, path string) (int64, error) {
+	if !strings.HasPrefix(path, "{") {
+		path = fmt.Sprintf("{%s}", path)
+	}
+
+	parser := jsonpath.New("health").AllowMissingKeys(true)
+	err := parser.Parse(path)
+	if err != nil {
+		return 0, fmt.Errorf("%w. invalid health path: %s", err, path)
+	}
+
+	var buf bytes.Buffer
+	err = parser.Execute(&buf, object.Object)
+	if err != nil {
+		return 0, fmt.Errorf("%w. failed to read health path: %s", err, path)
+	}
+
+	value := strings.TrimSpace(buf.String())
+	if value == "" {
+		return 0, nil
+	}
+
+	count, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w. health path: %s is not a number", err, path)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,74 @@
+package clients
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/models"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+var _ = Describe("Health", func() {
+	health := models.Health{
+		ConditionType: "Available",
+		ReadyPath:     ".status.readyReplicas",
+		DesiredPath:   ".spec.replicas",
+		UpdatedPath:   "{.status.updatedReplicas}",
+	}
+
+	// newObject returns a workload of three replicas at generation 2 whose
+	// status is status.
+	newObject := func(status map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"generation": int64(2)},
+			"spec":     map[string]interface{}{"replicas": int64(3)},
+			"status":   status,
+		}}
+	}
+	available := []interface{}{map[string]interface{}{"type": "Available", "status": "True"}}
+
+	for _, tc := range []struct {
+		name    string
+		status  map[string]interface{}
+		healthy bool
+	}{
+		{
+			name:    "is healthy once every check passes",
+			status:  map[string]interface{}{"observedGeneration": int64(2), "readyReplicas": int64(3), "updatedReplicas": int64(3), "conditions": available},
+			healthy: true,
+		},
+		{
+			name:   "is not healthy before the latest generation is observed",
+			status: map[string]interface{}{"observedGeneration": int64(1), "readyReplicas": int64(3), "updatedReplicas": int64(3), "conditions": available},
+		},
+		{
+			name:   "is not healthy without the condition",
+			status: map[string]interface{}{"readyReplicas": int64(3), "updatedReplicas": int64(3)},
+		},
+		{
+			name:   "is not healthy with too few ready replicas",
+			status: map[string]interface{}{"readyReplicas": int64(2), "updatedReplicas": int64(3), "conditions": available},
+		},
+		{
+			name:   "is not healthy with too few updated replicas",
+			status: map[string]interface{}{"readyReplicas": int64(3), "updatedReplicas": int64(2), "conditions": available},
+		},
+		{
+			name:   "counts missing replicas as zero",
+			status: map[string]interface{}{"updatedReplicas": int64(3), "conditions": available},
+		},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			healthy, err := IsHealthy(newObject(tc.status), health)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(healthy).To(Equal(tc.healthy))
+		})
+	}
+
+	It("fails on paths that are not numbers", func() {
+		_, err := IsHealthy(newObject(map[string]interface{}{"readyReplicas": "all", "conditions": available}), health)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -2,20 +2,92 @@ package clients
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/anmolbabu/kraft-controller/utils"
-	appsv1 "k8s.io/api/apps/v1"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/models"
+	"github.com/anmolbabu/kraft-controller/tracing"
+
+	"go.opentelemetry.io/otel/trace"
+
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"os"
-	"crypto/sha256"
-	"time"
 )
 
+// onDeleteStrategy is the update strategy of StatefulSets and DaemonSets that
+// only replaces pods once they are deleted.
+const onDeleteStrategy = "OnDelete"
+
+// ErrCoalesced is returned for workloads that are not restarted because they
+// were restarted within the coalesce window already.
+var ErrCoalesced = errors.New("restarted within the coalesce window")
+
+// IsCoalesced reports whether err is, or wraps, ErrCoalesced.
+func IsCoalesced(err error) bool {
+	return errors.Is(err, ErrCoalesced)
+}
+
+// ErrNotDue is returned for workloads that are not restarted because none of
+// their pods is older than the max pod age.
+var ErrNotDue = errors.New("no pod exceeds the max pod age")
+
+// IsNotDue reports whether err is, or wraps, ErrNotDue.
+func IsNotDue(err error) bool {
+	return errors.Is(err, ErrNotDue)
+}
+
+// ErrDryRun is returned for workloads that would have been restarted, had the
+// run not been a dry run.
+var ErrDryRun = errors.New("would have been restarted in a dry run")
+
+// IsDryRun reports whether err is, or wraps, ErrDryRun.
+func IsDryRun(err error) bool {
+	return errors.Is(err, ErrDryRun)
+}
+
+// RestartOptions tunes how workloads are restarted.
+type RestartOptions struct {
+	// CoalesceWindow is how long after a restart a workload is not restarted
+	// again.
+	CoalesceWindow time.Duration
+	// MaxConcurrent is how many workloads are restarted at the same time, all
+	// of them when it is zero.
+	MaxConcurrent int
+	// RolloutTimeout is how long to wait for the rollout of a workload. See
+	// rolloutTimeout for what zero means.
+	RolloutTimeout time.Duration
+	// RollbackOnFailure restores the previous pod template of workloads whose
+	// rollout gets stuck.
+	RollbackOnFailure bool
+	// WaitForDisruptionBudgets waits for the PodDisruptionBudgets covering a
+	// workload to allow a disruption, rather than skipping the workload.
+	WaitForDisruptionBudgets bool
+	// Evict restarts workloads by evicting their pods one by one, rather
+	// than by patching their pod template.
+	Evict bool
+	// OrderByPodDeletionCost evicts the pods with the lowest pod deletion
+	// cost first.
+	OrderByPodDeletionCost bool
+	// MaxPodAge restarts only workloads that have a pod older than it, and
+	// evicts only those pods, when it is set.
+	MaxPodAge time.Duration
+	// DryRun checks everything a restart depends on, without waiting for
+	// PodDisruptionBudgets, but neither patches nor evicts anything.
+	DryRun bool
+}
+
 // Clientset abstracts the cluster config loading both locally and on Kubernetes
 func InitKubeClient() (*kubernetes.Clientset, error) {
 	// Try to load in-cluster config
@@ -31,48 +103,351 @@ func InitKubeClient() (*kubernetes.Clientset, error) {
 	return kubernetes.NewForConfig(config)
 }
 
-func (kraftClient *KraftClients) PatchDeployments(deployments map[string]appsv1.Deployment) utils.MultiError {
-	errCh := make(chan error)
-	defer close(errCh)
+// RestartProgress is told about every restart of RestartWorkloads as it
+// starts and finishes. It is called from several goroutines at once.
+type RestartProgress interface {
+	Started(workload models.Workload)
+	Finished(workload models.Workload, err error)
+}
 
-	var multiErr utils.MultiError
+// RestartWorkloads restarts workloads in the given order, at most
+// options.MaxConcurrent of them at a time, and returns the result of each
+// restart keyed by models.Workload.Key. A restart only makes room for the next
+// one once its rollout is complete. When a rollout gets stuck, the workloads
+// that were not started yet are not restarted and get ErrRunStopped. Workloads
+// that were not started by the time ctx is done are left out of the results.
+func (kraftClient *KraftClients) RestartWorkloads(ctx context.Context, workloads []models.Workload, options RestartOptions, progress RestartProgress) map[string]error {
+	limit := options.MaxConcurrent
+	if limit <= 0 || limit > len(workloads) {
+		limit = len(workloads)
+	}
+	slots := make(chan struct{}, limit)
 
-	for _, currDepl := range deployments {
-		go func(currDepl appsv1.Deployment) {
-			deploymentJSON, err := json.Marshal(currDepl)
-			if err != nil {
-				errCh <- fmt.Errorf("%w. failed to marshalling the deployment: %s in namespace: %s while unmarshalling the deployment object", err, currDepl.Name, currDepl.Namespace)
-				return
-			}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var stuck *models.Workload
+	results := make(map[string]error, len(workloads))
+
+dispatch:
+	for _, workload := range workloads {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			break dispatch
+		}
 
-			sum := sha256.Sum256([]byte(deploymentJSON))
-
-			patchData := make(map[string]interface{})
-			patchData["spec"] = map[string]interface{}{
-				"template": map[string]interface{}{
-					"metadata": map[string]interface{}{
-						"annotations": map[string]interface{}{
-							"flipper.io/deployment-restart-time": time.Now().Format(time.UnixDate),
-							"flipper.io/deployment-hash": string(sum[:]),
-						},
-					},
-				},
+		lock.Lock()
+		if stuck != nil {
+			results[workload.Key()] = fmt.Errorf("%w. the rollout of %s: %s in namespace: %s got stuck", ErrRunStopped, stuck.Type.Kind, stuck.Name, stuck.Namespace)
+			lock.Unlock()
+			<-slots
+			continue
+		}
+		lock.Unlock()
+
+		if progress != nil {
+			progress.Started(workload)
+		}
+
+		wg.Add(1)
+		go func(workload models.Workload) {
+			defer wg.Done()
+
+			err := kraftClient.RestartWorkload(ctx, workload, options)
+
+			lock.Lock()
+			results[workload.Key()] = err
+			if IsRolloutStuck(err) && stuck == nil {
+				stuck = &workload
 			}
+			lock.Unlock()
 
-			encodedData, err := json.Marshal(patchData)
-			if err != nil {
-				errCh <- fmt.Errorf("%w. failed to restart deployment: %s in namespace: %s", err, currDepl.Name, currDepl.Namespace)
-				return
+			if progress != nil {
+				progress.Finished(workload, err)
 			}
+			<-slots
+		}(workload)
+	}
+
+	wg.Wait()
 
-			_, err =  kraftClient.kubeClient.AppsV1().Deployments(currDepl.Namespace).Patch(context.Background(), currDepl.Name, types.MergePatchType, encodedData, metav1.PatchOptions{})
-			errCh <- err
-		}(currDepl)
+	return results
+}
+
+// RestartWorkload restarts a single workload of any kind through the dynamic
+// client and waits for its rollout to complete. Workloads that roll out on
+// their own get a new pod template annotation, which leaves the pods below the
+// partition of a StatefulSet alone. Workloads with an OnDelete strategy have
+// their pods deleted one by one on top of that. Workloads restarted within the
+// coalesce window are left alone and ErrCoalesced is returned, and workloads
+// whose PodDisruptionBudgets do not allow a disruption ErrDisruptionBudget.
+// Workloads whose rollout gets stuck are rolled back when
+// options.RollbackOnFailure is set. With options.Evict, the pods are evicted
+// instead, see evictWorkload, and with options.MaxPodAge only workloads with
+// old enough pods are restarted, see restartThroughPods. With options.DryRun,
+// ErrDryRun is returned for workloads that pass every check instead. Each
+// restart is traced in a child span of ctx.
+func (kraftClient *KraftClients) RestartWorkload(ctx context.Context, workload models.Workload, options RestartOptions) error {
+	ctx, span := tracing.Tracer().Start(ctx, "RestartWorkload", trace.WithAttributes(WorkloadAttributes(workload)...))
+	err := kraftClient.restartWorkload(ctx, workload, options)
+	endRestartSpan(span, err)
+
+	return err
+}
+
+// restartWorkload restarts workload as RestartWorkload describes.
+func (kraftClient *KraftClients) restartWorkload(ctx context.Context, workload models.Workload, options RestartOptions) error {
+	resourceClient, err := kraftClient.resourceFor(workload)
+	if err != nil {
+		return err
+	}
+
+	object, err := resourceClient.Get(ctx, workload.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("%w. failed to fetch %s: %s in namespace: %s", err, workload.Type.Kind, workload.Name, workload.Namespace)
+	}
+
+	lastRestart, ok := lastRestartTime(object, workload.Type)
+	if ok && time.Since(lastRestart) < options.CoalesceWindow {
+		return fmt.Errorf("%w. %s: %s in namespace: %s was restarted at %s", ErrCoalesced, workload.Type.Kind, workload.Name, workload.Namespace, lastRestart.Format(time.RFC3339))
+	}
+
+	if options.MaxPodAge > 0 || options.Evict {
+		return kraftClient.restartThroughPods(ctx, resourceClient, workload, object, options)
+	}
+
+	return kraftClient.patchWorkload(ctx, resourceClient, workload, object, options)
+}
+
+// restartThroughPods restarts object depending on the age of its pods, or by
+// evicting them. With options.MaxPodAge, only workloads with pods older than
+// it are restarted, and only those pods are evicted, while ErrNotDue is
+// returned for the others.
+func (kraftClient *KraftClients) restartThroughPods(ctx context.Context, resourceClient dynamic.ResourceInterface, workload models.Workload, object *unstructured.Unstructured, options RestartOptions) error {
+	selector, found, err := workloadSelector(workload, object)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%s: %s in namespace: %s has no selector to find its pods by", workload.Type.Kind, workload.Name, workload.Namespace)
 	}
 
-	for idx := 0; idx < len(deployments); idx++ {
-		(&multiErr).AppendError(<- errCh)
+	pods, err := kraftClient.selectedPods(ctx, workload.Namespace, selector)
+	if err != nil {
+		return fmt.Errorf("%w. failed to list pods in namespace: %s", err, workload.Namespace)
 	}
 
-	return multiErr
-}
\ No newline at end of file
+	if options.MaxPodAge > 0 {
+		pods = podsOlderThan(pods, time.Now().Add(-options.MaxPodAge))
+		if len(pods) == 0 {
+			return fmt.Errorf("%w. %s: %s in namespace: %s has no pod older than %s", ErrNotDue, workload.Type.Kind, workload.Name, workload.Namespace, options.MaxPodAge)
+		}
+	}
+
+	if options.Evict {
+		// Evictions honour the budgets on their own, but only by waiting for
+		// them. Checking first skips blocked workloads unless the policy is to
+		// wait, and tells dry runs about them.
+		err := traced(ctx, "CheckDisruptionBudgets", func(ctx context.Context) error {
+			return kraftClient.checkDisruptionBudgets(ctx, workload, object, options)
+		})
+		if err != nil {
+			return err
+		}
+
+		if options.DryRun {
+			return dryRunError(workload)
+		}
+		return traced(ctx, "EvictPods", func(ctx context.Context) error {
+			return kraftClient.evictWorkload(ctx, resourceClient, workload, pods, options)
+		})
+	}
+
+	return kraftClient.patchWorkload(ctx, resourceClient, workload, object, options)
+}
+
+// patchWorkload restarts object by patching its pod template and waits for the
+// rollout to complete.
+func (kraftClient *KraftClients) patchWorkload(ctx context.Context, resourceClient dynamic.ResourceInterface, workload models.Workload, object *unstructured.Unstructured, options RestartOptions) error {
+	// Pods of workloads with an OnDelete strategy are evicted, which honours
+	// the budgets on its own.
+	strategy, _, _ := unstructured.NestedString(object.Object, "spec", "updateStrategy", "type")
+	if strategy != onDeleteStrategy {
+		err := traced(ctx, "CheckDisruptionBudgets", func(ctx context.Context) error {
+			return kraftClient.checkDisruptionBudgets(ctx, workload, object, options)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if options.DryRun {
+		return dryRunError(workload)
+	}
+
+	var generation int64
+	err := traced(ctx, "PatchTemplate", func(ctx context.Context) error {
+		var err error
+		generation, err = kraftClient.patchTemplate(ctx, resourceClient, workload, object)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if strategy == onDeleteStrategy {
+		err = traced(ctx, "EvictPods", func(ctx context.Context) error {
+			return kraftClient.recycle(ctx, workload, object)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = traced(ctx, "WaitForRollout", func(ctx context.Context) error {
+		return kraftClient.waitForRollout(ctx, resourceClient, workload, generation, rolloutTimeout(object, options))
+	})
+	if IsRolloutStuck(err) && options.RollbackOnFailure {
+		return traced(ctx, "Rollback", func(ctx context.Context) error {
+			return kraftClient.rollback(ctx, resourceClient, workload, object, err)
+		})
+	}
+
+	return err
+}
+
+// dryRunError returns the ErrDryRun for workload, which records when it would
+// have been restarted.
+func dryRunError(workload models.Workload) error {
+	return fmt.Errorf("%w. %s: %s in namespace: %s would have been restarted at %s", ErrDryRun, workload.Type.Kind, workload.Name, workload.Namespace, time.Now().Format(time.RFC3339))
+}
+
+// recycle evicts the pods of object, a workload with an OnDelete strategy, one
+// at a time, so that they are replaced with the restarted template.
+func (kraftClient *KraftClients) recycle(ctx context.Context, workload models.Workload, object *unstructured.Unstructured) error {
+	selector, found, err := workloadSelector(workload, object)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%s: %s in namespace: %s has an OnDelete strategy but no selector", workload.Type.Kind, workload.Name, workload.Namespace)
+	}
+
+	pods, err := kraftClient.ownedPods(ctx, object.GetUID(), workload.Namespace, selector)
+	if err != nil {
+		return fmt.Errorf("%w. failed to list pods in namespace: %s", err, workload.Namespace)
+	}
+
+	return kraftClient.recyclePods(ctx, pods, func() (bool, error) {
+		return kraftClient.Available(ctx, workload)
+	})
+}
+
+// evictWorkload restarts workload by evicting pods, its own, one at a time,
+// which leaves its pod template, and so its rollout history, alone. Each pod
+// has to be replaced by a ready one before the next is evicted. The time of
+// the restart goes into the annotations of the workload itself.
+func (kraftClient *KraftClients) evictWorkload(ctx context.Context, resourceClient dynamic.ResourceInterface, workload models.Workload, pods []corev1.Pod, options RestartOptions) error {
+	if options.OrderByPodDeletionCost {
+		sortByDeletionCost(pods)
+	}
+
+	encodedData, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": restartAnnotations(ctx, time.Now()),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("%w. failed to restart %s: %s in namespace: %s", err, workload.Type.Kind, workload.Name, workload.Namespace)
+	}
+
+	_, err = resourceClient.Patch(ctx, workload.Name, types.MergePatchType, encodedData, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("%w. failed to restart %s: %s in namespace: %s", err, workload.Type.Kind, workload.Name, workload.Namespace)
+	}
+
+	return kraftClient.recyclePods(ctx, pods, func() (bool, error) {
+		return kraftClient.Available(ctx, workload)
+	})
+}
+
+// resourceFor returns a client for the resource of workload.
+func (kraftClient *KraftClients) resourceFor(workload models.Workload) (dynamic.ResourceInterface, error) {
+	gvk := workload.Type.GroupVersionKind()
+
+	mapping, err := kraftClient.restMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, fmt.Errorf("%w. unknown workload kind: %s", err, gvk.String())
+	}
+
+	return kraftClient.dynamicClient.Resource(mapping.Resource).Namespace(workload.Namespace), nil
+}
+
+// patchTemplate stamps the restart annotations into the pod template of
+// object, which rolls out its pods, and returns the generation of the patched
+// object.
+func (kraftClient *KraftClients) patchTemplate(ctx context.Context, resourceClient dynamic.ResourceInterface, workload models.Workload, object *unstructured.Unstructured) (int64, error) {
+	objectJSON, err := object.MarshalJSON()
+	if err != nil {
+		return 0, fmt.Errorf("%w. failed to marshal %s: %s in namespace: %s", err, workload.Type.Kind, workload.Name, workload.Namespace)
+	}
+
+	sum := sha256.Sum256(objectJSON)
+
+	annotations := restartAnnotations(ctx, time.Now())
+	annotations[HashAnnotation] = fmt.Sprintf("%x", sum)
+
+	var patchData interface{} = annotations
+
+	// Nest the annotations along the path, innermost field first.
+	fields := templateAnnotationsFields(workload.Type)
+	for idx := len(fields) - 1; idx >= 0; idx-- {
+		patchData = map[string]interface{}{fields[idx]: patchData}
+	}
+
+	encodedData, err := json.Marshal(patchData)
+	if err != nil {
+		return 0, fmt.Errorf("%w. failed to restart %s: %s in namespace: %s", err, workload.Type.Kind, workload.Name, workload.Namespace)
+	}
+
+	patched, err := resourceClient.Patch(ctx, workload.Name, types.MergePatchType, encodedData, metav1.PatchOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("%w. failed to restart %s: %s in namespace: %s", err, workload.Type.Kind, workload.Name, workload.Namespace)
+	}
+
+	return patched.GetGeneration(), nil
+}
+
+// templateAnnotationsFields splits the pod template annotations path of
+// workloadType into its fields.
+func templateAnnotationsFields(workloadType models.WorkloadType) []string {
+	path := workloadType.TemplateAnnotationsPath
+	if path == "" {
+		path = models.DefaultTemplateAnnotationsPath
+	}
+
+	return strings.Split(strings.TrimPrefix(path, "."), ".")
+}
+
+// lastRestartTime reads the time of the last restart from the pod template
+// annotations of object, or from its own annotations for restarts by
+// eviction, whichever is later.
+func lastRestartTime(object *unstructured.Unstructured, workloadType models.WorkloadType) (time.Time, bool) {
+	var lastRestart time.Time
+	for _, fields := range [][]string{
+		append(templateAnnotationsFields(workloadType), RestartTimeAnnotation),
+		{"metadata", "annotations", RestartTimeAnnotation},
+	} {
+		value, found, err := unstructured.NestedString(object.Object, fields...)
+		if err != nil || !found {
+			continue
+		}
+
+		restartTime, ok := parseRestartAnnotation(value)
+		if ok && restartTime.After(lastRestart) {
+			lastRestart = restartTime
+		}
+	}
+
+	return lastRestart, !lastRestart.IsZero()
+}
@@ -0,0 +1,429 @@
+package clients
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/models"
+
+	"go.opentelemetry.io/otel/trace"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+// newDeployment returns a Deployment that has completed its rollout.
+func newDeployment(name string, mutate func(*appsv1.Deployment)) *appsv1.Deployment {
+	replicas := int32(1)
+	labels := map[string]string{"app": name}
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: name, UID: types.UID("uid-" + name)},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+		},
+		Status: appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+	}
+	if mutate != nil {
+		mutate(deployment)
+	}
+
+	return deployment
+}
+
+// newStatefulSet returns a StatefulSet of two replicas that has completed its
+// rollout.
+func newStatefulSet(name string, mutate func(*appsv1.StatefulSet)) *appsv1.StatefulSet {
+	replicas := int32(2)
+	labels := map[string]string{"app": name}
+	statefulSet := &appsv1.StatefulSet{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: models.KindStatefulSet},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: name, UID: types.UID("uid-" + name)},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+		},
+		Status: appsv1.StatefulSetStatus{Replicas: 2, ReadyReplicas: 2, UpdatedReplicas: 2},
+	}
+	if mutate != nil {
+		mutate(statefulSet)
+	}
+
+	return statefulSet
+}
+
+// newDaemonSet returns a DaemonSet on two nodes that has completed its
+// rollout.
+func newDaemonSet(name string) *appsv1.DaemonSet {
+	labels := map[string]string{"app": name}
+	return &appsv1.DaemonSet{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: models.KindDaemonSet},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: name, UID: types.UID("uid-" + name)},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+		},
+		Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 2, NumberAvailable: 2, UpdatedNumberScheduled: 2},
+	}
+}
+
+// stuck makes the rollout of deployment exceed its progress deadline.
+func stuck(deployment *appsv1.Deployment) {
+	deployment.Status.Conditions = []appsv1.DeploymentCondition{{
+		Type:   appsv1.DeploymentProgressing,
+		Status: corev1.ConditionFalse,
+		Reason: progressDeadlineExceeded,
+	}}
+}
+
+// newTestClients returns KraftClients serving deployments through the dynamic
+// client and objects through the typed one.
+func newTestClients(deployments []*appsv1.Deployment, objects ...runtime.Object) (*KraftClients, *dynamicfake.FakeDynamicClient) {
+	var workloads []runtime.Object
+	for _, deployment := range deployments {
+		workloads = append(workloads, deployment)
+	}
+
+	return newWorkloadClients(workloads, objects...)
+}
+
+// newWorkloadClients returns KraftClients serving workloads of the built in
+// kinds through the dynamic client and objects through the typed one.
+func newWorkloadClients(workloads []runtime.Object, objects ...runtime.Object) (*KraftClients, *dynamicfake.FakeDynamicClient) {
+	scheme := runtime.NewScheme()
+	Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(scheme, workloads...)
+
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	for _, kind := range []string{models.KindDeployment, models.KindStatefulSet, models.KindDaemonSet} {
+		restMapper.Add(appsv1.SchemeGroupVersion.WithKind(kind), meta.RESTScopeNamespace)
+	}
+
+	return NewKraftClients(kubefake.NewSimpleClientset(objects...), dynamicClient, restMapper), dynamicClient
+}
+
+// templateAnnotations reads the pod template annotations of the Deployment
+// called name.
+func templateAnnotations(dynamicClient *dynamicfake.FakeDynamicClient, name string) map[string]string {
+	return resourceTemplateAnnotations(dynamicClient, "deployments", name)
+}
+
+// resourceTemplateAnnotations reads the pod template annotations of the
+// apps/v1 workload of resource called name.
+func resourceTemplateAnnotations(dynamicClient *dynamicfake.FakeDynamicClient, resource string, name string) map[string]string {
+	object, err := dynamicClient.Resource(appsv1.SchemeGroupVersion.WithResource(resource)).Namespace("apps").Get(context.Background(), name, metav1.GetOptions{})
+	Expect(err).NotTo(HaveOccurred())
+
+	annotations, _, err := unstructured.NestedStringMap(object.Object, "spec", "template", "metadata", "annotations")
+	Expect(err).NotTo(HaveOccurred())
+
+	return annotations
+}
+
+func deploymentWorkload(name string) models.Workload {
+	return models.Workload{Type: models.BuiltinWorkloadTypes[models.KindDeployment], Namespace: "apps", Name: name}
+}
+
+// progressRecorder records the restarts of a run, and how many of them were
+// in progress at the same time at most.
+type progressRecorder struct {
+	lock          sync.Mutex
+	inProgress    int
+	maxInProgress int
+	finished      []string
+}
+
+func (recorder *progressRecorder) Started(workload models.Workload) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	recorder.inProgress++
+	if recorder.inProgress > recorder.maxInProgress {
+		recorder.maxInProgress = recorder.inProgress
+	}
+}
+
+func (recorder *progressRecorder) Finished(workload models.Workload, err error) {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	recorder.inProgress--
+	recorder.finished = append(recorder.finished, workload.Name)
+}
+
+var _ = Describe("Restarts", func() {
+	ctx := context.Background()
+
+	It("restarts deployments by stamping their pod template", func() {
+		kraftClients, dynamicClient := newTestClients([]*appsv1.Deployment{newDeployment("web", nil)})
+
+		Expect(kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{})).To(Succeed())
+
+		annotations := templateAnnotations(dynamicClient, "web")
+		Expect(annotations).To(HaveKey(RestartTimeAnnotation))
+		Expect(annotations).To(HaveKey(HashAnnotation))
+		_, err := time.Parse(time.UnixDate, annotations[RestartTimeAnnotation])
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("stamps the trace ID of traced restarts after the restart time", func() {
+		traceID := trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+		tracedCtx := trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID}))
+		kraftClients, dynamicClient := newTestClients([]*appsv1.Deployment{newDeployment("web", nil)})
+
+		Expect(kraftClients.RestartWorkload(tracedCtx, deploymentWorkload("web"), RestartOptions{})).To(Succeed())
+
+		value := templateAnnotations(dynamicClient, "web")[RestartTimeAnnotation]
+		Expect(value).To(HaveSuffix("; trace-id=4bf92f3577b34da6a3ce929d0e0e4736"))
+		_, err := time.Parse(time.UnixDate, strings.TrimSuffix(value, "; trace-id=4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(err).NotTo(HaveOccurred())
+
+		err = kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{CoalesceWindow: time.Hour})
+		Expect(IsCoalesced(err)).To(BeTrue(), "%v", err)
+	})
+
+	It("leaves deployments restarted within the coalesce window alone", func() {
+		restarted := time.Now().Add(-time.Minute).Format(time.UnixDate)
+		kraftClients, dynamicClient := newTestClients([]*appsv1.Deployment{newDeployment("web", func(deployment *appsv1.Deployment) {
+			deployment.Spec.Template.Annotations = map[string]string{RestartTimeAnnotation: restarted}
+		})})
+
+		err := kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{CoalesceWindow: time.Hour})
+		Expect(IsCoalesced(err)).To(BeTrue(), "%v", err)
+		Expect(templateAnnotations(dynamicClient, "web")).To(Equal(map[string]string{RestartTimeAnnotation: restarted}))
+
+		Expect(kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{CoalesceWindow: time.Second})).To(Succeed())
+	})
+
+	It("patches nothing in a dry run", func() {
+		kraftClients, dynamicClient := newTestClients([]*appsv1.Deployment{newDeployment("web", nil)})
+
+		err := kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{DryRun: true})
+		Expect(IsDryRun(err)).To(BeTrue(), "%v", err)
+		Expect(templateAnnotations(dynamicClient, "web")).To(BeEmpty())
+	})
+
+	It("reports deployments that exceed their progress deadline as stuck", func() {
+		kraftClients, _ := newTestClients([]*appsv1.Deployment{newDeployment("web", stuck)})
+
+		err := kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{})
+		Expect(IsRolloutStuck(err)).To(BeTrue(), "%v", err)
+		Expect(IsRolledBack(err)).To(BeFalse())
+	})
+
+	for _, tc := range []struct {
+		name          string
+		maxConcurrent int
+		stuck         string
+		results       map[string]func(error) bool
+		maxInProgress int
+	}{
+		{
+			name:          "restarts every workload at once by default",
+			results:       map[string]func(error) bool{"a": succeeded, "b": succeeded, "c": succeeded},
+			maxInProgress: 3,
+		},
+		{
+			name:          "restarts at most maxConcurrent workloads at a time",
+			maxConcurrent: 2,
+			results:       map[string]func(error) bool{"a": succeeded, "b": succeeded, "c": succeeded},
+			maxInProgress: 2,
+		},
+		{
+			name:          "stops a sequential run once a rollout gets stuck",
+			maxConcurrent: 1,
+			stuck:         "a",
+			results:       map[string]func(error) bool{"a": IsRolloutStuck, "b": IsRunStopped, "c": IsRunStopped},
+			maxInProgress: 1,
+		},
+		{
+			name:          "finishes the restarts in progress when a rollout gets stuck",
+			maxConcurrent: 1,
+			stuck:         "c",
+			results:       map[string]func(error) bool{"a": succeeded, "b": succeeded, "c": IsRolloutStuck},
+			maxInProgress: 1,
+		},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			var deployments []*appsv1.Deployment
+			var workloads []models.Workload
+			for _, name := range []string{"a", "b", "c"} {
+				deployment := newDeployment(name, nil)
+				if name == tc.stuck {
+					stuck(deployment)
+				}
+				deployments = append(deployments, deployment)
+				workloads = append(workloads, deploymentWorkload(name))
+			}
+			kraftClients, _ := newTestClients(deployments)
+
+			recorder := &progressRecorder{}
+			results := kraftClients.RestartWorkloads(ctx, workloads, RestartOptions{MaxConcurrent: tc.maxConcurrent}, recorder)
+
+			Expect(results).To(HaveLen(len(tc.results)))
+			for name, check := range tc.results {
+				err := results[deploymentWorkload(name).Key()]
+				Expect(check(err)).To(BeTrue(), "%s: %v", name, err)
+			}
+			Expect(recorder.maxInProgress).To(BeNumerically("<=", tc.maxInProgress))
+			Expect(recorder.inProgress).To(BeZero())
+		})
+	}
+})
+
+func succeeded(err error) bool {
+	return err == nil
+}
+
+var _ = Describe("Update strategies", func() {
+	ctx := context.Background()
+
+	workloadOf := func(kind string, name string) models.Workload {
+		return models.Workload{Type: models.BuiltinWorkloadTypes[kind], Namespace: "apps", Name: name}
+	}
+
+	It("restarts daemon sets by stamping their pod template", func() {
+		kraftClients, dynamicClient := newWorkloadClients([]runtime.Object{newDaemonSet("agent")})
+
+		Expect(kraftClients.RestartWorkload(ctx, workloadOf(models.KindDaemonSet, "agent"), RestartOptions{})).To(Succeed())
+		Expect(resourceTemplateAnnotations(dynamicClient, "daemonsets", "agent")).To(HaveKey(RestartTimeAnnotation))
+	})
+
+	for _, tc := range []struct {
+		name      string
+		replicas  int32
+		partition int32
+		updated   int32
+		stuck     bool
+	}{
+		{name: "leaves the pods of stateful sets below their partition alone", replicas: 3, partition: 2, updated: 1},
+		{name: "waits for the pods of stateful sets above their partition", replicas: 3, partition: 1, updated: 1, stuck: true},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			statefulSet := newStatefulSet("db", func(statefulSet *appsv1.StatefulSet) {
+				statefulSet.Spec.Replicas = &tc.replicas
+				statefulSet.Spec.UpdateStrategy = appsv1.StatefulSetUpdateStrategy{
+					Type:          appsv1.RollingUpdateStatefulSetStrategyType,
+					RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &tc.partition},
+				}
+				statefulSet.Status = appsv1.StatefulSetStatus{Replicas: tc.replicas, ReadyReplicas: tc.replicas, UpdatedReplicas: tc.updated}
+			})
+			kraftClients, dynamicClient := newWorkloadClients([]runtime.Object{statefulSet})
+			evictions := recordEvictions(kraftClients)
+
+			err := kraftClients.RestartWorkload(ctx, workloadOf(models.KindStatefulSet, "db"), RestartOptions{RolloutTimeout: 100 * time.Millisecond})
+			if tc.stuck {
+				Expect(IsRolloutStuck(err)).To(BeTrue(), "%v", err)
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(resourceTemplateAnnotations(dynamicClient, "statefulsets", "db")).To(HaveKey(RestartTimeAnnotation))
+			Expect(evictions.pods()).To(BeEmpty())
+		})
+	}
+
+	It("replaces the pods of stateful sets with an OnDelete strategy one by one", func() {
+		statefulSet := newStatefulSet("db", func(statefulSet *appsv1.StatefulSet) {
+			statefulSet.Spec.UpdateStrategy = appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType}
+		})
+		started := time.Now().Add(-time.Hour)
+		kraftClients, dynamicClient := newWorkloadClients(
+			[]runtime.Object{statefulSet},
+			newPod("db-0", "db", statefulSet, started),
+			newPod("db-1", "db", statefulSet, started),
+			newPod("db-backup", "db", nil, started),
+		)
+		evictions := recordEvictions(kraftClients)
+
+		Expect(kraftClients.RestartWorkload(ctx, workloadOf(models.KindStatefulSet, "db"), RestartOptions{})).To(Succeed())
+		Expect(resourceTemplateAnnotations(dynamicClient, "statefulsets", "db")).To(HaveKey(RestartTimeAnnotation))
+		Expect(evictions.pods()).To(Equal([]string{"db-1", "db-0"}))
+	})
+})
+
+var _ = Describe("Custom workload kinds", func() {
+	ctx := context.Background()
+	workerType := models.WorkloadType{
+		APIVersion:              "platform.example.com/v1",
+		Kind:                    "Worker",
+		TemplateAnnotationsPath: ".spec.podTemplate.metadata.annotations",
+		Health: models.Health{
+			ConditionType: "Available",
+			ReadyPath:     ".status.readyReplicas",
+			DesiredPath:   ".spec.replicas",
+		},
+	}
+	worker := models.Workload{Type: workerType, Namespace: "apps", Name: "queue"}
+
+	// newWorkerClients returns KraftClients serving a Worker called queue
+	// whose Available condition has the given status.
+	newWorkerClients := func(available string) (*KraftClients, *dynamicfake.FakeDynamicClient) {
+		object := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": workerType.APIVersion,
+			"kind":       workerType.Kind,
+			"metadata":   map[string]interface{}{"namespace": "apps", "name": "queue"},
+			"spec":       map[string]interface{}{"replicas": int64(2)},
+			"status": map[string]interface{}{
+				"readyReplicas": int64(2),
+				"conditions":    []interface{}{map[string]interface{}{"type": "Available", "status": available}},
+			},
+		}}
+		dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), object)
+
+		restMapper := meta.NewDefaultRESTMapper(nil)
+		restMapper.Add(workerType.GroupVersionKind(), meta.RESTScopeNamespace)
+
+		return NewKraftClients(kubefake.NewSimpleClientset(), dynamicClient, restMapper), dynamicClient
+	}
+
+	annotationsOf := func(dynamicClient *dynamicfake.FakeDynamicClient) map[string]string {
+		object, err := dynamicClient.Resource(workerType.GroupVersionKind().GroupVersion().WithResource("workers")).Namespace("apps").Get(ctx, "queue", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		annotations, _, err := unstructured.NestedStringMap(object.Object, "spec", "podTemplate", "metadata", "annotations")
+		Expect(err).NotTo(HaveOccurred())
+
+		return annotations
+	}
+
+	It("stamps the pod template at the path of the kind", func() {
+		kraftClients, dynamicClient := newWorkerClients("True")
+
+		Expect(kraftClients.RestartWorkload(ctx, worker, RestartOptions{})).To(Succeed())
+		Expect(annotationsOf(dynamicClient)).To(HaveKey(RestartTimeAnnotation))
+	})
+
+	It("reads the health of the kind from its status", func() {
+		kraftClients, dynamicClient := newWorkerClients("False")
+
+		err := kraftClients.RestartWorkload(ctx, worker, RestartOptions{RolloutTimeout: 100 * time.Millisecond})
+		Expect(IsRolloutStuck(err)).To(BeTrue(), "%v", err)
+		Expect(annotationsOf(dynamicClient)).To(HaveKey(RestartTimeAnnotation))
+	})
+
+	It("fails to restart kinds the API server does not serve", func() {
+		kraftClients, _ := newWorkerClients("True")
+
+		unknown := worker
+		unknown.Type.Kind = "Job"
+		Expect(kraftClients.RestartWorkload(ctx, unknown, RestartOptions{})).To(MatchError(ContainSubstring("unknown workload kind")))
+	})
+})
@@ -1,17 +1,52 @@
 package clients
 
-import "k8s.io/client-go/kubernetes"
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// RestartTimeAnnotation is stamped into the pod template of a restarted
+	// workload with the time of the restart, followed by the ID of the trace
+	// of the run that restarted it when the run is traced.
+	RestartTimeAnnotation = "flipper.io/deployment-restart-time"
+	// HashAnnotation is stamped into the pod template of a restarted workload
+	// with a hash of the workload at the time of the restart.
+	HashAnnotation = "flipper.io/deployment-hash"
+	// RolledBackAnnotation is set on a workload that was rolled back after a
+	// restart left it unhealthy, with the time of the rollback. The workload
+	// is not restarted again until the annotation is removed.
+	RolledBackAnnotation = "flipper.io/rolled-back"
+	// ConfigHashAnnotation is set on Deployments reloaded on config changes,
+	// and stamped into their pod template once it changes, with a hash of the
+	// ConfigMaps and Secrets the pod template references.
+	ConfigHashAnnotation = "flipper.io/config-hash"
+	// DryRunHashAnnotation is set on Deployments whose config change a dry
+	// run reported as one it would have rolled out, with the changed hash, so
+	// that the change is only reported once.
+	DryRunHashAnnotation = "flipper.io/dry-run-hash"
+	// DeferredHashAnnotation is set on Deployments whose rollout for a config
+	// change was deferred, until their Flipper is resumed, a window opens,
+	// their rollback is acknowledged or their budgets allow a disruption,
+	// with the changed hash, so that the deferral is only reported once.
+	DeferredHashAnnotation = "flipper.io/deferred-config-hash"
+)
 
 type KraftClients struct {
-	kubeClient kubernetes.Interface
+	kubeClient    kubernetes.Interface
+	dynamicClient dynamic.Interface
+	restMapper    meta.RESTMapper
 }
 
-func NewKraftClients(kubeClient kubernetes.Interface) (*KraftClients) {
+func NewKraftClients(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface, restMapper meta.RESTMapper) *KraftClients {
 	return &KraftClients{
-		kubeClient: kubeClient,
+		kubeClient:    kubeClient,
+		dynamicClient: dynamicClient,
+		restMapper:    restMapper,
 	}
 }
 
 func (kraftClients *KraftClients) GetKubeClient() kubernetes.Interface {
 	return kraftClients.kubeClient
-}
\ No newline at end of file
+}
@@ -0,0 +1,204 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/models"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// podPollInterval is how often a replaced pod is checked for readiness.
+	podPollInterval = 5 * time.Second
+	// podReadyTimeout bounds the wait for a replaced pod to become ready.
+	podReadyTimeout = 10 * time.Minute
+
+	// PodDeletionCostAnnotation ranks the pods of a ReplicaSet for deletion,
+	// the lowest cost going first.
+	PodDeletionCostAnnotation = "controller.kubernetes.io/pod-deletion-cost"
+)
+
+// ownedPods returns the pods in namespace matching selector that are
+// controlled by the object with the given UID, highest ordinal first.
+func (kraftClient *KraftClients) ownedPods(ctx context.Context, ownerUID types.UID, namespace string, selector *metav1.LabelSelector) ([]corev1.Pod, error) {
+	selected, err := kraftClient.selectedPods(ctx, namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []corev1.Pod
+	for _, pod := range selected {
+		owner := metav1.GetControllerOf(&pod)
+		if owner != nil && owner.UID == ownerUID {
+			pods = append(pods, pod)
+		}
+	}
+
+	return pods, nil
+}
+
+// selectedPods returns the pods in namespace matching selector that are not
+// being deleted already, highest ordinal first.
+func (kraftClient *KraftClients) selectedPods(ctx context.Context, namespace string, selector *metav1.LabelSelector) ([]corev1.Pod, error) {
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	podList, err := kraftClient.kubeClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []corev1.Pod
+	for _, pod := range podList.Items {
+		if pod.DeletionTimestamp == nil {
+			pods = append(pods, pod)
+		}
+	}
+
+	// StatefulSets replace their pods from the highest ordinal down, recycle
+	// them in the same order.
+	sort.Slice(pods, func(i, j int) bool {
+		return podOrdinal(pods[i]) > podOrdinal(pods[j])
+	})
+
+	return pods, nil
+}
+
+// sortByDeletionCost orders pods by their pod deletion cost, lowest first, as
+// a ReplicaSet scaling down would. Pods without a valid cost cost 0.
+func sortByDeletionCost(pods []corev1.Pod) {
+	cost := func(pod corev1.Pod) int64 {
+		value, err := strconv.ParseInt(pod.Annotations[PodDeletionCostAnnotation], 10, 32)
+		if err != nil {
+			return 0
+		}
+		return value
+	}
+
+	sort.SliceStable(pods, func(i, j int) bool {
+		return cost(pods[i]) < cost(pods[j])
+	})
+}
+
+// podsOlderThan returns the pods of pods that started before cutoff. Pods that
+// have not started yet are never too old.
+func podsOlderThan(pods []corev1.Pod, cutoff time.Time) []corev1.Pod {
+	var old []corev1.Pod
+	for _, pod := range pods {
+		if pod.Status.StartTime != nil && pod.Status.StartTime.Time.Before(cutoff) {
+			old = append(old, pod)
+		}
+	}
+
+	return old
+}
+
+// workloadSelector returns the pod selector of object, and false when it has
+// none.
+func workloadSelector(workload models.Workload, object *unstructured.Unstructured) (*metav1.LabelSelector, bool, error) {
+	selectorMap, found, err := unstructured.NestedMap(object.Object, "spec", "selector")
+	if err != nil || !found {
+		return nil, false, nil
+	}
+
+	selector := &metav1.LabelSelector{}
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(selectorMap, selector)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w. invalid selector of %s: %s in namespace: %s", err, workload.Type.Kind, workload.Name, workload.Namespace)
+	}
+
+	return selector, true, nil
+}
+
+// podOrdinal returns the ordinal of a StatefulSet pod, or -1 for other pods.
+func podOrdinal(pod corev1.Pod) int {
+	idx := strings.LastIndex(pod.Name, "-")
+	if idx < 0 {
+		return -1
+	}
+
+	ordinal, err := strconv.Atoi(pod.Name[idx+1:])
+	if err != nil {
+		return -1
+	}
+
+	return ordinal
+}
+
+// recyclePods evicts pods one by one, in the given order, waiting for the old
+// pod to be gone and for ready to report its workload ready again before
+// moving on to the next pod. Evictions refused by a PodDisruptionBudget are
+// retried until podReadyTimeout. Pods that are not replaced by then return
+// ErrRolloutStuck.
+func (kraftClient *KraftClients) recyclePods(ctx context.Context, pods []corev1.Pod, ready func() (bool, error)) error {
+	for _, pod := range pods {
+		err := kraftClient.evictPod(ctx, pod)
+		if err != nil {
+			return err
+		}
+
+		podsClient := kraftClient.kubeClient.CoreV1().Pods(pod.Namespace)
+
+		err = wait.PollImmediate(podPollInterval, podReadyTimeout, func() (bool, error) {
+			current, err := podsClient.Get(ctx, pod.Name, metav1.GetOptions{})
+			if err == nil && current.UID == pod.UID {
+				return false, nil
+			}
+			if err != nil && !apierrors.IsNotFound(err) {
+				return false, err
+			}
+
+			return ready()
+		})
+		if errors.Is(err, wait.ErrWaitTimeout) {
+			return fmt.Errorf("%w. pod: %s in namespace: %s was not replaced within %s", ErrRolloutStuck, pod.Name, pod.Namespace, podReadyTimeout)
+		}
+		if err != nil {
+			return fmt.Errorf("%w. pod: %s in namespace: %s was not replaced", err, pod.Name, pod.Namespace)
+		}
+	}
+
+	return nil
+}
+
+// evictPod evicts pod through the Eviction API, retrying for as long as a
+// PodDisruptionBudget refuses the eviction.
+func (kraftClient *KraftClients) evictPod(ctx context.Context, pod corev1.Pod) error {
+	eviction := &policyv1beta1.Eviction{
+		ObjectMeta:    metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name},
+		DeleteOptions: &metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(pod.UID))},
+	}
+
+	err := wait.PollImmediate(podPollInterval, podReadyTimeout, func() (bool, error) {
+		err := kraftClient.kubeClient.CoreV1().Pods(pod.Namespace).Evict(ctx, eviction)
+		switch {
+		case err == nil, apierrors.IsNotFound(err), apierrors.IsConflict(err):
+			return true, nil
+		case apierrors.IsTooManyRequests(err):
+			return false, nil
+		default:
+			return false, err
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("%w. failed to evict pod: %s in namespace: %s", err, pod.Name, pod.Namespace)
+	}
+
+	return nil
+}
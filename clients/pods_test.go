@@ -0,0 +1,195 @@
+package clients
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// newPod returns a pod in the apps namespace labeled app: app that started at
+// startTime, controlled by owner unless it is nil.
+func newPod(name string, app string, owner metav1.Object, startTime time.Time) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: name, UID: types.UID("uid-" + name), Labels: map[string]string{"app": app}},
+		Status:     corev1.PodStatus{StartTime: &metav1.Time{Time: startTime}},
+	}
+	if owner != nil {
+		controller := true
+		pod.OwnerReferences = []metav1.OwnerReference{{Name: owner.GetName(), UID: owner.GetUID(), Controller: &controller}}
+	}
+
+	return pod
+}
+
+// evictionRecorder records the pods evicted through a fake typed client,
+// which removes them right away as if they were replaced.
+type evictionRecorder struct {
+	lock    sync.Mutex
+	evicted []string
+}
+
+// recordEvictions makes the typed client of kraftClients evict pods by
+// deleting them, and returns the recorder of the evicted pods.
+func recordEvictions(kraftClients *KraftClients) *evictionRecorder {
+	recorder := &evictionRecorder{}
+	kubeClient := kraftClients.GetKubeClient().(*kubefake.Clientset)
+	kubeClient.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+
+		name := action.(clienttesting.CreateAction).GetObject().(metav1.Object).GetName()
+		err := kubeClient.Tracker().Delete(corev1.SchemeGroupVersion.WithResource("pods"), action.GetNamespace(), name)
+		Expect(err).NotTo(HaveOccurred())
+
+		recorder.lock.Lock()
+		defer recorder.lock.Unlock()
+		recorder.evicted = append(recorder.evicted, name)
+
+		return true, nil, nil
+	})
+
+	return recorder
+}
+
+// pods returns the names of the evicted pods in the order they were evicted.
+func (recorder *evictionRecorder) pods() []string {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	return append([]string(nil), recorder.evicted...)
+}
+
+var _ = Describe("Evictions", func() {
+	ctx := context.Background()
+	started := time.Now().Add(-time.Hour)
+
+	// newEvictionClients returns KraftClients serving the Deployment web with
+	// two pods, web-b being the cheaper one to delete, a pod of another app
+	// and objects, along with the recorder of their evictions.
+	newEvictionClients := func(objects ...runtime.Object) (*KraftClients, *dynamicfake.FakeDynamicClient, *evictionRecorder) {
+		cheap := newPod("web-b", "web", nil, started)
+		cheap.Annotations = map[string]string{PodDeletionCostAnnotation: "-5"}
+		objects = append(objects, newPod("web-a", "web", nil, started), cheap, newPod("api-a", "api", nil, started))
+
+		kraftClients, dynamicClient := newTestClients([]*appsv1.Deployment{newDeployment("web", nil)}, objects...)
+		return kraftClients, dynamicClient, recordEvictions(kraftClients)
+	}
+
+	restartAnnotation := func(dynamicClient *dynamicfake.FakeDynamicClient) (string, bool) {
+		object, err := dynamicClient.Resource(appsv1.SchemeGroupVersion.WithResource("deployments")).Namespace("apps").Get(ctx, "web", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		value, ok := object.GetAnnotations()[RestartTimeAnnotation]
+		return value, ok
+	}
+
+	It("evicts the pods of deployments without touching their pod template", func() {
+		kraftClients, dynamicClient, evictions := newEvictionClients()
+
+		Expect(kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{Evict: true})).To(Succeed())
+		Expect(evictions.pods()).To(ConsistOf("web-a", "web-b"))
+		Expect(templateAnnotations(dynamicClient, "web")).To(BeEmpty())
+
+		value, ok := restartAnnotation(dynamicClient)
+		Expect(ok).To(BeTrue())
+		restartTime, ok := parseRestartAnnotation(value)
+		Expect(ok).To(BeTrue())
+		Expect(restartTime).To(BeTemporally("~", time.Now(), time.Minute))
+
+		By("leaving workloads restarted by eviction within the coalesce window alone")
+		err := kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{Evict: true, CoalesceWindow: time.Hour})
+		Expect(IsCoalesced(err)).To(BeTrue(), "%v", err)
+	})
+
+	It("evicts the pods with the lowest pod deletion cost first", func() {
+		kraftClients, _, evictions := newEvictionClients()
+
+		Expect(kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{Evict: true, OrderByPodDeletionCost: true})).To(Succeed())
+		Expect(evictions.pods()).To(Equal([]string{"web-b", "web-a"}))
+	})
+
+	for _, tc := range []struct {
+		name    string
+		budget  int32
+		dryRun  bool
+		blocked bool
+	}{
+		{name: "evicts nothing in a dry run", budget: 1, dryRun: true},
+		{name: "evicts nothing while a budget allows no disruption", blocked: true},
+		{name: "reports dry runs a budget would block", dryRun: true, blocked: true},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			kraftClients, dynamicClient, evictions := newEvictionClients(newBudget("web", "web", tc.budget))
+
+			err := kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{Evict: true, DryRun: tc.dryRun})
+			if tc.blocked {
+				Expect(IsBlockedByDisruptionBudget(err)).To(BeTrue(), "%v", err)
+			} else {
+				Expect(IsDryRun(err)).To(BeTrue(), "%v", err)
+			}
+			Expect(evictions.pods()).To(BeEmpty())
+
+			_, restarted := restartAnnotation(dynamicClient)
+			Expect(restarted).To(BeFalse())
+		})
+	}
+})
+
+var _ = Describe("Max pod age", func() {
+	ctx := context.Background()
+
+	// newAgedClients returns KraftClients serving the Deployment web with a
+	// pod that started a day ago and one that started an hour ago.
+	newAgedClients := func() (*KraftClients, *dynamicfake.FakeDynamicClient, *evictionRecorder) {
+		kraftClients, dynamicClient := newTestClients(
+			[]*appsv1.Deployment{newDeployment("web", nil)},
+			newPod("web-old", "web", nil, time.Now().Add(-24*time.Hour)),
+			newPod("web-new", "web", nil, time.Now().Add(-time.Hour)),
+		)
+		return kraftClients, dynamicClient, recordEvictions(kraftClients)
+	}
+
+	It("restarts workloads with a pod older than the max pod age", func() {
+		kraftClients, dynamicClient, evictions := newAgedClients()
+
+		Expect(kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{MaxPodAge: 12 * time.Hour})).To(Succeed())
+		Expect(templateAnnotations(dynamicClient, "web")).To(HaveKey(RestartTimeAnnotation))
+		Expect(evictions.pods()).To(BeEmpty())
+	})
+
+	It("leaves workloads whose pods are all younger than the max pod age alone", func() {
+		kraftClients, dynamicClient, _ := newAgedClients()
+
+		err := kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{MaxPodAge: 48 * time.Hour})
+		Expect(IsNotDue(err)).To(BeTrue(), "%v", err)
+		Expect(templateAnnotations(dynamicClient, "web")).To(BeEmpty())
+	})
+
+	It("evicts only the pods older than the max pod age", func() {
+		kraftClients, _, evictions := newAgedClients()
+
+		Expect(kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{MaxPodAge: 12 * time.Hour, Evict: true})).To(Succeed())
+		Expect(evictions.pods()).To(Equal([]string{"web-old"}))
+	})
+
+	It("never finds pods that have not started too old", func() {
+		pending := newPod("web-pending", "web", nil, time.Time{})
+		pending.Status.StartTime = nil
+
+		Expect(podsOlderThan([]corev1.Pod{*pending}, time.Now())).To(BeEmpty())
+	})
+})
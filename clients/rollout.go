@@ -0,0 +1,185 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/models"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	// rolloutPollInterval is how often the rollout of a workload is checked.
+	rolloutPollInterval = 5 * time.Second
+
+	// DefaultRolloutTimeout is how long a rollout may take for workloads that
+	// do not report on their own when they are stuck.
+	DefaultRolloutTimeout = 10 * time.Minute
+
+	// progressDeadlineExceeded is the reason of the Progressing condition of
+	// a Deployment whose rollout is stuck.
+	progressDeadlineExceeded = "ProgressDeadlineExceeded"
+)
+
+// ErrRolloutStuck is returned for workloads whose rollout did not complete in
+// time.
+var ErrRolloutStuck = errors.New("rollout did not complete")
+
+// ErrRolledBack is returned for workloads that were rolled back to their
+// previous pod template after their rollout got stuck. It wraps
+// ErrRolloutStuck.
+var ErrRolledBack = fmt.Errorf("%w, rolled back to the previous pod template", ErrRolloutStuck)
+
+// ErrRunStopped is returned for workloads that were not restarted because the
+// rollout of another one got stuck first.
+var ErrRunStopped = errors.New("run stopped")
+
+// IsRolloutStuck reports whether err is, or wraps, ErrRolloutStuck.
+func IsRolloutStuck(err error) bool {
+	return errors.Is(err, ErrRolloutStuck)
+}
+
+// IsRolledBack reports whether err is, or wraps, ErrRolledBack.
+func IsRolledBack(err error) bool {
+	return errors.Is(err, ErrRolledBack)
+}
+
+// IsRunStopped reports whether err is, or wraps, ErrRunStopped.
+func IsRunStopped(err error) bool {
+	return errors.Is(err, ErrRunStopped)
+}
+
+// waitForRollout waits until the controller of workload has observed
+// generation and rolled it out completely. It gives up with ErrRolloutStuck
+// once a Deployment reports that it exceeded its progress deadline, or after
+// timeout when it is set. Paused workloads do not roll out, so they are not
+// waited for.
+func (kraftClient *KraftClients) waitForRollout(ctx context.Context, resourceClient dynamic.ResourceInterface, workload models.Workload, generation int64, timeout time.Duration) error {
+	waitCtx := ctx
+	if timeout > 0 {
+		var cancel func()
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := wait.PollImmediateUntil(rolloutPollInterval, func() (bool, error) {
+		object, err := resourceClient.Get(waitCtx, workload.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("%w. %s: %s in namespace: %s was deleted during its rollout", err, workload.Type.Kind, workload.Name, workload.Namespace)
+		}
+		if err != nil {
+			// Keep polling through transient failures until the timeout.
+			return false, nil
+		}
+
+		paused, _, _ := unstructured.NestedBool(object.Object, "spec", "paused")
+		if paused {
+			return true, nil
+		}
+
+		// Conditions left over from an earlier rollout only count once the
+		// controller has observed the restart.
+		observedGeneration, found, _ := unstructured.NestedInt64(object.Object, "status", "observedGeneration")
+		if found && observedGeneration < generation {
+			return false, nil
+		}
+
+		if conditionReason(object, "Progressing") == progressDeadlineExceeded {
+			return false, fmt.Errorf("%w. %s: %s in namespace: %s exceeded its progress deadline", ErrRolloutStuck, workload.Type.Kind, workload.Name, workload.Namespace)
+		}
+
+		return IsHealthy(object, workload.Type.Health)
+	}, waitCtx.Done())
+
+	if errors.Is(err, wait.ErrWaitTimeout) {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w. stopped waiting for the rollout of %s: %s in namespace: %s", ctx.Err(), workload.Type.Kind, workload.Name, workload.Namespace)
+		}
+
+		return fmt.Errorf("%w. %s: %s in namespace: %s did not complete its rollout within %s", ErrRolloutStuck, workload.Type.Kind, workload.Name, workload.Namespace, timeout)
+	}
+
+	return err
+}
+
+// rollback restores the restart annotations of the pod template of workload to
+// those of object, as it was before the restart, which brings back the
+// previous pod template as `kubectl rollout undo` would. It marks the workload
+// with RolledBackAnnotation, which pauses its restarts until someone removes
+// it. stuckErr is the reason for the rollback.
+func (kraftClient *KraftClients) rollback(ctx context.Context, resourceClient dynamic.ResourceInterface, workload models.Workload, object *unstructured.Unstructured, stuckErr error) error {
+	fields := templateAnnotationsFields(workload.Type)
+	previous, _, _ := unstructured.NestedStringMap(object.Object, fields...)
+
+	patchData := map[string]interface{}{}
+	for _, annotation := range []string{RestartTimeAnnotation, HashAnnotation} {
+		// A null value removes annotations the template did not have before.
+		var value interface{}
+		if previousValue, ok := previous[annotation]; ok {
+			value = previousValue
+		}
+
+		err := unstructured.SetNestedField(patchData, value, append(fields, annotation)...)
+		if err != nil {
+			return fmt.Errorf("%w. failed to roll back %s: %s in namespace: %s: %s", stuckErr, workload.Type.Kind, workload.Name, workload.Namespace, err)
+		}
+	}
+
+	err := unstructured.SetNestedField(patchData, time.Now().Format(time.RFC3339), "metadata", "annotations", RolledBackAnnotation)
+	if err != nil {
+		return fmt.Errorf("%w. failed to roll back %s: %s in namespace: %s: %s", stuckErr, workload.Type.Kind, workload.Name, workload.Namespace, err)
+	}
+
+	encodedData, err := json.Marshal(patchData)
+	if err != nil {
+		return fmt.Errorf("%w. failed to roll back %s: %s in namespace: %s: %s", stuckErr, workload.Type.Kind, workload.Name, workload.Namespace, err)
+	}
+
+	_, err = resourceClient.Patch(ctx, workload.Name, types.MergePatchType, encodedData, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("%w. failed to roll back %s: %s in namespace: %s: %s", stuckErr, workload.Type.Kind, workload.Name, workload.Namespace, err)
+	}
+
+	return fmt.Errorf("%w. %s: %s in namespace: %s", ErrRolledBack, workload.Type.Kind, workload.Name, workload.Namespace)
+}
+
+// rolloutTimeout returns how long to wait for the rollout of object. With no
+// timeout in options, Deployments are waited for until they report that they
+// exceeded their progress deadline, and other kinds for DefaultRolloutTimeout.
+func rolloutTimeout(object *unstructured.Unstructured, options RestartOptions) time.Duration {
+	if options.RolloutTimeout > 0 {
+		return options.RolloutTimeout
+	}
+
+	_, found, _ := unstructured.NestedInt64(object.Object, "spec", "progressDeadlineSeconds")
+	if found {
+		return 0
+	}
+
+	return DefaultRolloutTimeout
+}
+
+// conditionReason returns the reason of the status condition of conditionType.
+func conditionReason(object *unstructured.Unstructured, conditionType string) string {
+	conditions, _, _ := unstructured.NestedSlice(object.Object, "status", "conditions")
+	for _, condition := range conditions {
+		conditionMap, ok := condition.(map[string]interface{})
+		if !ok || conditionMap["type"] != conditionType {
+			continue
+		}
+
+		reason, _ := conditionMap["reason"].(string)
+		return reason
+	}
+
+	return ""
+}
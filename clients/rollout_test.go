@@ -0,0 +1,63 @@
+package clients
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Rollbacks", func() {
+	ctx := context.Background()
+	previousRestart := time.Date(2021, 7, 1, 3, 30, 0, 0, time.UTC).Format(time.UnixDate)
+
+	for _, tc := range []struct {
+		name     string
+		previous map[string]string
+		restored map[string]string
+	}{
+		{
+			name:     "restores the pod template of stuck deployments",
+			previous: map[string]string{RestartTimeAnnotation: previousRestart, HashAnnotation: "abc", "team": "web"},
+			restored: map[string]string{RestartTimeAnnotation: previousRestart, HashAnnotation: "abc", "team": "web"},
+		},
+		{
+			name:     "removes the restart annotations of stuck deployments restarted for the first time",
+			previous: map[string]string{"team": "web"},
+			restored: map[string]string{"team": "web"},
+		},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			kraftClients, dynamicClient := newTestClients([]*appsv1.Deployment{newDeployment("web", func(deployment *appsv1.Deployment) {
+				deployment.Spec.Template.Annotations = tc.previous
+				stuck(deployment)
+			})})
+
+			err := kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{RollbackOnFailure: true})
+			Expect(IsRolledBack(err)).To(BeTrue(), "%v", err)
+			Expect(IsRolloutStuck(err)).To(BeTrue())
+
+			Expect(templateAnnotations(dynamicClient, "web")).To(Equal(tc.restored))
+
+			object, err := dynamicClient.Resource(appsv1.SchemeGroupVersion.WithResource("deployments")).Namespace("apps").Get(ctx, "web", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			rolledBack, err := time.Parse(time.RFC3339, object.GetAnnotations()[RolledBackAnnotation])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rolledBack).To(BeTemporally("~", time.Now(), time.Minute))
+		})
+	}
+
+	It("leaves stuck deployments alone without rollbackOnFailure", func() {
+		kraftClients, dynamicClient := newTestClients([]*appsv1.Deployment{newDeployment("web", stuck)})
+
+		err := kraftClients.RestartWorkload(ctx, deploymentWorkload("web"), RestartOptions{})
+		Expect(IsRolloutStuck(err)).To(BeTrue(), "%v", err)
+		Expect(IsRolledBack(err)).To(BeFalse())
+		Expect(templateAnnotations(dynamicClient, "web")).To(HaveKey(RestartTimeAnnotation))
+	})
+})
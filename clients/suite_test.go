@@ -0,0 +1,14 @@
+package clients
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestClients(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Clients Suite")
+}
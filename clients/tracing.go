@@ -0,0 +1,81 @@
+package clients
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/models"
+	"github.com/anmolbabu/kraft-controller/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WorkloadAttributes describe workload on the spans about it.
+func WorkloadAttributes(workload models.Workload) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("flipper.target.kind", workload.Type.Kind),
+		attribute.String("flipper.target.namespace", workload.Namespace),
+		attribute.String("flipper.target.name", workload.Name),
+	}
+}
+
+// traced runs step of a restart in a child span of ctx named name.
+func traced(ctx context.Context, name string, step func(ctx context.Context) error) error {
+	ctx, span := tracing.Tracer().Start(ctx, name)
+	err := step(ctx)
+	tracing.End(span, err)
+
+	return err
+}
+
+// endRestartSpan ends the span of the restart of a workload, which failed with
+// err unless the workload was left alone on purpose.
+func endRestartSpan(span trace.Span, err error) {
+	if IsCoalesced(err) || IsNotDue(err) || IsDryRun(err) {
+		span.SetAttributes(attribute.String("flipper.skipped", err.Error()))
+		err = nil
+	}
+
+	tracing.End(span, err)
+}
+
+// traceIDSeparator separates the time of a restart from the ID of the trace
+// of its run in the value of RestartTimeAnnotation.
+const traceIDSeparator = "; trace-id="
+
+// RestartAnnotation returns the value of RestartTimeAnnotation for a restart
+// at restartTime in the run that ctx traces, which carries the ID of the trace
+// after the time so that the telemetry of the restarted pods can be traced
+// back to the run.
+func RestartAnnotation(ctx context.Context, restartTime time.Time) string {
+	value := restartTime.Format(time.UnixDate)
+	if traceID, ok := tracing.TraceID(ctx); ok {
+		value += traceIDSeparator + traceID
+	}
+
+	return value
+}
+
+// parseRestartAnnotation reads the time of a restart from value, a value of
+// RestartTimeAnnotation, ignoring the ID of the trace of its run.
+func parseRestartAnnotation(value string) (time.Time, bool) {
+	value = strings.SplitN(value, traceIDSeparator, 2)[0]
+	for _, layout := range []string{time.UnixDate, time.RFC3339} {
+		restartTime, err := time.Parse(layout, value)
+		if err == nil {
+			return restartTime, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// restartAnnotations returns the merge patch of the annotations of a workload
+// restarted at restartTime in the run that ctx traces.
+func restartAnnotations(ctx context.Context, restartTime time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		RestartTimeAnnotation: RestartAnnotation(ctx, restartTime),
+	}
+}
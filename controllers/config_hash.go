@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// configReferences lists the ConfigMaps and Secrets a pod spec references
+// through env, envFrom and volumes.
+type configReferences struct {
+	configMaps map[string]bool
+	secrets    map[string]bool
+}
+
+// referencedConfigs returns the ConfigMaps and Secrets podSpec references.
+func referencedConfigs(podSpec *corev1.PodSpec) configReferences {
+	refs := configReferences{
+		configMaps: make(map[string]bool),
+		secrets:    make(map[string]bool),
+	}
+
+	containers := append(append([]corev1.Container{}, podSpec.InitContainers...), podSpec.Containers...)
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if env.ValueFrom.ConfigMapKeyRef != nil {
+				refs.configMaps[env.ValueFrom.ConfigMapKeyRef.Name] = true
+			}
+			if env.ValueFrom.SecretKeyRef != nil {
+				refs.secrets[env.ValueFrom.SecretKeyRef.Name] = true
+			}
+		}
+
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				refs.configMaps[envFrom.ConfigMapRef.Name] = true
+			}
+			if envFrom.SecretRef != nil {
+				refs.secrets[envFrom.SecretRef.Name] = true
+			}
+		}
+	}
+
+	for _, volume := range podSpec.Volumes {
+		if volume.ConfigMap != nil {
+			refs.configMaps[volume.ConfigMap.Name] = true
+		}
+		if volume.Secret != nil {
+			refs.secrets[volume.Secret.SecretName] = true
+		}
+		if volume.Projected == nil {
+			continue
+		}
+		for _, source := range volume.Projected.Sources {
+			if source.ConfigMap != nil {
+				refs.configMaps[source.ConfigMap.Name] = true
+			}
+			if source.Secret != nil {
+				refs.secrets[source.Secret.Name] = true
+			}
+		}
+	}
+
+	return refs
+}
+
+// configIndex indexes Deployments by the ConfigMaps and Secrets their pod
+// template references, named as configKey names them.
+const configIndex = "spec.template.configs"
+
+// indexDeploymentConfigs returns the configIndex values of object, a
+// Deployment.
+func indexDeploymentConfigs(object client.Object) []string {
+	deployment, ok := object.(*appsv1.Deployment)
+	if !ok {
+		return nil
+	}
+
+	return referencedConfigs(&deployment.Spec.Template.Spec).keys()
+}
+
+// keys returns the ConfigMaps and Secrets refs include, named as configKey
+// names them.
+func (refs configReferences) keys() []string {
+	keys := make([]string, 0, len(refs.configMaps)+len(refs.secrets))
+	for _, name := range sortedNames(refs.configMaps) {
+		keys = append(keys, configKey(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name}}))
+	}
+	for _, name := range sortedNames(refs.secrets) {
+		keys = append(keys, configKey(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name}}))
+	}
+
+	return keys
+}
+
+// configKey names object, a ConfigMap or a Secret, in configIndex. It is
+// empty for any other object.
+func configKey(object client.Object) string {
+	switch object.(type) {
+	case *corev1.ConfigMap:
+		return "configmap/" + object.GetName()
+	case *corev1.Secret:
+		return "secret/" + object.GetName()
+	default:
+		return ""
+	}
+}
+
+// configHash hashes the content of the ConfigMaps and Secrets in namespace
+// that refs include. Missing ones, which optional references allow, hash
+// differently from empty ones, so that creating them changes the hash too.
+func configHash(ctx context.Context, c client.Client, namespace string, refs configReferences) (string, error) {
+	hash := sha256.New()
+
+	for _, name := range sortedNames(refs.configMaps) {
+		configMap := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, configMap)
+		if err != nil && !errors.IsNotFound(err) {
+			return "", fmt.Errorf("%w. failed to fetch configmap: %s in namespace: %s", err, name, namespace)
+		}
+
+		fmt.Fprintf(hash, "configmap/%s:%t\n", name, err == nil)
+		for _, key := range sortedKeys(configMap.Data) {
+			fmt.Fprintf(hash, "%s=%q\n", key, configMap.Data[key])
+		}
+		for _, key := range sortedByteKeys(configMap.BinaryData) {
+			fmt.Fprintf(hash, "%s=%x\n", key, configMap.BinaryData[key])
+		}
+	}
+
+	for _, name := range sortedNames(refs.secrets) {
+		secret := &corev1.Secret{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret)
+		if err != nil && !errors.IsNotFound(err) {
+			return "", fmt.Errorf("%w. failed to fetch secret: %s in namespace: %s", err, name, namespace)
+		}
+
+		fmt.Fprintf(hash, "secret/%s:%t\n", name, err == nil)
+		for _, key := range sortedByteKeys(secret.Data) {
+			fmt.Fprintf(hash, "%s=%x\n", key, secret.Data[key])
+		}
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+func sortedNames(names map[string]bool) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+func sortedKeys(values map[string]string) []string {
+	sorted := make([]string, 0, len(values))
+	for key := range values {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+func sortedByteKeys(values map[string][]byte) []string {
+	sorted := make([]string, 0, len(values))
+	for key := range values {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
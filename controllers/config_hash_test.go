@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Config hashes", func() {
+	ctx := context.Background()
+
+	configMap := func(name string, data map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: name}, Data: data}
+	}
+	secret := func(name string, data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: name}, Data: data}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		podSpec corev1.PodSpec
+		keys    []string
+	}{
+		{
+			name: "references configs from env",
+			podSpec: corev1.PodSpec{Containers: []corev1.Container{{Env: []corev1.EnvVar{
+				{Name: "A", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}}},
+				{Name: "B", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "token"}}}},
+				{Name: "C", Value: "plain"},
+			}}}},
+			keys: []string{"configmap/settings", "secret/token"},
+		},
+		{
+			name: "references configs from envFrom of init containers",
+			podSpec: corev1.PodSpec{InitContainers: []corev1.Container{{EnvFrom: []corev1.EnvFromSource{
+				{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}},
+				{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "token"}}},
+			}}}},
+			keys: []string{"configmap/settings", "secret/token"},
+		},
+		{
+			name: "references configs from volumes and projected volumes",
+			podSpec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{Name: "settings", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}}},
+				{Name: "token", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "token"}}},
+				{Name: "all", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{
+					{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "extra"}}},
+					{Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "token"}}},
+				}}}},
+				{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			}},
+			keys: []string{"configmap/extra", "configmap/settings", "secret/token"},
+		},
+		{
+			name:    "references nothing without configs",
+			podSpec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}},
+			keys:    []string{},
+		},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			Expect(referencedConfigs(&tc.podSpec).keys()).To(Equal(tc.keys))
+			Expect(indexDeploymentConfigs(&appsv1.Deployment{Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: tc.podSpec}}})).To(Equal(tc.keys))
+		})
+	}
+
+	refs := referencedConfigs(&corev1.PodSpec{Volumes: []corev1.Volume{
+		{Name: "settings", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}}},
+		{Name: "token", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "token"}}},
+	}})
+	hashOf := func(objects ...client.Object) string {
+		hash, err := configHash(ctx, newFakeClient(objects...), "apps", refs)
+		Expect(err).NotTo(HaveOccurred())
+
+		return hash
+	}
+	base := hashOf(configMap("settings", map[string]string{"level": "info"}), secret("token", map[string][]byte{"key": []byte("s3cr3t")}))
+
+	for _, tc := range []struct {
+		name    string
+		objects []client.Object
+		changed bool
+	}{
+		{
+			name:    "hashes the same content the same",
+			objects: []client.Object{secret("token", map[string][]byte{"key": []byte("s3cr3t")}), configMap("settings", map[string]string{"level": "info"})},
+		},
+		{
+			name:    "ignores configs that are not referenced",
+			objects: []client.Object{configMap("settings", map[string]string{"level": "info"}), secret("token", map[string][]byte{"key": []byte("s3cr3t")}), configMap("other", map[string]string{"level": "debug"})},
+		},
+		{
+			name:    "ignores configs of other namespaces",
+			objects: []client.Object{configMap("settings", map[string]string{"level": "info"}), secret("token", map[string][]byte{"key": []byte("s3cr3t")}), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "settings"}}},
+		},
+		{
+			name:    "changes with configmap data",
+			objects: []client.Object{configMap("settings", map[string]string{"level": "debug"}), secret("token", map[string][]byte{"key": []byte("s3cr3t")})},
+			changed: true,
+		},
+		{
+			name:    "changes with secret data",
+			objects: []client.Object{configMap("settings", map[string]string{"level": "info"}), secret("token", map[string][]byte{"key": []byte("rotated")})},
+			changed: true,
+		},
+		{
+			name:    "changes when a config goes missing",
+			objects: []client.Object{configMap("settings", map[string]string{"level": "info"})},
+			changed: true,
+		},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			if tc.changed {
+				Expect(hashOf(tc.objects...)).NotTo(Equal(base))
+			} else {
+				Expect(hashOf(tc.objects...)).To(Equal(base))
+			}
+		})
+	}
+
+	It("hashes missing configs differently from empty ones", func() {
+		Expect(hashOf()).NotTo(Equal(hashOf(configMap("settings", nil), secret("token", nil))))
+	})
+
+	It("names configs by kind in the index", func() {
+		Expect(configKey(configMap("settings", nil))).To(Equal("configmap/settings"))
+		Expect(configKey(secret("settings", nil))).To(Equal("secret/settings"))
+		Expect(configKey(&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "settings"}})).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Reloading flipper index", func() {
+	for _, tc := range []struct {
+		name   string
+		mutate func(*v1beta1.Flipper)
+		values []string
+	}{
+		{
+			name:   "skips flippers that do not reload",
+			values: nil,
+		},
+		{
+			name:   "indexes flippers by their own namespace",
+			mutate: func(flipper *v1beta1.Flipper) { flipper.Spec.ReloadOnConfigChange = true },
+			values: []string{"apps"},
+		},
+		{
+			name: "indexes flippers by the namespace they match",
+			mutate: func(flipper *v1beta1.Flipper) {
+				flipper.Spec.ReloadOnConfigChange = true
+				flipper.Spec.Match.Namespace = "web"
+			},
+			values: []string{"web"},
+		},
+		{
+			name: "indexes flippers selecting namespaces under any namespace",
+			mutate: func(flipper *v1beta1.Flipper) {
+				flipper.Spec.ReloadOnConfigChange = true
+				flipper.Spec.Match.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "web"}}
+			},
+			values: []string{anyNamespace},
+		},
+		{
+			name: "indexes flippers matching all namespaces under any namespace",
+			mutate: func(flipper *v1beta1.Flipper) {
+				flipper.Spec.ReloadOnConfigChange = true
+				flipper.Spec.Match.AllNamespaces = true
+			},
+			values: []string{anyNamespace},
+		},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			Expect(indexReloadingFlippers(newScheduledFlipper("reload", tc.mutate))).To(Equal(tc.values))
+		})
+	}
+})
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/matching"
+	"github.com/anmolbabu/kraft-controller/models"
+	"github.com/anmolbabu/kraft-controller/schedule"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// flipperClaim is the claim of a Flipper on the workloads it matches.
+type flipperClaim struct {
+	key      types.NamespacedName
+	priority int32
+	interval time.Duration
+}
+
+// newFlipperClaim returns the claim of flipper on its targets.
+func newFlipperClaim(flipper *v1beta1.Flipper, parsed *parsedConfig) flipperClaim {
+	claim := flipperClaim{
+		key:      types.NamespacedName{Namespace: flipper.Namespace, Name: flipper.Name},
+		priority: flipper.Spec.Priority,
+	}
+	if parsed.schedule != nil {
+		claim.interval = schedule.ShortestInterval(parsed.schedule)
+	}
+
+	return claim
+}
+
+// precedes reports whether claim wins a workload over other. The higher
+// priority wins, then the shorter interval, then the name that sorts first.
+func (claim flipperClaim) precedes(other flipperClaim) bool {
+	if claim.priority != other.priority {
+		return claim.priority > other.priority
+	}
+
+	if claim.interval != other.interval {
+		return claim.interval < other.interval
+	}
+
+	return claim.key.String() < other.key.String()
+}
+
+// targetConflict lists the other Flippers matching a target and the one that
+// restarts it.
+type targetConflict struct {
+	others []string
+	owner  *flipperClaim
+}
+
+// findConflicts returns the targets of claim that other Flippers match too.
+// Flippers that are being deleted, have an invalid spec or only reload on
+// config changes never restart anything on a schedule, so they do not
+// conflict. Suspended and dry run Flippers still conflict, but do not restart
+// the targets they claim, so they never own them. The other Flippers are
+// listed through c and parsed through specs.
+func findConflicts(ctx context.Context, c client.Reader, specs *parsedSpecs, claim flipperClaim, targets map[string]models.Workload) (map[string]targetConflict, error) {
+	flipperList := &v1beta1.FlipperList{}
+	err := c.List(ctx, flipperList)
+	if err != nil {
+		return nil, err
+	}
+
+	conflicts := make(map[string]targetConflict)
+	for idx := range flipperList.Items {
+		other := &flipperList.Items[idx]
+		if other.Namespace == claim.key.Namespace && other.Name == claim.key.Name || other.DeletionTimestamp != nil {
+			continue
+		}
+
+		parsed, err := specs.get(other)
+		if err != nil || parsed.schedule == nil {
+			continue
+		}
+
+		// The targets of claim are checked against the selector of other
+		// instead of listing the targets of every other Flipper.
+		namespaces, err := parsed.targets.Namespaces(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+
+		otherClaim := newFlipperClaim(other, parsed)
+		for key, workload := range targets {
+			if !parsed.targets.Selects(workload, namespaces) {
+				continue
+			}
+
+			conflict := conflicts[key]
+			conflict.others = append(conflict.others, otherClaim.key.String())
+			if !other.Spec.Suspend && !other.Spec.DryRun && otherClaim.precedes(claim) && (conflict.owner == nil || otherClaim.precedes(*conflict.owner)) {
+				owner := otherClaim
+				conflict.owner = &owner
+			}
+			conflicts[key] = conflict
+		}
+	}
+
+	for key := range conflicts {
+		sort.Strings(conflicts[key].others)
+	}
+
+	return conflicts, nil
+}
+
+// overlappingFlippers requeues the Flippers whose targets may overlap with
+// those of a Flipper that was created, changed or deleted, before or after
+// the change, as that may change which of them restarts a workload.
+func (r *FlipperReconciler) overlappingFlippers() handler.EventHandler {
+	return handler.Funcs{
+		CreateFunc: func(e event.CreateEvent, queue workqueue.RateLimitingInterface) {
+			r.requeueOverlapping(queue, e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent, queue workqueue.RateLimitingInterface) {
+			r.requeueOverlapping(queue, e.ObjectOld, e.ObjectNew)
+		},
+		DeleteFunc: func(e event.DeleteEvent, queue workqueue.RateLimitingInterface) {
+			r.requeueOverlapping(queue, e.Object)
+		},
+	}
+}
+
+// requeueOverlapping requeues every Flipper but those in changed whose
+// targets may overlap with the targets of any of them.
+func (r *FlipperReconciler) requeueOverlapping(queue workqueue.RateLimitingInterface, changed ...client.Object) {
+	var selectors []*matching.Selector
+	for _, object := range changed {
+		flipper, ok := object.(*v1beta1.Flipper)
+		if !ok {
+			continue
+		}
+
+		parsed, err := r.specs.get(flipper)
+		if err == nil {
+			selectors = append(selectors, parsed.targets)
+		}
+	}
+	if len(selectors) == 0 {
+		return
+	}
+
+	flipperList := &v1beta1.FlipperList{}
+	err := r.List(context.Background(), flipperList)
+	if err != nil {
+		log.Log.Error(err, "failed to list flippers")
+		return
+	}
+
+	for idx := range flipperList.Items {
+		flipper := &flipperList.Items[idx]
+		if flipper.Namespace == changed[0].GetNamespace() && flipper.Name == changed[0].GetName() {
+			continue
+		}
+
+		parsed, err := r.specs.get(flipper)
+		if err != nil {
+			continue
+		}
+
+		for _, selector := range selectors {
+			if selector.Overlaps(parsed.targets) {
+				queue.Add(reconcile.Request{
+					NamespacedName: types.NamespacedName{Namespace: flipper.Namespace, Name: flipper.Name},
+				})
+				break
+			}
+		}
+	}
+}
+
+// parsedSpecs caches the parsed specs of Flippers by generation, as resolving
+// conflicts needs those of every other Flipper on every reconcile.
+type parsedSpecs struct {
+	lock    sync.Mutex
+	entries map[types.NamespacedName]parsedSpec
+}
+
+// parsedSpec is a Flipper spec parsed at generation, or the error it failed
+// to parse with.
+type parsedSpec struct {
+	uid        types.UID
+	generation int64
+	parsed     *parsedConfig
+	err        error
+}
+
+// get returns the parsed spec of flipper, parsing it unless the same
+// generation was parsed before.
+func (specs *parsedSpecs) get(flipper *v1beta1.Flipper) (*parsedConfig, error) {
+	key := types.NamespacedName{Namespace: flipper.Namespace, Name: flipper.Name}
+
+	specs.lock.Lock()
+	defer specs.lock.Unlock()
+
+	entry, ok := specs.entries[key]
+	if ok && entry.uid == flipper.UID && entry.generation == flipper.Generation {
+		return entry.parsed, entry.err
+	}
+
+	parsed, err := parseConfig(models.ConfigFromFlipper(*flipper))
+	if specs.entries == nil {
+		specs.entries = make(map[types.NamespacedName]parsedSpec)
+	}
+	specs.entries[key] = parsedSpec{uid: flipper.UID, generation: flipper.Generation, parsed: parsed, err: err}
+
+	return parsed, err
+}
+
+// forget drops the parsed spec of the Flipper with the given key.
+func (specs *parsedSpecs) forget(key types.NamespacedName) {
+	specs.lock.Lock()
+	defer specs.lock.Unlock()
+
+	delete(specs.entries, key)
+}
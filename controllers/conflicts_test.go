@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/matching"
+	"github.com/anmolbabu/kraft-controller/models"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newScheduledFlipper(name string, mutate func(*v1beta1.Flipper)) *v1beta1.Flipper {
+	flipper := &v1beta1.Flipper{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: name, Generation: 1},
+		Spec: v1beta1.FlipperSpec{
+			Schedule: "30 3 * * *",
+			Match:    v1beta1.Match{Labels: map[string]string{"app": "web"}},
+		},
+	}
+	if mutate != nil {
+		mutate(flipper)
+	}
+
+	return flipper
+}
+
+var _ = Describe("Flipper conflicts", func() {
+	web := models.Workload{
+		Type:      models.BuiltinWorkloadTypes[models.KindDeployment],
+		Namespace: "apps",
+		Name:      "web",
+		Labels:    map[string]string{"app": "web"},
+	}
+	targets := map[string]models.Workload{web.Key(): web}
+
+	claimOf := func(flipper *v1beta1.Flipper) flipperClaim {
+		parsed, err := parseConfig(models.ConfigFromFlipper(*flipper))
+		Expect(err).NotTo(HaveOccurred())
+
+		return newFlipperClaim(flipper, parsed)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		other  *v1beta1.Flipper
+		others []string
+		owner  string
+	}{
+		{
+			name:   "hands targets to flippers with a higher priority",
+			other:  newScheduledFlipper("urgent", func(flipper *v1beta1.Flipper) { flipper.Spec.Priority = 10 }),
+			others: []string{"apps/urgent"},
+			owner:  "apps/urgent",
+		},
+		{
+			name:   "keeps targets from flippers with a lower priority",
+			other:  newScheduledFlipper("lazy", func(flipper *v1beta1.Flipper) { flipper.Spec.Priority = -10 }),
+			others: []string{"apps/lazy"},
+		},
+		{
+			name:   "hands targets to flippers that restart more often",
+			other:  newScheduledFlipper("hourly", func(flipper *v1beta1.Flipper) { flipper.Spec.Schedule = "0 * * * *" }),
+			others: []string{"apps/hourly"},
+			owner:  "apps/hourly",
+		},
+		{
+			name: "keeps targets from suspended flippers with a higher priority",
+			other: newScheduledFlipper("urgent", func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Priority = 10
+				flipper.Spec.Suspend = true
+			}),
+			others: []string{"apps/urgent"},
+		},
+		{
+			name: "keeps targets from dry run flippers with a higher priority",
+			other: newScheduledFlipper("urgent", func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Priority = 10
+				flipper.Spec.DryRun = true
+			}),
+			others: []string{"apps/urgent"},
+		},
+		{
+			name: "ignores flippers that only reload on config changes",
+			other: newScheduledFlipper("reloader", func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Schedule = ""
+				flipper.Spec.ReloadOnConfigChange = true
+				flipper.Spec.Priority = 10
+			}),
+		},
+		{
+			name: "ignores flippers with an invalid spec",
+			other: newScheduledFlipper("broken", func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Schedule = "every now and then"
+				flipper.Spec.Priority = 10
+			}),
+		},
+		{
+			name:  "ignores flippers that match other workloads",
+			other: newScheduledFlipper("api", func(flipper *v1beta1.Flipper) { flipper.Spec.Match.Labels = map[string]string{"app": "api"} }),
+		},
+		{
+			name:  "ignores flippers that exclude the target",
+			other: newScheduledFlipper("all-but-web", func(flipper *v1beta1.Flipper) { flipper.Spec.Match.Exclude = []string{"web"} }),
+		},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			flipper := newScheduledFlipper("nightly", nil)
+			conflicts, err := findConflicts(context.Background(), newFakeClient(flipper, tc.other), &parsedSpecs{}, claimOf(flipper), targets)
+			Expect(err).NotTo(HaveOccurred())
+
+			if tc.others == nil {
+				Expect(conflicts).To(BeEmpty())
+				return
+			}
+			Expect(conflicts).To(HaveKey(web.Key()))
+			Expect(conflicts[web.Key()].others).To(Equal(tc.others))
+			if tc.owner == "" {
+				Expect(conflicts[web.Key()].owner).To(BeNil())
+			} else {
+				Expect(conflicts[web.Key()].owner).NotTo(BeNil())
+				Expect(conflicts[web.Key()].owner.key).To(Equal(types.NamespacedName{Namespace: "apps", Name: tc.owner[len("apps/"):]}))
+			}
+		})
+	}
+
+	It("tells which selectors may match the same workloads", func() {
+		selectorOf := func(mutate func(*v1beta1.Flipper)) *matching.Selector {
+			parsed, err := parseConfig(models.ConfigFromFlipper(*newScheduledFlipper("nightly", mutate)))
+			Expect(err).NotTo(HaveOccurred())
+
+			return parsed.targets
+		}
+
+		nightly := selectorOf(nil)
+		Expect(nightly.Overlaps(selectorOf(func(flipper *v1beta1.Flipper) { flipper.Spec.Match.Labels["tier"] = "frontend" }))).To(BeTrue())
+		Expect(nightly.Overlaps(selectorOf(func(flipper *v1beta1.Flipper) { flipper.Spec.Match.AllNamespaces = true }))).To(BeTrue())
+		Expect(nightly.Overlaps(selectorOf(func(flipper *v1beta1.Flipper) { flipper.Spec.Match.Labels = map[string]string{"app": "api"} }))).To(BeFalse())
+		Expect(nightly.Overlaps(selectorOf(func(flipper *v1beta1.Flipper) { flipper.Spec.Match.Namespace = "batch" }))).To(BeFalse())
+		Expect(nightly.Overlaps(selectorOf(func(flipper *v1beta1.Flipper) {
+			flipper.Spec.Match.Kinds = []v1beta1.WorkloadKind{v1beta1.WorkloadKind(models.KindStatefulSet)}
+		}))).To(BeFalse())
+	})
+})
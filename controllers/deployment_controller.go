@@ -18,86 +18,432 @@ package controllers
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/json"
 	"fmt"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/clients"
+	"github.com/anmolbabu/kraft-controller/matching"
 	"github.com/anmolbabu/kraft-controller/models"
+	"github.com/anmolbabu/kraft-controller/notify"
 
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-// DeploymentReconciler reconciles a Deployment object
+// DeploymentReconciler rolls out the Deployments matched by a Flipper with
+// reloadOnConfigChange whenever a ConfigMap or Secret their pod template
+// references changes.
 type DeploymentReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	config *models.Config
-	Deployments map[string]appsv1.Deployment
+	Scheme        *runtime.Scheme
+	KraftClients  *clients.KraftClients
+	Recorder      record.EventRecorder
+	Notifications *Notifications
+	// DryRun makes every Flipper a dry run, whatever its spec says.
+	DryRun bool
+
+	specs parsedSpecs
 }
 
+// reloadRetryInterval is how long a rollout for a config change that a
+// PodDisruptionBudget blocked waits before it is tried again.
+const reloadRetryInterval = time.Minute
+
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=deployments/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=apps,resources=deployments/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=configmaps;secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=flipper.flipper.io,resources=flipperruns,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=flipper.flipper.io,resources=flipperruns/status,verbs=get;update;patch
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Deployment object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.9.2/pkg/reconcile
+// Reconcile hashes the content of the ConfigMaps and Secrets a Deployment
+// references and keeps the hash in the annotations of the Deployment. Once
+// the hash changes from the one recorded there, it is stamped into the pod
+// template too, along with the time of the restart, which rolls out the
+// Deployment. The first hash is only
+// recorded, so that turning reloads on does not restart anything. While the
+// Flipper is suspended, changes are only recorded too, unless it catches up
+// on missed runs, in which case they are left for once it is resumed. Like
+// scheduled restarts, rollouts leave Deployments claimed by another Flipper
+// to that one, and wait for the windows of the Flipper, for rolled back
+// Deployments to be acknowledged and for their PodDisruptionBudgets to allow
+// a disruption. Dry runs never touch the pod template, they only record the
+// rollout in an event. Every rollout is recorded as an Event FlipperRun of
+// the Flipper. Dry runs and deferred rollouts report each hash once only.
 func (r *DeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 
-	// your logic here
-	logger.V(2).Info("processing: %#+v", req)
+	deployment := &appsv1.Deployment{}
+	err := r.Get(ctx, req.NamespacedName, deployment)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "failed to fetch deployment")
+		return ctrl.Result{}, err
+	}
+
+	if deployment.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	reloading, parsed, err := r.reloadingFlipper(ctx, deployment)
+	if err != nil {
+		logger.Error(err, "failed to look for flippers reloading the deployment")
+		return ctrl.Result{}, err
+	}
+	if reloading == nil {
+		return ctrl.Result{}, nil
+	}
 
-	deplInChange := &appsv1.Deployment{}
-	err := r.Get(ctx, req.NamespacedName, deplInChange)
+	hash, err := configHash(ctx, r.Client, deployment.Namespace, referencedConfigs(&deployment.Spec.Template.Spec))
 	if err != nil {
-		logger.Error(err, "failed to fetch deployment with name: %s", req.NamespacedName)
+		logger.Error(err, "failed to hash the config of the deployment")
 		return ctrl.Result{}, err
 	}
 
-	deploymentJSON, err := json.Marshal(*deplInChange)
-	sum := sha256.Sum256([]byte(deploymentJSON))
+	recorded := deployment.Annotations[clients.ConfigHashAnnotation]
+	if recorded == hash {
+		return ctrl.Result{}, nil
+	}
+
+	rollOut := recorded != "" && !reloading.Spec.Suspend
+	if rollOut {
+		workload := deploymentWorkload(deployment)
+		workload.Labels = deployment.Labels
+		conflicts, err := findConflicts(ctx, r.Client, &r.specs, newFlipperClaim(reloading, parsed), map[string]models.Workload{workload.Key(): workload})
+		if err != nil {
+			logger.Error(err, "failed to look for conflicting flippers")
+			return ctrl.Result{}, err
+		}
+		if owner := conflicts[workload.Key()].owner; owner != nil {
+			logger.Info("config changed, leaving the deployment to the flipper claiming it", "flipper", client.ObjectKeyFromObject(reloading), "claimedBy", owner.key)
+			rollOut = false
+		}
+	}
+
+	if rollOut {
+		if _, rolledBack := deployment.Annotations[clients.RolledBackAnnotation]; rolledBack {
+			logger.Info("config changed, rolling out deployment once its rollback is acknowledged", "flipper", client.ObjectKeyFromObject(reloading))
+			return r.deferReload(ctx, reloading, deployment, hash, fmt.Sprintf("deferred the rollout for its config change until the %s annotation is removed", clients.RolledBackAnnotation), 0)
+		}
+
+		now := time.Now()
+		deferral, err := parsed.windows.Defer(now)
+		switch {
+		case err != nil:
+			logger.Error(err, "skipping the rollout of deployment for its config change")
+			r.recordReloadEvent(reloading, deployment, corev1.EventTypeWarning, reasonRunSkipped, fmt.Sprintf("skipped the rollout for its config change: %s", err), "")
+			rollOut = false
+		case deferral != nil:
+			logger.Info("config changed, deferring the rollout of deployment", "flipper", client.ObjectKeyFromObject(reloading), "reason", deferral.Reason, "until", deferral.Until)
+			return r.deferReload(ctx, reloading, deployment, hash, fmt.Sprintf("deferred the rollout for its config change until %s: %s", deferral.Until.UTC().Format(time.RFC3339), deferral.Message), deferral.Until.Sub(now))
+		}
+	}
+
+	if rollOut {
+		err = r.KraftClients.CheckDisruptionBudgets(ctx, deploymentWorkload(deployment))
+		if clients.IsBlockedByDisruptionBudget(err) {
+			logger.Info("config changed, deferring the rollout of deployment", "flipper", client.ObjectKeyFromObject(reloading), "reason", err.Error())
+			return r.deferReload(ctx, reloading, deployment, hash, fmt.Sprintf("deferred the rollout for its config change: %s", err), reloadRetryInterval)
+		}
+		if err != nil {
+			logger.Error(err, "failed to check the pod disruption budgets of the deployment")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if rollOut && (reloading.Spec.DryRun || r.DryRun) {
+		if deployment.Annotations[clients.DryRunHashAnnotation] == hash {
+			return ctrl.Result{}, nil
+		}
+		err = r.markReported(ctx, deployment, clients.DryRunHashAnnotation, hash)
+		if err != nil {
+			logger.Error(err, "failed to record the dry run of the deployment")
+			return ctrl.Result{}, err
+		}
+
+		logger.Info("config changed, would have rolled out deployment", "flipper", client.ObjectKeyFromObject(reloading), "hash", hash)
+		spanCtx, endSpans := startReloadSpans(ctx, reloading, deployment, true)
+		run := r.recordReloadRun(spanCtx, reloading, deployment, v1beta1.RestartWouldRestart, fmt.Sprintf("would have rolled out as the config hash changed to %s", hash), true)
+		endSpans(run, nil)
+		r.recordReloadEvent(reloading, deployment, corev1.EventTypeNormal, reasonDryRun, "would have rolled out as its config changed", run)
+		return ctrl.Result{}, nil
+	}
+
+	if recorded != "" && reloading.Spec.Suspend {
+		if reloading.Spec.CatchUpPolicy == v1beta1.CatchUpRunOnce {
+			logger.Info("config changed, rolling out deployment once the flipper is resumed", "flipper", client.ObjectKeyFromObject(reloading))
+			return r.deferReload(ctx, reloading, deployment, hash, "deferred the rollout for its config change until the flipper is resumed", 0)
+		}
+		r.recordReloadEvent(reloading, deployment, corev1.EventTypeNormal, reasonRunSkipped, "skipped the rollout for its config change as the flipper is suspended", "")
+	}
 
-	patch := client.MergeFrom(deplInChange.DeepCopy())
+	patch := client.MergeFrom(deployment.DeepCopy())
 
-	annotations := deplInChange.Spec.Template.ObjectMeta.Annotations
-	if annotations == nil {
-		annotations = map[string]string{"updatedHash": string(sum[:])}
+	if deployment.Annotations == nil {
+		deployment.Annotations = make(map[string]string)
 	}
+	deployment.Annotations[clients.ConfigHashAnnotation] = hash
+	delete(deployment.Annotations, clients.DryRunHashAnnotation)
+	delete(deployment.Annotations, clients.DeferredHashAnnotation)
 
-	deplInChange.Spec.Template.ObjectMeta.Annotations = annotations
+	endSpans := func(string, error) {}
+	if rollOut {
+		ctx, endSpans = startReloadSpans(ctx, reloading, deployment, false)
+		if deployment.Spec.Template.Annotations == nil {
+			deployment.Spec.Template.Annotations = make(map[string]string)
+		}
+		deployment.Spec.Template.Annotations[clients.ConfigHashAnnotation] = hash
+		deployment.Spec.Template.Annotations[clients.RestartTimeAnnotation] = clients.RestartAnnotation(ctx, time.Now())
+	}
 
-	err = r.Patch(ctx, deplInChange, patch)
+	err = r.Patch(ctx, deployment, patch)
 	if err != nil {
-		logger.Error(err, "failed to restart the deployment")
+		endSpans("", err)
+		logger.Error(err, "failed to record the config hash of the deployment")
+		if rollOut {
+			countRestart(client.ObjectKeyFromObject(reloading), deployment.Namespace, v1beta1.RestartFailed)
+			r.recordReloadEvent(reloading, deployment, corev1.EventTypeWarning, reasonRestartFailed, fmt.Sprintf("failed to roll out for its config change: %s", err), "")
+		}
 		return ctrl.Result{}, err
 	}
 
+	if rollOut {
+		logger.Info("config changed, rolling out deployment", "flipper", client.ObjectKeyFromObject(reloading), "hash", hash)
+		countRestart(client.ObjectKeyFromObject(reloading), deployment.Namespace, v1beta1.RestartSucceeded)
+		run := r.recordReloadRun(ctx, reloading, deployment, v1beta1.RestartSucceeded, fmt.Sprintf("rolled out as the config hash changed to %s", hash), false)
+		endSpans(run, nil)
+		r.recordReloadEvent(reloading, deployment, corev1.EventTypeNormal, reasonRestarted, "rolled out as its config changed", run)
+	}
+
 	return ctrl.Result{}, nil
 }
 
+// markReported sets annotation of deployment to hash, the config hash of a
+// change that was reported without being rolled out.
+func (r *DeploymentReconciler) markReported(ctx context.Context, deployment *appsv1.Deployment, annotation string, hash string) error {
+	patch := client.MergeFrom(deployment.DeepCopy())
+
+	if deployment.Annotations == nil {
+		deployment.Annotations = make(map[string]string)
+	}
+	deployment.Annotations[annotation] = hash
+
+	return r.Patch(ctx, deployment, patch)
+}
+
+// deferReload reports that flipper deferred the rollout of deployment for the
+// config change to hash with message, once per hash, and requeues deployment
+// after requeueAfter to try again, unless it is zero.
+func (r *DeploymentReconciler) deferReload(ctx context.Context, flipper *v1beta1.Flipper, deployment *appsv1.Deployment, hash string, message string, requeueAfter time.Duration) (ctrl.Result, error) {
+	result := ctrl.Result{RequeueAfter: requeueAfter}
+	if deployment.Annotations[clients.DeferredHashAnnotation] == hash {
+		return result, nil
+	}
+
+	err := r.markReported(ctx, deployment, clients.DeferredHashAnnotation, hash)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to record the deferred rollout of the deployment")
+		return ctrl.Result{}, err
+	}
+
+	r.recordReloadEvent(flipper, deployment, corev1.EventTypeNormal, reasonRunDeferred, message, "")
+	return result, nil
+}
+
+// reloadIndex indexes the Flippers that reload on config changes by the
+// namespace they match workloads in, or anyNamespace when they may match
+// workloads in more than one.
+const reloadIndex = "spec.match.reloadNamespace"
+
+// anyNamespace is the reloadIndex value of Flippers matching workloads in
+// more than one namespace.
+const anyNamespace = "*"
+
+// indexReloadingFlippers returns the reloadIndex values of object, a Flipper.
+func indexReloadingFlippers(object client.Object) []string {
+	flipper, ok := object.(*v1beta1.Flipper)
+	if !ok || !flipper.Spec.ReloadOnConfigChange {
+		return nil
+	}
+
+	config := models.ConfigFromFlipper(*flipper)
+	if config.Namespace != "" && config.NamespaceSelector == nil && !config.AllNamespaces {
+		return []string{config.Namespace}
+	}
+
+	return []string{anyNamespace}
+}
+
+// reloadingFlipper returns the Flipper that reloads deployment on config
+// changes, along with its parsed spec, and nil if there is none. When several
+// do, the one whose claim precedes the others wins, as it would for scheduled
+// restarts. Only the Flippers that may match workloads in the namespace of
+// deployment are looked at.
+func (r *DeploymentReconciler) reloadingFlipper(ctx context.Context, deployment *appsv1.Deployment) (*v1beta1.Flipper, *parsedConfig, error) {
+	var flippers []v1beta1.Flipper
+	for _, namespace := range []string{deployment.Namespace, anyNamespace} {
+		flipperList := &v1beta1.FlipperList{}
+		err := r.List(ctx, flipperList, client.MatchingFields{reloadIndex: namespace})
+		if err != nil {
+			return nil, nil, err
+		}
+		flippers = append(flippers, flipperList.Items...)
+	}
+
+	var reloading *v1beta1.Flipper
+	var reloadingParsed *parsedConfig
+	var reloadingClaim flipperClaim
+	workload := deploymentWorkload(deployment)
+	for idx := range flippers {
+		flipper := &flippers[idx]
+		if !flipper.Spec.ReloadOnConfigChange || flipper.DeletionTimestamp != nil {
+			continue
+		}
+
+		parsed, err := r.specs.get(flipper)
+		if err != nil {
+			continue
+		}
+
+		matches, err := parsed.targets.Matches(ctx, r.Client, workload, deployment.Labels)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !matches {
+			continue
+		}
+
+		claim := newFlipperClaim(flipper, parsed)
+		if reloading == nil || claim.precedes(reloadingClaim) {
+			reloading, reloadingParsed, reloadingClaim = flipper, parsed, claim
+		}
+	}
+
+	return reloading, reloadingParsed, nil
+}
+
+// recordReloadRun records the rollout of deployment, whose config changed, as
+// an Event run of flipper with result and message, notifies of it and returns
+// the name of the run, empty if it could not be recorded.
+func (r *DeploymentReconciler) recordReloadRun(ctx context.Context, flipper *v1beta1.Flipper, deployment *appsv1.Deployment, result string, message string, dryRun bool) string {
+	now := time.Now()
+	record, err := recordReload(ctx, r.Client, r.Scheme, flipper, deploymentWorkload(deployment), result, message, dryRun, now)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to record flipper run")
+	}
+	if record == nil {
+		return ""
+	}
+
+	r.Notifications.send(ctx, r.Client, r.Recorder, flipper, runNotification(notify.RunSucceeded, record, record.Status.Targets, now))
+
+	return record.Name
+}
+
+// recordReloadEvent records what happened to deployment, whose config changed,
+// in events on both flipper and deployment, each naming the other, along with
+// the FlipperRun that recorded it, if any.
+func (r *DeploymentReconciler) recordReloadEvent(flipper *v1beta1.Flipper, deployment *appsv1.Deployment, eventType string, reason string, message string, run string) {
+	if run != "" {
+		message = fmt.Sprintf("%s in run %s", message, run)
+	}
+
+	r.Recorder.Eventf(flipper, eventType, reason, "Deployment %s/%s: %s", deployment.Namespace, deployment.Name, message)
+	r.Recorder.Eventf(deployment, eventType, reason, "Flipper %s/%s: %s", flipper.Namespace, flipper.Name, message)
+}
+
+// deploymentWorkload describes deployment as a workload.
+func deploymentWorkload(deployment *appsv1.Deployment) models.Workload {
+	return models.Workload{
+		Type:      models.BuiltinWorkloadTypes[models.KindDeployment],
+		Namespace: deployment.Namespace,
+		Name:      deployment.Name,
+	}
+}
+
+// referencingDeployments requeues the Deployments that reference object, a
+// ConfigMap or a Secret.
+func (r *DeploymentReconciler) referencingDeployments(object client.Object) []reconcile.Request {
+	deploymentList := &appsv1.DeploymentList{}
+	err := r.List(context.Background(), deploymentList, client.InNamespace(object.GetNamespace()), client.MatchingFields{configIndex: configKey(object)})
+	if err != nil {
+		log.Log.Error(err, "failed to list deployments", "namespace", object.GetNamespace())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(deploymentList.Items))
+	for _, deployment := range deploymentList.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name},
+		})
+	}
+
+	return requests
+}
+
+// flipperDeployments requeues the Deployments a Flipper that reloads on
+// config changes matches, so that their hashes are recorded before the config
+// changes.
+func (r *DeploymentReconciler) flipperDeployments(object client.Object) []reconcile.Request {
+	flipper, ok := object.(*v1beta1.Flipper)
+	if !ok || !flipper.Spec.ReloadOnConfigChange {
+		return nil
+	}
+
+	selector, err := matching.NewSelector(models.ConfigFromFlipper(*flipper))
+	if err != nil {
+		return nil
+	}
+
+	targets, err := matching.Targets(context.Background(), r.Client, selector)
+	if err != nil {
+		log.Log.Error(err, "failed to list flipper targets", "flipper", client.ObjectKeyFromObject(flipper))
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, workload := range targets {
+		if workload.Type.Kind != models.KindDeployment || workload.Type.APIVersion != appsv1.SchemeGroupVersion.String() {
+			continue
+		}
+
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: workload.Namespace, Name: workload.Name},
+		})
+	}
+
+	return requests
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *DeploymentReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	deploymentsList := &appsv1.DeploymentList{}
-	err := r.List(context.Background(), deploymentsList)
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &appsv1.Deployment{}, configIndex, indexDeploymentConfigs)
 	if err != nil {
 		return err
 	}
 
-	for idx := 0; idx < len(deploymentsList.Items); idx++ {
-		r.Deployments[fmt.Sprintf("%s.%s", deploymentsList.Items[idx].Namespace, deploymentsList.Items[idx].Name)] = deploymentsList.Items[idx]
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &v1beta1.Flipper{}, reloadIndex, indexReloadingFlippers)
+	if err != nil {
+		return err
 	}
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&appsv1.Deployment{}).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.referencingDeployments)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.referencingDeployments)).
+		Watches(&source.Kind{Type: &v1beta1.Flipper{}}, handler.EnqueueRequestsFromMapFunc(r.flipperDeployments)).
 		Complete(r)
 }
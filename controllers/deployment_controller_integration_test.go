@@ -0,0 +1,91 @@
+//go:build integration
+// +build integration
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/clients"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Reloading deployments on config changes", func() {
+	const timeout = 30 * time.Second
+	const interval = 250 * time.Millisecond
+	ctx := context.Background()
+
+	It("rolls out deployments once their config changes", func() {
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "reload-"}}
+		Expect(k8sClient.Create(ctx, namespace)).To(Succeed())
+
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Name, Name: "settings"},
+			Data:       map[string]string{"level": "info"},
+		}
+		Expect(k8sClient.Create(ctx, configMap)).To(Succeed())
+
+		labels := map[string]string{"app": "web"}
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Name, Name: "web", Labels: labels},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{
+						Name:    "web",
+						Image:   "nginx",
+						EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}}},
+					}}},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, deployment)).To(Succeed())
+
+		flipper := &v1beta1.Flipper{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Name, Name: "reload"},
+			Spec: v1beta1.FlipperSpec{
+				ReloadOnConfigChange: true,
+				Match:                v1beta1.Match{Kinds: []v1beta1.WorkloadKind{v1beta1.WorkloadKind("Deployment")}, Labels: labels},
+			},
+		}
+		Expect(k8sClient.Create(ctx, flipper)).To(Succeed())
+
+		By("recording the first hash without rolling out")
+		key := client.ObjectKeyFromObject(deployment)
+		Eventually(func() string {
+			Expect(k8sClient.Get(ctx, key, deployment)).To(Succeed())
+			return deployment.Annotations[clients.ConfigHashAnnotation]
+		}, timeout, interval).ShouldNot(BeEmpty())
+		first := deployment.Annotations[clients.ConfigHashAnnotation]
+		Expect(deployment.Spec.Template.Annotations).NotTo(HaveKey(clients.ConfigHashAnnotation))
+
+		By("rolling out once the config changes")
+		configMap.Data["level"] = "debug"
+		Expect(k8sClient.Update(ctx, configMap)).To(Succeed())
+		Eventually(func() string {
+			Expect(k8sClient.Get(ctx, key, deployment)).To(Succeed())
+			return deployment.Spec.Template.Annotations[clients.ConfigHashAnnotation]
+		}, timeout, interval).ShouldNot(BeEmpty())
+		Expect(deployment.Spec.Template.Annotations[clients.ConfigHashAnnotation]).NotTo(Equal(first))
+		Expect(deployment.Spec.Template.Annotations[clients.ConfigHashAnnotation]).To(Equal(deployment.Annotations[clients.ConfigHashAnnotation]))
+
+		runList := &v1beta1.FlipperRunList{}
+		Eventually(func() []v1beta1.FlipperRun {
+			Expect(k8sClient.List(ctx, runList, client.InNamespace(namespace.Name))).To(Succeed())
+			return runList.Items
+		}, timeout, interval).Should(HaveLen(1))
+		Expect(runList.Items[0].Spec.Trigger).To(Equal(v1beta1.TriggerEvent))
+		Expect(runList.Items[0].Status.Targets).To(HaveLen(1))
+		Expect(runList.Items[0].Status.Targets[0].Name).To(Equal("web"))
+	})
+})
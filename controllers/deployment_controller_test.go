@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/clients"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newReloadedDeployment returns the Deployment web in the apps namespace,
+// whose config hash was recorded before the ConfigMap settings it reads its
+// env from changed, along with that ConfigMap.
+func newReloadedDeployment() (*appsv1.Deployment, *corev1.ConfigMap) {
+	labels := map[string]string{"app": "web"}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "settings"},
+		Data:       map[string]string{"level": "debug"},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "apps",
+			Name:        "web",
+			Labels:      labels,
+			Annotations: map[string]string{clients.ConfigHashAnnotation: "before"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:    "web",
+					EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}}},
+				}}},
+			},
+		},
+	}
+
+	return deployment, configMap
+}
+
+// newReloadingFlipper returns a Flipper that only reloads the Deployments of
+// newScheduledFlipper on config changes.
+func newReloadingFlipper(name string, mutate func(*v1beta1.Flipper)) *v1beta1.Flipper {
+	return newScheduledFlipper(name, func(flipper *v1beta1.Flipper) {
+		flipper.Spec.Schedule = ""
+		flipper.Spec.ReloadOnConfigChange = true
+		flipper.Spec.Match.Kinds = []v1beta1.WorkloadKind{v1beta1.WorkloadKind("Deployment")}
+		if mutate != nil {
+			mutate(flipper)
+		}
+	})
+}
+
+// newTestDeploymentReconciler returns a DeploymentReconciler serving objects
+// from fake clients, along with the recorder of its events.
+func newTestDeploymentReconciler(objects ...client.Object) (*DeploymentReconciler, *record.FakeRecorder) {
+	fakeClient := newFakeClient(objects...)
+	recorder := record.NewFakeRecorder(10)
+
+	return &DeploymentReconciler{Client: fakeClient, Scheme: fakeClient.Scheme(), KraftClients: newFakeKraftClients(objects...), Recorder: recorder}, recorder
+}
+
+var _ = Describe("Reporting config changes without rolling out", func() {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name   string
+		mutate func(*v1beta1.Flipper)
+		reason string
+		runs   int
+	}{
+		{
+			name:   "reports dry runs once per config change",
+			mutate: func(flipper *v1beta1.Flipper) { flipper.Spec.DryRun = true },
+			reason: reasonDryRun,
+			runs:   1,
+		},
+		{
+			name: "reports deferred rollouts once per config change",
+			mutate: func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Suspend = true
+				flipper.Spec.CatchUpPolicy = v1beta1.CatchUpRunOnce
+			},
+			reason: reasonRunDeferred,
+		},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			deployment, configMap := newReloadedDeployment()
+			reconciler, recorder := newTestDeploymentReconciler(configMap, deployment, newReloadingFlipper("reload", tc.mutate))
+			fakeClient := reconciler.Client
+			req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deployment)}
+
+			reconcileTwice := func() {
+				for i := 0; i < 2; i++ {
+					_, err := reconciler.Reconcile(ctx, req)
+					Expect(err).NotTo(HaveOccurred())
+				}
+			}
+			runs := func() int {
+				runList := &v1beta1.FlipperRunList{}
+				Expect(fakeClient.List(ctx, runList)).To(Succeed())
+				return len(runList.Items)
+			}
+
+			reconcileTwice()
+			// Each report is an event on both the Flipper and the Deployment.
+			Expect(recorder.Events).To(HaveLen(2))
+			Expect(<-recorder.Events).To(ContainSubstring(tc.reason))
+			Expect(<-recorder.Events).To(ContainSubstring(tc.reason))
+			Expect(runs()).To(Equal(tc.runs))
+
+			Expect(fakeClient.Get(ctx, req.NamespacedName, deployment)).To(Succeed())
+			Expect(deployment.Annotations[clients.ConfigHashAnnotation]).To(Equal("before"))
+			Expect(deployment.Spec.Template.Annotations).NotTo(HaveKey(clients.ConfigHashAnnotation))
+
+			By("reporting the next config change again")
+			configMap.Data["level"] = "info"
+			Expect(fakeClient.Update(ctx, configMap)).To(Succeed())
+
+			reconcileTwice()
+			Expect(recorder.Events).To(HaveLen(2))
+			Expect(runs()).To(Equal(2 * tc.runs))
+		})
+	}
+})
+
+var _ = Describe("Picking the flipper that reloads a deployment", func() {
+	It("leaves config changes to the flipper whose claim precedes the others", func() {
+		deployment, configMap := newReloadedDeployment()
+		// The first flipper listed would only report the change as a dry run.
+		dryRun := newReloadingFlipper("a-reload", func(flipper *v1beta1.Flipper) { flipper.Spec.DryRun = true })
+		preceding := newReloadingFlipper("b-reload", func(flipper *v1beta1.Flipper) {
+			flipper.Spec.Priority = 10
+			flipper.Spec.Suspend = true
+			flipper.Spec.CatchUpPolicy = v1beta1.CatchUpRunOnce
+		})
+		reconciler, recorder := newTestDeploymentReconciler(configMap, deployment, dryRun, preceding)
+
+		_, err := reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deployment)})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(recorder.Events).To(HaveLen(2))
+		Expect(<-recorder.Events).To(ContainSubstring(reasonRunDeferred))
+		Expect(<-recorder.Events).To(SatisfyAll(ContainSubstring(reasonRunDeferred), ContainSubstring("apps/b-reload")))
+	})
+})
+
+var _ = Describe("Holding config changes back", func() {
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		name             string
+		objects          []client.Object
+		mutateFlipper    func(*v1beta1.Flipper)
+		mutateDeployment func(*appsv1.Deployment)
+		reason           string
+		requeueAfter     time.Duration
+		rolledOut        bool
+		recorded         bool
+	}{
+		{
+			name:      "rolls out deployments nothing holds back",
+			reason:    reasonRestarted,
+			rolledOut: true,
+			recorded:  true,
+		},
+		{
+			name: "defers rollouts during a blackout until it ends",
+			mutateFlipper: func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Blackouts = []v1beta1.Blackout{{
+					Start:  metav1.NewTime(time.Now().Add(-time.Hour)),
+					End:    metav1.NewTime(time.Now().Add(time.Hour)),
+					Reason: "release freeze",
+				}}
+			},
+			reason:       reasonRunDeferred,
+			requeueAfter: time.Hour,
+		},
+		{
+			name: "defers rollouts of rolled back deployments until the rollback is acknowledged",
+			mutateDeployment: func(deployment *appsv1.Deployment) {
+				deployment.Annotations[clients.RolledBackAnnotation] = time.Now().Format(time.RFC3339)
+			},
+			reason: reasonRunDeferred,
+		},
+		{
+			name: "defers rollouts while a budget allows no disruption",
+			objects: []client.Object{
+				&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "web-0", Labels: map[string]string{"app": "web"}}},
+				&policyv1.PodDisruptionBudget{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "web"},
+					Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+				},
+			},
+			reason:       reasonRunDeferred,
+			requeueAfter: reloadRetryInterval,
+		},
+		{
+			name:     "leaves deployments claimed by another flipper to it",
+			objects:  []client.Object{newScheduledFlipper("nightly", func(flipper *v1beta1.Flipper) { flipper.Spec.Priority = 10 })},
+			recorded: true,
+		},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			deployment, configMap := newReloadedDeployment()
+			if tc.mutateDeployment != nil {
+				tc.mutateDeployment(deployment)
+			}
+			objects := append([]client.Object{configMap, deployment, newReloadingFlipper("reload", tc.mutateFlipper)}, tc.objects...)
+			reconciler, recorder := newTestDeploymentReconciler(objects...)
+			req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(deployment)}
+
+			for i := 0; i < 2; i++ {
+				result, err := reconciler.Reconcile(ctx, req)
+				Expect(err).NotTo(HaveOccurred())
+				if i == 0 {
+					Expect(result.RequeueAfter).To(BeNumerically("~", tc.requeueAfter, time.Minute))
+				}
+			}
+
+			// Each report is an event on both the Flipper and the Deployment,
+			// and deferrals are reported once.
+			if tc.reason == "" {
+				Expect(recorder.Events).To(BeEmpty())
+			} else {
+				Expect(recorder.Events).To(HaveLen(2))
+				Expect(<-recorder.Events).To(ContainSubstring(tc.reason))
+				Expect(<-recorder.Events).To(ContainSubstring(tc.reason))
+			}
+
+			Expect(reconciler.Get(ctx, req.NamespacedName, deployment)).To(Succeed())
+			if tc.recorded {
+				Expect(deployment.Annotations[clients.ConfigHashAnnotation]).NotTo(Equal("before"))
+			} else {
+				Expect(deployment.Annotations[clients.ConfigHashAnnotation]).To(Equal("before"))
+				Expect(deployment.Annotations).To(HaveKey(clients.DeferredHashAnnotation))
+			}
+			if tc.rolledOut {
+				Expect(deployment.Spec.Template.Annotations).To(HaveKey(clients.ConfigHashAnnotation))
+			} else {
+				Expect(deployment.Spec.Template.Annotations).NotTo(HaveKey(clients.ConfigHashAnnotation))
+			}
+		})
+	}
+})
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/clients"
+	"github.com/anmolbabu/kraft-controller/models"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// targetObject refers to workload in events.
+func targetObject(workload models.Workload) runtime.Object {
+	return &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: workload.Type.APIVersion, Kind: workload.Type.Kind},
+		ObjectMeta: metav1.ObjectMeta{Namespace: workload.Namespace, Name: workload.Name, UID: workload.UID},
+	}
+}
+
+// recordRunEvent records an event about a whole run on flipper and on each of
+// targets, where it names flipper.
+func (r *FlipperReconciler) recordRunEvent(flipper *v1beta1.Flipper, targets map[string]models.Workload, eventType string, reason string, message string) {
+	r.Recorder.Event(flipper, eventType, reason, message)
+
+	for _, workload := range targets {
+		r.Recorder.Eventf(targetObject(workload), eventType, reason, "Flipper %s/%s: %s", flipper.Namespace, flipper.Name, message)
+	}
+}
+
+// recordRunStart records the start of run, by trigger, on flipper and on each
+// of its targets.
+func (r *FlipperReconciler) recordRunStart(flipper *v1beta1.Flipper, run *flipperRun, trigger v1beta1.RunTrigger) {
+	r.Recorder.Eventf(flipper, corev1.EventTypeNormal, reasonRunStarted, "Started %s run %s of %d targets", trigger, run.record, len(run.workloads))
+
+	for _, workload := range run.workloads {
+		r.Recorder.Eventf(targetObject(workload), corev1.EventTypeNormal, reasonRestartScheduled, "Scheduled for restart by flipper %s/%s in run %s", flipper.Namespace, flipper.Name, run.record)
+	}
+}
+
+// recordRunResults records how each target of run fared on flipper and on the
+// target, and how the run as a whole did on flipper.
+func (r *FlipperReconciler) recordRunResults(flipper *v1beta1.Flipper, run *flipperRun, results map[string]error) {
+	for _, workload := range run.workloads {
+		err, ran := results[workload.Key()]
+		if ran {
+			r.recordResult(flipper, run.record, workload, err)
+		}
+	}
+
+	if runPhase(runTargets(run.workloads, results)) == v1beta1.RunFailed {
+		r.Recorder.Eventf(flipper, corev1.EventTypeWarning, reasonRunFailed, "Run %s completed with failures, %d of %d targets finished", run.record, len(results), len(run.workloads))
+		return
+	}
+
+	r.Recorder.Eventf(flipper, corev1.EventTypeNormal, reasonRunCompleted, "Run %s completed, %d of %d targets finished", run.record, len(results), len(run.workloads))
+}
+
+// recordResult records the result of the restart of workload in run, err, on
+// flipper and on workload.
+func (r *FlipperReconciler) recordResult(flipper *v1beta1.Flipper, run string, workload models.Workload, err error) {
+	eventType, reason, action := corev1.EventTypeNormal, reasonRestarted, "Restarted"
+
+	result, message := restartResult(err)
+	switch result {
+	case v1beta1.RestartRolledBack:
+		r.recordRollback(flipper, run, workload, err)
+		return
+	case v1beta1.RestartFailed:
+		eventType, reason, action = corev1.EventTypeWarning, reasonRestartFailed, "Failed to restart"
+	case v1beta1.RestartBlocked:
+		eventType, reason, action = corev1.EventTypeWarning, reasonRestartBlocked, "Did not restart"
+	case v1beta1.RestartCoalesced, v1beta1.RestartNotDue, v1beta1.RestartSkipped:
+		reason, action = reasonRestartSkipped, "Skipped"
+	case v1beta1.RestartWouldRestart:
+		reason, action = reasonDryRun, "Would have restarted"
+	}
+
+	detail := ""
+	if message != "" {
+		detail = ": " + message
+	}
+
+	r.Recorder.Eventf(flipper, eventType, reason, "%s %s %s/%s in run %s%s", action, workload.Type.Kind, workload.Namespace, workload.Name, run, detail)
+	r.Recorder.Eventf(targetObject(workload), eventType, reason, "%s by flipper %s/%s in run %s%s", action, flipper.Namespace, flipper.Name, run, detail)
+}
+
+// recordRollback records the rollback of workload in run in events on both
+// flipper and workload.
+func (r *FlipperReconciler) recordRollback(flipper *v1beta1.Flipper, run string, workload models.Workload, err error) {
+	r.Recorder.Eventf(flipper, corev1.EventTypeWarning, reasonRolledBack, "Rolled back %s %s/%s in run %s and paused its restarts: %s", workload.Type.Kind, workload.Namespace, workload.Name, run, err)
+	r.Recorder.Eventf(targetObject(workload), corev1.EventTypeWarning, reasonRolledBack, "Rolled back by flipper %s/%s in run %s, remove the %s annotation to resume restarts: %s", flipper.Namespace, flipper.Name, run, clients.RolledBackAnnotation, err)
+}
+
+// dueRun names a run that did not start, and so has no FlipperRun, by when
+// it came due.
+func dueRun(dueTime time.Time) string {
+	return fmt.Sprintf("the run due at %s", dueTime.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,794 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/clients"
+	"github.com/anmolbabu/kraft-controller/matching"
+	"github.com/anmolbabu/kraft-controller/models"
+	"github.com/anmolbabu/kraft-controller/notify"
+	"github.com/anmolbabu/kraft-controller/schedule"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	reasonInvalidSpec     = "InvalidSpec"
+	reasonScheduled       = "Scheduled"
+	reasonReloadOnly      = "ReloadOnly"
+	reasonSuspended       = "Suspended"
+	reasonNotSuspended    = "NotSuspended"
+	reasonRestartFailed   = "RestartFailed"
+	reasonRestartsOK      = "RestartsSucceeded"
+	reasonTargetsConflict = "TargetsConflict"
+	reasonNoConflicts     = "NoConflicts"
+	reasonRolloutStuck    = "RolloutStuck"
+	reasonRolledBack      = "RolledBack"
+	reasonDryRun          = "DryRun"
+
+	reasonRunStarted       = "RunStarted"
+	reasonRunCompleted     = "RunCompleted"
+	reasonRunFailed        = "RunFailed"
+	reasonRunSkipped       = "RunSkipped"
+	reasonRunDeferred      = "RunDeferred"
+	reasonRestartScheduled = "RestartScheduled"
+	reasonRestarted        = "Restarted"
+	reasonRestartSkipped   = "RestartSkipped"
+	reasonRestartBlocked   = "RestartBlocked"
+
+	// runEventsBuffer is how many progress notifications of runs can be
+	// queued up before a run waits for them to be picked up.
+	runEventsBuffer = 128
+
+	// defaultCoalesceWindow is how long after a restart a workload is not
+	// restarted again, unless the Flipper says otherwise.
+	defaultCoalesceWindow = time.Minute
+)
+
+// flipperSchedule tracks when a Flipper is next due to restart its targets.
+type flipperSchedule struct {
+	generation int64
+	nextRun    time.Time
+}
+
+// FlipperReconciler reconciles a Flipper object
+type FlipperReconciler struct {
+	client.Client
+	Scheme        *runtime.Scheme
+	KraftClients  *clients.KraftClients
+	Recorder      record.EventRecorder
+	Notifications *Notifications
+	// DryRun makes every Flipper a dry run, whatever its spec says.
+	DryRun bool
+
+	specs parsedSpecs
+	// lock guards schedules and runs, which the reconciles of different
+	// Flippers share.
+	lock      sync.Mutex
+	schedules map[types.NamespacedName]flipperSchedule
+	runs      map[types.NamespacedName]*flipperRun
+	runEvents chan event.GenericEvent
+}
+
+// runPlan is what a run of a Flipper restarts and how, along with when the
+// selection of its targets started and ended.
+type runPlan struct {
+	config      *models.Config
+	parsed      *parsedConfig
+	owned       map[string]models.Workload
+	matched     int
+	selectStart time.Time
+	selected    time.Time
+}
+
+//+kubebuilder:rbac:groups=flipper.flipper.io,resources=flippers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=flipper.flipper.io,resources=flippers/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=flipper.flipper.io,resources=flippers/finalizers,verbs=update
+//+kubebuilder:rbac:groups=flipper.flipper.io,resources=flipperruns,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=flipper.flipper.io,resources=flipperruns/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=statefulsets;daemonsets,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile keeps the restart schedule of a Flipper in sync with its spec.
+// Every time the Flipper is due, the workloads it matches are restarted and
+// the request is requeued for the next run.
+func (r *FlipperReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	flipper := &v1beta1.Flipper{}
+	err := r.Get(ctx, req.NamespacedName, flipper)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("flipper deleted, dropping its schedule")
+			r.removeSchedule(req.NamespacedName)
+			r.specs.forget(req.NamespacedName)
+			flipperMetrics.forget(req.NamespacedName)
+			if run := r.removeRun(req.NamespacedName); run != nil {
+				run.cancel()
+			}
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "failed to fetch flipper")
+		return ctrl.Result{}, err
+	}
+
+	// The API server stores times with a precision of seconds, anything finer
+	// would make every status update look like a change.
+	now := time.Now().Truncate(time.Second)
+	original := flipper.DeepCopy()
+
+	flipper.Status.ObservedGeneration = flipper.Generation
+	flipper.Status.DeferredRun = nil
+
+	config := models.ConfigFromFlipper(*flipper)
+	config.DryRun = config.DryRun || r.DryRun
+	if config.Suspend {
+		setCondition(flipper, v1beta1.ConditionSuspended, metav1.ConditionTrue, reasonSuspended, "no runs are started until spec.suspend is cleared")
+	} else {
+		setCondition(flipper, v1beta1.ConditionSuspended, metav1.ConditionFalse, reasonNotSuspended, "")
+	}
+
+	parsed, err := r.specs.get(flipper)
+	if err != nil {
+		// The spec has to change before this can succeed, which triggers a
+		// new reconcile on its own, so there is no point in requeueing.
+		logger.Error(err, "invalid flipper spec")
+		r.removeSchedule(req.NamespacedName)
+		flipper.Status.NextRunTime = nil
+		setCondition(flipper, v1beta1.ConditionReady, metav1.ConditionFalse, reasonInvalidSpec, err.Error())
+		flipperMetrics.update(flipper)
+		return ctrl.Result{}, r.patchStatus(ctx, original, flipper)
+	}
+
+	selectStart := time.Now()
+	targets, err := matching.Targets(ctx, r.Client, parsed.targets)
+	if err != nil {
+		logger.Error(err, "failed to list flipper targets")
+		return ctrl.Result{}, err
+	}
+
+	conflicts, err := findConflicts(ctx, r.Client, &r.specs, newFlipperClaim(flipper, parsed), targets)
+	if err != nil {
+		logger.Error(err, "failed to look for conflicting flippers")
+		return ctrl.Result{}, err
+	}
+
+	// Workloads claimed by another Flipper are restarted by that one only,
+	// and workloads that were rolled back by none until someone acknowledges
+	// the rollback.
+	owned := make(map[string]models.Workload, len(targets))
+	paused := 0
+	for key, workload := range targets {
+		if _, rolledBack := workload.Annotations[clients.RolledBackAnnotation]; rolledBack {
+			paused++
+			continue
+		}
+		if conflicts[key].owner == nil {
+			owned[key] = workload
+		}
+	}
+	selected := time.Now()
+
+	// Flippers without a schedule only reload their targets on config
+	// changes, which the DeploymentReconciler takes care of. A run still in
+	// progress is cancelled, and its record failed along with any other one
+	// left Running.
+	if parsed.schedule == nil {
+		r.removeSchedule(req.NamespacedName)
+		if run := r.removeRun(req.NamespacedName); run != nil {
+			run.cancel()
+		}
+		err = r.pruneRunRecords(ctx, flipper, "", now)
+		if err != nil {
+			logger.Error(err, "failed to prune flipper runs")
+		}
+		flipper.Status.NextRunTime = nil
+		flipper.Status.ActiveRun = nil
+		flipper.Status.Targets = targetStatuses(flipper.Status.Targets, targets, conflicts, nil, now)
+		setCondition(flipper, v1beta1.ConditionReady, metav1.ConditionTrue, reasonReloadOnly, "targets are only restarted when their config changes")
+		flipperMetrics.update(flipper)
+		return ctrl.Result{}, r.patchStatus(ctx, original, flipper)
+	}
+
+	state := r.scheduleFor(req.NamespacedName, original, parsed.schedule, now)
+
+	// Record the results of a run once it is done.
+	var results map[string]error
+	if run, ok := r.activeRun(req.NamespacedName); ok {
+		runResults, done := run.progress()
+		if done {
+			r.removeRun(req.NamespacedName)
+			results = runResults
+			record, err := r.completeRunRecord(ctx, req.Namespace, run, results, now)
+			if err != nil {
+				logger.Error(err, "failed to record the results of flipper run")
+			}
+			flipper.Status.StuckTarget = stuckTarget(targets, results)
+			r.recordRunResults(flipper, run, results)
+			if record != nil {
+				r.notifyRunResults(ctx, flipper, record, now)
+			}
+		}
+	}
+
+	// Runs that come due while the Flipper is suspended are missed. The
+	// first one is remembered so that it can be caught up on once the
+	// Flipper is resumed.
+	if config.Suspend {
+		if !now.Before(state.nextRun) {
+			if flipper.Status.MissedRunTime == nil {
+				missedRunTime := metav1.NewTime(state.nextRun)
+				flipper.Status.MissedRunTime = &missedRunTime
+			}
+			logger.Info("skipping flipper run, the flipper is suspended")
+			r.recordRunEvent(flipper, owned, corev1.EventTypeNormal, reasonRunSkipped, fmt.Sprintf("Skipped %s as the flipper is suspended", dueRun(state.nextRun)))
+			state.nextRun = parsed.schedule.Next(now)
+		}
+	} else if flipper.Status.MissedRunTime != nil {
+		if config.CatchUpPolicy == string(v1beta1.CatchUpRunOnce) {
+			logger.Info("catching up on a run missed while suspended", "dueTime", flipper.Status.MissedRunTime.Time)
+			state.nextRun = now
+		}
+		flipper.Status.MissedRunTime = nil
+	}
+
+	plan := runPlan{
+		config:      config,
+		parsed:      parsed,
+		owned:       owned,
+		matched:     len(targets),
+		selectStart: selectStart,
+		selected:    selected,
+	}
+	requeueAt := state.nextRun
+	if !now.Before(state.nextRun) {
+		_, running := r.activeRun(req.NamespacedName)
+		deferral, err := parsed.windows.Defer(now)
+		switch {
+		case running:
+			logger.Info("skipping flipper run, the previous one is still in progress")
+			r.recordRunEvent(flipper, owned, corev1.EventTypeNormal, reasonRunSkipped, fmt.Sprintf("Skipped %s as the previous run is still in progress", dueRun(state.nextRun)))
+			state.nextRun = parsed.schedule.Next(now)
+			requeueAt = state.nextRun
+		case err != nil:
+			logger.Error(err, "skipping flipper run")
+			r.recordRunEvent(flipper, owned, corev1.EventTypeWarning, reasonRunSkipped, fmt.Sprintf("Skipped %s: %s", dueRun(state.nextRun), err))
+			state.nextRun = parsed.schedule.Next(now)
+			requeueAt = state.nextRun
+		case deferral != nil:
+			logger.Info("deferring flipper run", "reason", deferral.Reason, "until", deferral.Until)
+			flipper.Status.DeferredRun = &v1beta1.DeferredRun{
+				DueTime: metav1.NewTime(state.nextRun),
+				Until:   metav1.NewTime(deferral.Until),
+				Reason:  deferral.Reason,
+				Message: deferral.Message,
+			}
+			if original.Status.DeferredRun == nil || !original.Status.DeferredRun.DueTime.Time.Equal(state.nextRun) {
+				r.recordRunEvent(flipper, owned, corev1.EventTypeNormal, reasonRunDeferred, fmt.Sprintf("Deferred %s until %s: %s", dueRun(state.nextRun), deferral.Until.UTC().Format(time.RFC3339), deferral.Message))
+			}
+			requeueAt = deferral.Until
+		default:
+			logger.Info("restarting flipper targets", "count", len(owned), "claimedByOthers", len(targets)-len(owned)-paused, "paused", paused, "dryRun", config.DryRun)
+			err := r.beginRun(ctx, flipper, v1beta1.TriggerSchedule, "", plan, now)
+			if err != nil {
+				logger.Error(err, "failed to record flipper run")
+				return ctrl.Result{}, err
+			}
+			state.nextRun = parsed.schedule.Next(now)
+			requeueAt = state.nextRun
+		}
+	}
+
+	// A run requested through the run-now annotation starts right away, in
+	// and out of windows, and leaves the schedule alone. A scheduled run that
+	// just started covers it. One that is in progress delays it until it is
+	// done, and suspending the Flipper until it is resumed.
+	if token := flipper.Annotations[v1beta1.RunNowAnnotation]; token != "" && token != flipper.Status.LastRunNowToken {
+		_, running := r.activeRun(req.NamespacedName)
+		switch {
+		case config.Suspend:
+			logger.Info("delaying requested flipper run, the flipper is suspended", "token", token)
+		case running && flipper.Status.LastRunTime != nil && flipper.Status.LastRunTime.Time.Equal(now):
+			flipper.Status.LastRunNowToken = token
+		case running:
+			logger.Info("delaying requested flipper run, the previous one is still in progress", "token", token)
+		default:
+			logger.Info("restarting flipper targets on request", "token", token, "count", len(owned), "dryRun", config.DryRun)
+			err := r.beginRun(ctx, flipper, v1beta1.TriggerManual, token, plan, now)
+			if err != nil {
+				logger.Error(err, "failed to record flipper run")
+				return ctrl.Result{}, err
+			}
+			flipper.Status.LastRunNowToken = token
+		}
+	}
+
+	r.setSchedule(req.NamespacedName, state)
+
+	flipper.Status.ActiveRun = nil
+	activeRecord := ""
+	if run, ok := r.activeRun(req.NamespacedName); ok {
+		flipper.Status.ActiveRun = run.status()
+		activeRecord = run.record
+	}
+
+	err = r.pruneRunRecords(ctx, flipper, activeRecord, now)
+	if err != nil {
+		logger.Error(err, "failed to prune flipper runs")
+	}
+
+	nextRunTime := metav1.NewTime(state.nextRun)
+	flipper.Status.NextRunTime = &nextRunTime
+	flipper.Status.Targets = targetStatuses(flipper.Status.Targets, targets, conflicts, results, now)
+
+	if config.DryRun {
+		setCondition(flipper, v1beta1.ConditionReady, metav1.ConditionTrue, reasonDryRun, "runs only record which targets they would restart")
+	} else {
+		setCondition(flipper, v1beta1.ConditionReady, metav1.ConditionTrue, reasonScheduled, "")
+	}
+
+	var failed, conflicted, rolledBack []string
+	for _, target := range flipper.Status.Targets {
+		if target.RolledBackTime != nil {
+			rolledBack = append(rolledBack, fmt.Sprintf("%s %s/%s", target.Kind, target.Namespace, target.Name))
+		}
+		if target.Result == v1beta1.RestartFailed {
+			failed = append(failed, fmt.Sprintf("%s %s/%s", target.Kind, target.Namespace, target.Name))
+		}
+		if len(target.ConflictsWith) > 0 {
+			conflicted = append(conflicted, fmt.Sprintf("%s %s/%s", target.Kind, target.Namespace, target.Name))
+		}
+	}
+	switch {
+	case len(rolledBack) > 0:
+		setCondition(flipper, v1beta1.ConditionDegraded, metav1.ConditionTrue, reasonRolledBack, fmt.Sprintf("rolled back and paused until the %s annotation is removed: %s", clients.RolledBackAnnotation, strings.Join(rolledBack, ", ")))
+	case flipper.Status.StuckTarget != "":
+		setCondition(flipper, v1beta1.ConditionDegraded, metav1.ConditionTrue, reasonRolloutStuck, fmt.Sprintf("the last run stopped as the rollout of %s did not complete", flipper.Status.StuckTarget))
+	case len(failed) > 0:
+		setCondition(flipper, v1beta1.ConditionDegraded, metav1.ConditionTrue, reasonRestartFailed, fmt.Sprintf("failed to restart: %s", strings.Join(failed, ", ")))
+	default:
+		setCondition(flipper, v1beta1.ConditionDegraded, metav1.ConditionFalse, reasonRestartsOK, "")
+	}
+	if len(conflicted) > 0 {
+		setCondition(flipper, v1beta1.ConditionConflicted, metav1.ConditionTrue, reasonTargetsConflict, fmt.Sprintf("matched by other flippers too: %s", strings.Join(conflicted, ", ")))
+	} else {
+		setCondition(flipper, v1beta1.ConditionConflicted, metav1.ConditionFalse, reasonNoConflicts, "")
+	}
+
+	flipperMetrics.update(flipper)
+
+	err = r.patchStatus(ctx, original, flipper)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: requeueAt.Sub(now)}, nil
+}
+
+// parsedConfig is the schedule, windows and targets described by a config.
+// The schedule is nil for Flippers that only reload on config changes.
+type parsedConfig struct {
+	schedule       schedule.Schedule
+	windows        *schedule.Windows
+	targets        *matching.Selector
+	coalesceWindow time.Duration
+	maxConcurrent  int
+	rolloutTimeout time.Duration
+	maxPodAge      time.Duration
+}
+
+// parseConfig validates config and builds everything a run needs from it.
+func parseConfig(config *models.Config) (*parsedConfig, error) {
+	var restartSchedule schedule.Schedule
+	var err error
+	if config.Interval != "" || config.Schedule != "" || !config.ReloadOnConfigChange {
+		restartSchedule, err = schedule.Parse(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	windows, err := schedule.ParseWindows(config)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := matching.NewSelector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	coalesceWindow := defaultCoalesceWindow
+	if config.CoalesceWindow != "" {
+		coalesceWindow, err = time.ParseDuration(config.CoalesceWindow)
+		if err != nil {
+			return nil, fmt.Errorf("%w. invalid coalesceWindow: %s", err, config.CoalesceWindow)
+		}
+
+		if coalesceWindow < 0 {
+			return nil, fmt.Errorf("invalid coalesceWindow: %s. coalesceWindow must not be negative", config.CoalesceWindow)
+		}
+	}
+
+	switch config.CatchUpPolicy {
+	case "", string(v1beta1.CatchUpSkip), string(v1beta1.CatchUpRunOnce):
+	default:
+		return nil, fmt.Errorf("unsupported catchUpPolicy: %s", config.CatchUpPolicy)
+	}
+
+	maxConcurrent, err := parseStrategy(config.Strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	var rolloutTimeout time.Duration
+	if config.Strategy.RolloutTimeout != "" {
+		rolloutTimeout, err = time.ParseDuration(config.Strategy.RolloutTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("%w. invalid strategy rolloutTimeout: %s", err, config.Strategy.RolloutTimeout)
+		}
+
+		if rolloutTimeout <= 0 {
+			return nil, fmt.Errorf("invalid strategy rolloutTimeout: %s. rolloutTimeout must be positive", config.Strategy.RolloutTimeout)
+		}
+	}
+
+	var maxPodAge time.Duration
+	if config.MaxPodAge != "" {
+		maxPodAge, err = time.ParseDuration(config.MaxPodAge)
+		if err != nil {
+			return nil, fmt.Errorf("%w. invalid maxPodAge: %s", err, config.MaxPodAge)
+		}
+
+		if maxPodAge <= 0 {
+			return nil, fmt.Errorf("invalid maxPodAge: %s. maxPodAge must be positive", config.MaxPodAge)
+		}
+	}
+
+	return &parsedConfig{
+		schedule:       restartSchedule,
+		windows:        windows,
+		targets:        targets,
+		coalesceWindow: coalesceWindow,
+		maxConcurrent:  maxConcurrent,
+		rolloutTimeout: rolloutTimeout,
+		maxPodAge:      maxPodAge,
+	}, nil
+}
+
+// parseStrategy validates strategy and returns how many targets it restarts at
+// the same time, 0 for all of them.
+func parseStrategy(strategy models.Strategy) (int, error) {
+	switch strategy.Order {
+	case "", string(v1beta1.OrderName), string(v1beta1.OrderOldestRestartFirst), string(v1beta1.OrderPriority):
+	default:
+		return 0, fmt.Errorf("unsupported strategy order: %s", strategy.Order)
+	}
+
+	switch strategy.DisruptionBudgetPolicy {
+	case "", string(v1beta1.DisruptionBudgetWait), string(v1beta1.DisruptionBudgetSkip):
+	default:
+		return 0, fmt.Errorf("unsupported strategy disruptionBudgetPolicy: %s", strategy.DisruptionBudgetPolicy)
+	}
+
+	switch strategy.RestartMethod {
+	case "", string(v1beta1.RestartMethodPatch), string(v1beta1.RestartMethodEvict):
+	default:
+		return 0, fmt.Errorf("unsupported strategy restartMethod: %s", strategy.RestartMethod)
+	}
+
+	switch strategy.Type {
+	case "", string(v1beta1.StrategyParallel):
+		return 0, nil
+	case string(v1beta1.StrategySequential):
+		return 1, nil
+	case string(v1beta1.StrategyMaxConcurrent):
+		if strategy.MaxConcurrent < 1 {
+			return 0, fmt.Errorf("invalid strategy maxConcurrent: %d. maxConcurrent must be at least 1", strategy.MaxConcurrent)
+		}
+		return strategy.MaxConcurrent, nil
+	default:
+		return 0, fmt.Errorf("unsupported strategy type: %s", strategy.Type)
+	}
+}
+
+// restartOptions returns how the targets of a run of config are restarted.
+func restartOptions(config *models.Config, parsed *parsedConfig) clients.RestartOptions {
+	return clients.RestartOptions{
+		CoalesceWindow:           parsed.coalesceWindow,
+		MaxConcurrent:            parsed.maxConcurrent,
+		RolloutTimeout:           parsed.rolloutTimeout,
+		RollbackOnFailure:        config.Strategy.Rollback,
+		WaitForDisruptionBudgets: config.Strategy.DisruptionBudgetPolicy != string(v1beta1.DisruptionBudgetSkip),
+		Evict:                    config.Strategy.RestartMethod == string(v1beta1.RestartMethodEvict),
+		OrderByPodDeletionCost:   config.Strategy.OrderByPodDeletionCost,
+		MaxPodAge:                parsed.maxPodAge,
+		DryRun:                   config.DryRun,
+	}
+}
+
+// beginRun records a run of flipper started by trigger, with token for runs
+// requested through the run-now annotation, and starts restarting the targets
+// plan owns in the background.
+func (r *FlipperReconciler) beginRun(ctx context.Context, flipper *v1beta1.Flipper, trigger v1beta1.RunTrigger, token string, plan runPlan, now time.Time) error {
+	record, err := r.startRunRecord(ctx, flipper, trigger, token, plan.config.DryRun, now)
+	if err != nil {
+		return err
+	}
+
+	runCtx := startRunSpan(flipper, record, plan.selectStart, plan.selected, plan.matched, len(plan.owned))
+	workloads := orderTargets(plan.owned, plan.config.Strategy, flipper.Status.Targets)
+	// The start is queued before the run can finish and queue its results.
+	r.Notifications.send(ctx, r.Client, r.Recorder, flipper, runNotification(notify.RunStarted, record, pendingTargets(workloads), now))
+	run := r.startRun(runCtx, client.ObjectKeyFromObject(flipper), workloads, restartOptions(plan.config, plan.parsed), record.Name, now)
+	r.recordRunStart(flipper, run, trigger)
+
+	lastRunTime := metav1.NewTime(now)
+	flipper.Status.LastRunTime = &lastRunTime
+
+	return nil
+}
+
+// startRun restarts workloads in the background, in the trace of ctx, and
+// returns the run. The Flipper is reconciled whenever the run makes progress,
+// and once more when it is done.
+func (r *FlipperReconciler) startRun(ctx context.Context, key types.NamespacedName, workloads []models.Workload, options clients.RestartOptions, record string, now time.Time) *flipperRun {
+	ctx, cancel := context.WithCancel(ctx)
+
+	run := newFlipperRun(now, workloads, record, cancel)
+	run.onProgress = func() { r.notify(key) }
+	run.onFinished = func(workload models.Workload, err error, duration time.Duration) {
+		observeRestart(key, workload, err, duration)
+	}
+	r.lock.Lock()
+	r.runs[key] = run
+	r.lock.Unlock()
+
+	go func() {
+		defer cancel()
+
+		results := r.KraftClients.RestartWorkloads(ctx, workloads, options, run)
+		endRunSpan(ctx, workloads, results)
+		run.finish()
+		r.notify(key)
+	}()
+
+	return run
+}
+
+// activeRun returns the run of the Flipper with the given key, if it has one
+// whose results are not recorded yet.
+func (r *FlipperReconciler) activeRun(key types.NamespacedName) (*flipperRun, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	run, ok := r.runs[key]
+	return run, ok
+}
+
+// removeRun forgets the run of the Flipper with the given key and returns it,
+// nil if it has none.
+func (r *FlipperReconciler) removeRun(key types.NamespacedName) *flipperRun {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	run := r.runs[key]
+	delete(r.runs, key)
+
+	return run
+}
+
+// setSchedule remembers the schedule state of the Flipper with the given key.
+func (r *FlipperReconciler) setSchedule(key types.NamespacedName, state flipperSchedule) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.schedules[key] = state
+}
+
+// removeSchedule forgets the schedule state of the Flipper with the given key.
+func (r *FlipperReconciler) removeSchedule(key types.NamespacedName) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.schedules, key)
+}
+
+// notify requeues the Flipper with the given key.
+func (r *FlipperReconciler) notify(key types.NamespacedName) {
+	r.runEvents <- event.GenericEvent{
+		Object: &v1beta1.Flipper{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}},
+	}
+}
+
+// scheduleFor returns the schedule state of a Flipper. A change of the spec
+// starts a new schedule, and after a restart of the controller the schedule
+// picks up where the status left off.
+func (r *FlipperReconciler) scheduleFor(key types.NamespacedName, flipper *v1beta1.Flipper, restartSchedule schedule.Schedule, now time.Time) flipperSchedule {
+	r.lock.Lock()
+	state, ok := r.schedules[key]
+	r.lock.Unlock()
+	if ok && state.generation == flipper.Generation {
+		return state
+	}
+
+	if !ok && flipper.Status.ObservedGeneration == flipper.Generation && flipper.Status.NextRunTime != nil {
+		return flipperSchedule{
+			generation: flipper.Generation,
+			nextRun:    flipper.Status.NextRunTime.Time,
+		}
+	}
+
+	return flipperSchedule{
+		generation: flipper.Generation,
+		nextRun:    restartSchedule.Next(now),
+	}
+}
+
+// targetStatuses lists the matched targets, carrying over the last restart of
+// each from previous, recording the results of a run at now and the Flippers
+// each conflicts with.
+func targetStatuses(previous []v1beta1.TargetStatus, targets map[string]models.Workload, conflicts map[string]targetConflict, results map[string]error, now time.Time) []v1beta1.TargetStatus {
+	previousByKey := make(map[string]v1beta1.TargetStatus, len(previous))
+	for _, target := range previous {
+		previousByKey[targetKey(target)] = target
+	}
+
+	statuses := make([]v1beta1.TargetStatus, 0, len(targets))
+	for key, workload := range targets {
+		status, ok := previousByKey[key]
+		if !ok {
+			status = v1beta1.TargetStatus{
+				APIVersion: workload.Type.APIVersion,
+				Kind:       workload.Type.Kind,
+				Namespace:  workload.Namespace,
+				Name:       workload.Name,
+			}
+		}
+
+		status.RolledBackTime = rolledBackTime(workload)
+
+		conflict := conflicts[key]
+		status.ConflictsWith = conflict.others
+		status.ClaimedBy = ""
+		if conflict.owner != nil {
+			status.ClaimedBy = conflict.owner.key.String()
+		}
+
+		if err, ran := results[key]; ran {
+			status.Result, status.Message = restartResult(err)
+			switch status.Result {
+			case v1beta1.RestartSucceeded, v1beta1.RestartFailed, v1beta1.RestartRolledBack:
+				restartTime := metav1.NewTime(now)
+				status.LastRestartTime = &restartTime
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return targetKey(statuses[i]) < targetKey(statuses[j])
+	})
+
+	return statuses
+}
+
+// rolledBackTime reads when workload was rolled back from its annotation, nil
+// when it was not or the rollback was acknowledged since.
+func rolledBackTime(workload models.Workload) *metav1.Time {
+	value, ok := workload.Annotations[clients.RolledBackAnnotation]
+	if !ok {
+		return nil
+	}
+
+	// Annotations edited by hand still pause the workload, they just have no
+	// time to show.
+	rolledBack, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return &metav1.Time{}
+	}
+
+	rolledBackTime := metav1.NewTime(rolledBack)
+	return &rolledBackTime
+}
+
+// stuckTarget names the target whose rollout got stuck in results, if any.
+func stuckTarget(targets map[string]models.Workload, results map[string]error) string {
+	var stuck []string
+	for key, err := range results {
+		workload, ok := targets[key]
+		if ok && clients.IsRolloutStuck(err) {
+			stuck = append(stuck, fmt.Sprintf("%s %s/%s", workload.Type.Kind, workload.Namespace, workload.Name))
+		}
+	}
+	sort.Strings(stuck)
+
+	return strings.Join(stuck, ", ")
+}
+
+func targetKey(target v1beta1.TargetStatus) string {
+	return models.WorkloadKey(target.APIVersion, target.Kind, target.Namespace, target.Name)
+}
+
+func setCondition(flipper *v1beta1.Flipper, conditionType string, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&flipper.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: flipper.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// patchStatus writes the status of flipper if it differs from original.
+func (r *FlipperReconciler) patchStatus(ctx context.Context, original *v1beta1.Flipper, flipper *v1beta1.Flipper) error {
+	if equality.Semantic.DeepEqual(original.Status, flipper.Status) {
+		return nil
+	}
+
+	err := r.Status().Patch(ctx, flipper, client.MergeFrom(original))
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to update flipper status")
+	}
+
+	return err
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *FlipperReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.schedules = make(map[types.NamespacedName]flipperSchedule)
+	r.runs = make(map[types.NamespacedName]*flipperRun)
+	r.runEvents = make(chan event.GenericEvent, runEventsBuffer)
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1beta1.Flipper{}).
+		Watches(&source.Channel{Source: r.runEvents}, &handler.EnqueueRequestForObject{}).
+		Watches(
+			&source.Kind{Type: &v1beta1.Flipper{}},
+			r.overlappingFlippers(),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		).
+		Complete(r)
+}
@@ -0,0 +1,88 @@
+//go:build integration
+// +build integration
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/clients"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Running flippers now", func() {
+	const timeout = 30 * time.Second
+	const interval = 250 * time.Millisecond
+	ctx := context.Background()
+
+	It("restarts targets once per run-now token", func() {
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "run-now-"}}
+		Expect(k8sClient.Create(ctx, namespace)).To(Succeed())
+
+		labels := map[string]string{"app": "web"}
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Name, Name: "web", Labels: labels},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: labels},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "web", Image: "nginx"}}},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, deployment)).To(Succeed())
+
+		flipper := &v1beta1.Flipper{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace.Name, Name: "nightly"},
+			Spec: v1beta1.FlipperSpec{
+				Interval: "24h",
+				Match:    v1beta1.Match{Kinds: []v1beta1.WorkloadKind{v1beta1.WorkloadKind("Deployment")}, Labels: labels},
+			},
+		}
+		Expect(k8sClient.Create(ctx, flipper)).To(Succeed())
+
+		key := client.ObjectKeyFromObject(flipper)
+		Eventually(func() *metav1.Time {
+			Expect(k8sClient.Get(ctx, key, flipper)).To(Succeed())
+			return flipper.Status.NextRunTime
+		}, timeout, interval).ShouldNot(BeNil())
+		Expect(flipper.Status.LastRunTime).To(BeNil())
+
+		By("starting a run when the token is set")
+		patch := client.MergeFrom(flipper.DeepCopy())
+		flipper.Annotations = map[string]string{v1beta1.RunNowAnnotation: "first"}
+		Expect(k8sClient.Patch(ctx, flipper, patch)).To(Succeed())
+
+		Eventually(func() string {
+			Expect(k8sClient.Get(ctx, key, flipper)).To(Succeed())
+			return flipper.Status.LastRunNowToken
+		}, timeout, interval).Should(Equal("first"))
+		Expect(flipper.Status.LastRunTime).NotTo(BeNil())
+
+		Eventually(func() map[string]string {
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(deployment), deployment)).To(Succeed())
+			return deployment.Spec.Template.Annotations
+		}, timeout, interval).Should(HaveKey(clients.RestartTimeAnnotation))
+
+		runList := &v1beta1.FlipperRunList{}
+		Expect(k8sClient.List(ctx, runList, client.InNamespace(namespace.Name))).To(Succeed())
+		Expect(runList.Items).To(HaveLen(1))
+		Expect(runList.Items[0].Spec.Trigger).To(Equal(v1beta1.TriggerManual))
+		Expect(runList.Items[0].Spec.Token).To(Equal("first"))
+
+		By("not starting another run for the same token")
+		Consistently(func() []v1beta1.FlipperRun {
+			Expect(k8sClient.List(ctx, runList, client.InNamespace(namespace.Name))).To(Succeed())
+			return runList.Items
+		}, 2*time.Second, interval).Should(HaveLen(1))
+	})
+})
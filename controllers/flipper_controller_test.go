@@ -0,0 +1,393 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/clients"
+	"github.com/anmolbabu/kraft-controller/models"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// newTestReconciler returns a FlipperReconciler serving objects from fake
+// clients, along with the recorder of its events.
+func newTestReconciler(objects ...client.Object) (*FlipperReconciler, *record.FakeRecorder) {
+	fakeClient := newFakeClient(objects...)
+	recorder := record.NewFakeRecorder(100)
+
+	return &FlipperReconciler{
+		Client:       fakeClient,
+		Scheme:       fakeClient.Scheme(),
+		KraftClients: newFakeKraftClients(objects...),
+		Recorder:     recorder,
+		schedules:    make(map[types.NamespacedName]flipperSchedule),
+		runs:         make(map[types.NamespacedName]*flipperRun),
+		runEvents:    make(chan event.GenericEvent, runEventsBuffer),
+	}, recorder
+}
+
+// newTarget returns a Deployment in the apps namespace matched by the Flippers
+// of newScheduledFlipper.
+func newTarget(name string) *appsv1.Deployment {
+	labels := map[string]string{"app": "web"}
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: name, Labels: labels},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+		},
+	}
+}
+
+// reconcileFlipper reconciles flipper with r and returns it as it is stored
+// afterwards.
+func reconcileFlipper(r *FlipperReconciler, flipper *v1beta1.Flipper) (ctrl.Result, *v1beta1.Flipper) {
+	key := client.ObjectKeyFromObject(flipper)
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	Expect(err).NotTo(HaveOccurred())
+
+	stored := &v1beta1.Flipper{}
+	Expect(r.Get(context.Background(), key, stored)).To(Succeed())
+
+	return result, stored
+}
+
+var _ = Describe("Flipper status", func() {
+	It("reports the schedule, targets and conditions of flippers", func() {
+		r, _ := newTestReconciler(newScheduledFlipper("nightly", nil), newTarget("web"))
+
+		result, flipper := reconcileFlipper(r, newScheduledFlipper("nightly", nil))
+		Expect(result.RequeueAfter).To(BeNumerically(">", 0))
+
+		Expect(flipper.Status.ObservedGeneration).To(Equal(int64(1)))
+		Expect(flipper.Status.LastRunTime).To(BeNil())
+		Expect(flipper.Status.NextRunTime).NotTo(BeNil())
+		Expect(flipper.Status.NextRunTime.Time).To(BeTemporally("~", time.Now().Add(result.RequeueAfter), 2*time.Second))
+
+		Expect(flipper.Status.Targets).To(HaveLen(1))
+		Expect(flipper.Status.Targets[0].Kind).To(Equal(models.KindDeployment))
+		Expect(flipper.Status.Targets[0].Name).To(Equal("web"))
+		Expect(flipper.Status.Targets[0].Result).To(BeEmpty())
+
+		Expect(meta.IsStatusConditionTrue(flipper.Status.Conditions, v1beta1.ConditionReady)).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(flipper.Status.Conditions, v1beta1.ConditionDegraded)).To(BeTrue())
+		Expect(meta.IsStatusConditionFalse(flipper.Status.Conditions, v1beta1.ConditionSuspended)).To(BeTrue())
+	})
+
+	It("reports flippers with an invalid spec as not ready", func() {
+		broken := newScheduledFlipper("broken", func(flipper *v1beta1.Flipper) { flipper.Spec.Schedule = "every now and then" })
+		r, _ := newTestReconciler(broken)
+
+		result, flipper := reconcileFlipper(r, broken)
+		Expect(result.RequeueAfter).To(BeZero())
+
+		Expect(flipper.Status.NextRunTime).To(BeNil())
+		ready := meta.FindStatusCondition(flipper.Status.Conditions, v1beta1.ConditionReady)
+		Expect(ready).NotTo(BeNil())
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready.Reason).To(Equal(reasonInvalidSpec))
+	})
+
+	It("cancels the run in progress once the flipper only reloads on config changes", func() {
+		reloadOnly := newScheduledFlipper("nightly", func(flipper *v1beta1.Flipper) {
+			flipper.Spec.Schedule = ""
+			flipper.Spec.ReloadOnConfigChange = true
+		})
+		startTime := metav1.NewTime(time.Now().Add(-time.Minute))
+		record := &v1beta1.FlipperRun{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "nightly-1"},
+			Spec:       v1beta1.FlipperRunSpec{FlipperName: "nightly", Trigger: v1beta1.TriggerSchedule},
+			Status:     v1beta1.FlipperRunStatus{Phase: v1beta1.RunRunning, StartTime: &startTime},
+		}
+		r, _ := newTestReconciler(reloadOnly, record)
+
+		cancelled := false
+		r.runs[client.ObjectKeyFromObject(reloadOnly)] = newFlipperRun(startTime.Time, nil, record.Name, func() { cancelled = true })
+
+		_, flipper := reconcileFlipper(r, reloadOnly)
+		Expect(cancelled).To(BeTrue())
+		Expect(r.runs).To(BeEmpty())
+		Expect(flipper.Status.ActiveRun).To(BeNil())
+		Expect(meta.FindStatusCondition(flipper.Status.Conditions, v1beta1.ConditionReady).Reason).To(Equal(reasonReloadOnly))
+
+		Expect(r.Get(context.Background(), client.ObjectKeyFromObject(record), record)).To(Succeed())
+		Expect(record.Status.Phase).To(Equal(v1beta1.RunFailed))
+		Expect(record.Status.CompletionTime).NotTo(BeNil())
+	})
+
+	It("carries the last restart of targets over and records the results of runs", func() {
+		web := models.Workload{Type: models.BuiltinWorkloadTypes[models.KindDeployment], Namespace: "apps", Name: "web"}
+		api := models.Workload{Type: models.BuiltinWorkloadTypes[models.KindDeployment], Namespace: "apps", Name: "api"}
+		restarted := metav1.NewTime(time.Date(2021, 7, 1, 3, 30, 0, 0, time.UTC))
+		now := restarted.Add(24 * time.Hour)
+
+		previous := []v1beta1.TargetStatus{{
+			APIVersion:      web.Type.APIVersion,
+			Kind:            web.Type.Kind,
+			Namespace:       web.Namespace,
+			Name:            web.Name,
+			Result:          v1beta1.RestartSucceeded,
+			LastRestartTime: &restarted,
+		}}
+		targets := map[string]models.Workload{web.Key(): web, api.Key(): api}
+		results := map[string]error{web.Key(): clients.ErrCoalesced, api.Key(): nil}
+
+		statuses := targetStatuses(previous, targets, nil, results, now)
+		Expect(statuses).To(HaveLen(2))
+
+		Expect(statuses[0].Name).To(Equal("api"))
+		Expect(statuses[0].Result).To(Equal(v1beta1.RestartSucceeded))
+		Expect(statuses[0].LastRestartTime.Time).To(Equal(now))
+
+		Expect(statuses[1].Name).To(Equal("web"))
+		Expect(statuses[1].Result).To(Equal(v1beta1.RestartCoalesced))
+		Expect(statuses[1].LastRestartTime.Time).To(Equal(restarted.Time))
+
+		By("dropping targets that are no longer matched")
+		statuses = targetStatuses(statuses, map[string]models.Workload{api.Key(): api}, nil, nil, now)
+		Expect(statuses).To(HaveLen(1))
+		Expect(statuses[0].Name).To(Equal("api"))
+		Expect(statuses[0].Result).To(Equal(v1beta1.RestartSucceeded))
+	})
+})
+
+var _ = Describe("Max pod age", func() {
+	for _, tc := range []struct {
+		name      string
+		maxPodAge string
+		parsed    time.Duration
+		invalid   bool
+	}{
+		{name: "restarts on schedule alone without a max pod age"},
+		{name: "restarts only workloads with pods older than the max pod age", maxPodAge: "168h", parsed: 168 * time.Hour},
+		{name: "rejects max pod ages that are not durations", maxPodAge: "a week", invalid: true},
+		{name: "rejects max pod ages that are not positive", maxPodAge: "0s", invalid: true},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			flipper := newScheduledFlipper("weekly", func(flipper *v1beta1.Flipper) { flipper.Spec.MaxPodAge = tc.maxPodAge })
+			config := models.ConfigFromFlipper(*flipper)
+
+			parsed, err := parseConfig(config)
+			if tc.invalid {
+				Expect(err).To(MatchError(ContainSubstring("maxPodAge")))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restartOptions(config, parsed).MaxPodAge).To(Equal(tc.parsed))
+		})
+	}
+
+	It("keeps the last restart of targets that were not due", func() {
+		web := models.Workload{Type: models.BuiltinWorkloadTypes[models.KindDeployment], Namespace: "apps", Name: "web"}
+		restarted := metav1.NewTime(time.Date(2021, 7, 1, 3, 30, 0, 0, time.UTC))
+		previous := []v1beta1.TargetStatus{{APIVersion: web.Type.APIVersion, Kind: web.Type.Kind, Namespace: "apps", Name: "web", LastRestartTime: &restarted}}
+
+		statuses := targetStatuses(previous, map[string]models.Workload{web.Key(): web}, nil, map[string]error{web.Key(): clients.ErrNotDue}, restarted.Add(time.Hour))
+		Expect(statuses).To(HaveLen(1))
+		Expect(statuses[0].Result).To(Equal(v1beta1.RestartNotDue))
+		Expect(statuses[0].LastRestartTime.Time).To(Equal(restarted.Time))
+	})
+})
+
+var _ = Describe("Suspending flippers", func() {
+	ctx := context.Background()
+
+	// newDueFlipper returns a Flipper whose status has its next run due a
+	// minute ago.
+	newDueFlipper := func(mutate func(*v1beta1.Flipper)) *v1beta1.Flipper {
+		return newScheduledFlipper("nightly", func(flipper *v1beta1.Flipper) {
+			dueTime := metav1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))
+			flipper.Status.ObservedGeneration = flipper.Generation
+			flipper.Status.NextRunTime = &dueTime
+			if mutate != nil {
+				mutate(flipper)
+			}
+		})
+	}
+
+	runs := func(r *FlipperReconciler) []v1beta1.FlipperRun {
+		runList := &v1beta1.FlipperRunList{}
+		Expect(r.List(ctx, runList)).To(Succeed())
+		return runList.Items
+	}
+
+	It("skips runs that come due while suspended and remembers the first one", func() {
+		suspended := newDueFlipper(func(flipper *v1beta1.Flipper) { flipper.Spec.Suspend = true })
+		dueTime := suspended.Status.NextRunTime.Time
+		r, recorder := newTestReconciler(suspended, newTarget("web"))
+
+		_, flipper := reconcileFlipper(r, suspended)
+		Expect(meta.IsStatusConditionTrue(flipper.Status.Conditions, v1beta1.ConditionSuspended)).To(BeTrue())
+		Expect(flipper.Status.MissedRunTime).NotTo(BeNil())
+		Expect(flipper.Status.MissedRunTime.Time).To(BeTemporally("==", dueTime))
+		Expect(flipper.Status.NextRunTime.Time).To(BeTemporally(">", time.Now()))
+		Expect(flipper.Status.LastRunTime).To(BeNil())
+		Expect(runs(r)).To(BeEmpty())
+
+		// The skipped run is reported on the Flipper and on its target.
+		Expect(recorder.Events).To(HaveLen(2))
+		Expect(<-recorder.Events).To(ContainSubstring(reasonRunSkipped))
+		Expect(<-recorder.Events).To(ContainSubstring(reasonRunSkipped))
+
+		By("keeping the first missed run when another one comes due")
+		earlier := metav1.NewTime(dueTime.Add(-time.Hour))
+		flipper.Status.NextRunTime = &earlier
+		Expect(r.Status().Update(ctx, flipper)).To(Succeed())
+		r.removeSchedule(client.ObjectKeyFromObject(flipper))
+
+		_, flipper = reconcileFlipper(r, flipper)
+		Expect(flipper.Status.MissedRunTime.Time).To(BeTemporally("==", dueTime))
+		Expect(runs(r)).To(BeEmpty())
+	})
+
+	for _, tc := range []struct {
+		name    string
+		policy  v1beta1.CatchUpPolicy
+		catchUp bool
+	}{
+		{name: "catches up on a missed run once resumed with the RunOnce policy", policy: v1beta1.CatchUpRunOnce, catchUp: true},
+		{name: "drops missed runs once resumed with the Skip policy", policy: v1beta1.CatchUpSkip},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			resumed := newScheduledFlipper("nightly", func(flipper *v1beta1.Flipper) {
+				missedRunTime := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+				nextRunTime := metav1.NewTime(time.Now().Add(time.Hour).Truncate(time.Second))
+				flipper.Spec.CatchUpPolicy = tc.policy
+				flipper.Status.ObservedGeneration = flipper.Generation
+				flipper.Status.MissedRunTime = &missedRunTime
+				flipper.Status.NextRunTime = &nextRunTime
+			})
+			r, _ := newTestReconciler(resumed)
+
+			_, flipper := reconcileFlipper(r, resumed)
+			Expect(meta.IsStatusConditionFalse(flipper.Status.Conditions, v1beta1.ConditionSuspended)).To(BeTrue())
+			Expect(flipper.Status.MissedRunTime).To(BeNil())
+			Expect(flipper.Status.NextRunTime.Time).To(BeTemporally(">", time.Now()))
+
+			if !tc.catchUp {
+				Expect(flipper.Status.LastRunTime).To(BeNil())
+				Expect(runs(r)).To(BeEmpty())
+				return
+			}
+			Expect(flipper.Status.LastRunTime).NotTo(BeNil())
+			Expect(flipper.Status.LastRunTime.Time).To(BeTemporally("~", time.Now(), 2*time.Second))
+			Expect(runs(r)).To(HaveLen(1))
+			Expect(runs(r)[0].Spec.Trigger).To(Equal(v1beta1.TriggerSchedule))
+		})
+	}
+})
+
+var _ = Describe("Flipper events", func() {
+	// newDueFlipper returns a Flipper whose status has its next run due a
+	// minute ago.
+	newDueFlipper := func(mutate func(*v1beta1.Flipper)) *v1beta1.Flipper {
+		return newScheduledFlipper("nightly", func(flipper *v1beta1.Flipper) {
+			dueTime := metav1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))
+			flipper.Status.ObservedGeneration = flipper.Generation
+			flipper.Status.NextRunTime = &dueTime
+			mutate(flipper)
+		})
+	}
+
+	// events drains the events recorded so far.
+	events := func(recorder *record.FakeRecorder) []string {
+		var recorded []string
+		for len(recorder.Events) > 0 {
+			recorded = append(recorded, <-recorder.Events)
+		}
+		return recorded
+	}
+
+	It("reports runs skipped while suspended on the flipper and its targets", func() {
+		suspended := newDueFlipper(func(flipper *v1beta1.Flipper) { flipper.Spec.Suspend = true })
+		r, recorder := newTestReconciler(suspended, newTarget("web"))
+
+		reconcileFlipper(r, suspended)
+		Expect(events(recorder)).To(ConsistOf(
+			SatisfyAll(HavePrefix("Normal "+reasonRunSkipped+" Skipped the run due at"), HaveSuffix("as the flipper is suspended")),
+			HavePrefix("Normal "+reasonRunSkipped+" Flipper apps/nightly: Skipped the run due at"),
+		))
+	})
+
+	It("reports runs deferred by a blackout once per due time", func() {
+		frozen := newDueFlipper(func(flipper *v1beta1.Flipper) {
+			flipper.Spec.Blackouts = []v1beta1.Blackout{{
+				Start:  metav1.NewTime(time.Now().Add(-time.Hour)),
+				End:    metav1.NewTime(time.Now().Add(time.Hour)),
+				Reason: "release freeze",
+			}}
+		})
+		r, recorder := newTestReconciler(frozen, newTarget("web"))
+
+		_, flipper := reconcileFlipper(r, frozen)
+		Expect(flipper.Status.DeferredRun).NotTo(BeNil())
+		_, flipper = reconcileFlipper(r, flipper)
+		Expect(flipper.Status.DeferredRun).NotTo(BeNil())
+		Expect(flipper.Status.LastRunTime).To(BeNil())
+
+		Expect(events(recorder)).To(ConsistOf(
+			HavePrefix("Normal "+reasonRunDeferred+" Deferred the run due at"),
+			HavePrefix("Normal "+reasonRunDeferred+" Flipper apps/nightly: Deferred the run due at"),
+		))
+	})
+
+	It("reports the start of runs on the flipper and its targets", func() {
+		// A dry run starts like any other, without waiting for a rollout.
+		due := newDueFlipper(func(flipper *v1beta1.Flipper) { flipper.Spec.DryRun = true })
+		r, recorder := newTestReconciler(due, newTarget("web"))
+
+		_, flipper := reconcileFlipper(r, due)
+		Expect(flipper.Status.LastRunTime).NotTo(BeNil())
+		Expect(events(recorder)).To(ConsistOf(
+			MatchRegexp(`^Normal %s Started Schedule run \S+ of 1 targets$`, reasonRunStarted),
+			MatchRegexp(`^Normal %s Scheduled for restart by flipper apps/nightly in run \S+$`, reasonRestartScheduled),
+		))
+	})
+
+	It("reports the results of runs on the flipper and their targets", func() {
+		flipper := newScheduledFlipper("nightly", nil)
+		web := models.Workload{Type: models.BuiltinWorkloadTypes[models.KindDeployment], Namespace: "apps", Name: "web"}
+		api := models.Workload{Type: models.BuiltinWorkloadTypes[models.KindDeployment], Namespace: "apps", Name: "api"}
+		r, recorder := newTestReconciler()
+
+		run := newFlipperRun(time.Now(), []models.Workload{web, api}, "nightly-1", func() {})
+		r.recordRunResults(flipper, run, map[string]error{
+			web.Key(): errors.New("connection refused"),
+			api.Key(): fmt.Errorf("%w. the rollout timed out", clients.ErrRolledBack),
+		})
+
+		Expect(events(recorder)).To(ConsistOf(
+			"Warning "+reasonRestartFailed+" Failed to restart Deployment apps/web in run nightly-1: connection refused",
+			"Warning "+reasonRestartFailed+" Failed to restart by flipper apps/nightly in run nightly-1: connection refused",
+			HavePrefix("Warning "+reasonRolledBack+" Rolled back Deployment apps/api in run nightly-1 and paused its restarts"),
+			HavePrefix("Warning "+reasonRolledBack+" Rolled back by flipper apps/nightly in run nightly-1, remove the "+clients.RolledBackAnnotation+" annotation"),
+			"Warning "+reasonRunFailed+" Run nightly-1 completed with failures, 2 of 2 targets finished",
+		))
+
+		By("reporting runs that went through as completed")
+		r.recordRunResults(flipper, run, map[string]error{web.Key(): nil, api.Key(): clients.ErrCoalesced})
+		Expect(events(recorder)).To(ContainElements(
+			"Normal "+reasonRestarted+" Restarted Deployment apps/web in run nightly-1",
+			HavePrefix("Normal "+reasonRestartSkipped+" Skipped Deployment apps/api in run nightly-1"),
+			"Normal "+reasonRunCompleted+" Run nightly-1 completed, 2 of 2 targets finished",
+		))
+	})
+})
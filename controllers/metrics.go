@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	restartsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flipper_restarts_total",
+		Help: "Restarts of targets by flipper, target namespace and result.",
+	}, []string{"flipper", "namespace", "result"})
+
+	rolloutDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "flipper_rollout_duration_seconds",
+		Help:    "How long the restart of a target took, until its rollout completed, failed or was rolled back.",
+		Buckets: []float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, []string{"flipper", "namespace", "result"})
+
+	flipperMetrics = newFlipperCollector()
+)
+
+func init() {
+	metrics.Registry.MustRegister(restartsTotal, rolloutDuration, flipperMetrics)
+}
+
+// observeRestart counts the restart of workload by the Flipper key, which took
+// duration and returned err.
+func observeRestart(key types.NamespacedName, workload models.Workload, err error, duration time.Duration) {
+	result, _ := restartResult(err)
+	countRestart(key, workload.Namespace, result)
+
+	switch result {
+	case v1beta1.RestartSucceeded, v1beta1.RestartFailed, v1beta1.RestartRolledBack:
+		rolloutDuration.WithLabelValues(key.String(), workload.Namespace, result).Observe(duration.Seconds())
+	}
+}
+
+// countRestart counts a restart of a target in namespace by the Flipper key
+// with result.
+func countRestart(key types.NamespacedName, namespace string, result string) {
+	flipperMetrics.track(key, restartLabels{namespace: namespace, result: result})
+	restartsTotal.WithLabelValues(key.String(), namespace, result).Inc()
+}
+
+// flipperState is what the gauges of a Flipper are computed from.
+type flipperState struct {
+	nextRun      time.Time
+	targets      int
+	lastRestarts map[targetRef]time.Time
+}
+
+// targetRef identifies a target in the gauges.
+type targetRef struct {
+	namespace string
+	kind      string
+	name      string
+}
+
+// restartLabels are the labels of a series of restartsTotal and
+// rolloutDuration, other than the Flipper.
+type restartLabels struct {
+	namespace string
+	result    string
+}
+
+// flipperCollector reports the gauges of every Flipper. The time since the
+// last restart of a target is computed when it is scraped, so that it does
+// not go stale between reconciles.
+type flipperCollector struct {
+	nextRun          *prometheus.Desc
+	targets          *prometheus.Desc
+	sinceLastRestart *prometheus.Desc
+
+	lock     sync.Mutex
+	flippers map[types.NamespacedName]flipperState
+	// restarts tracks the series of restartsTotal and rolloutDuration of
+	// every Flipper, so that they can be deleted along with it.
+	restarts map[types.NamespacedName]map[restartLabels]bool
+}
+
+func newFlipperCollector() *flipperCollector {
+	return &flipperCollector{
+		nextRun: prometheus.NewDesc("flipper_next_run_timestamp_seconds",
+			"When the next run of a flipper is scheduled, as a Unix timestamp.",
+			[]string{"flipper"}, nil),
+		targets: prometheus.NewDesc("flipper_targets",
+			"How many targets a flipper matches.",
+			[]string{"flipper"}, nil),
+		sinceLastRestart: prometheus.NewDesc("flipper_target_seconds_since_last_restart",
+			"Seconds since a flipper last restarted a target.",
+			[]string{"flipper", "namespace", "kind", "name"}, nil),
+		flippers: make(map[types.NamespacedName]flipperState),
+		restarts: make(map[types.NamespacedName]map[restartLabels]bool),
+	}
+}
+
+// update records the state of flipper as of its status.
+func (collector *flipperCollector) update(flipper *v1beta1.Flipper) {
+	state := flipperState{
+		targets:      len(flipper.Status.Targets),
+		lastRestarts: make(map[targetRef]time.Time),
+	}
+
+	if flipper.Status.NextRunTime != nil {
+		state.nextRun = flipper.Status.NextRunTime.Time
+	}
+
+	for _, target := range flipper.Status.Targets {
+		if target.LastRestartTime != nil {
+			ref := targetRef{namespace: target.Namespace, kind: target.Kind, name: target.Name}
+			state.lastRestarts[ref] = target.LastRestartTime.Time
+		}
+	}
+
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+
+	collector.flippers[types.NamespacedName{Namespace: flipper.Namespace, Name: flipper.Name}] = state
+}
+
+// track remembers that the Flipper key has a series of restartsTotal, and
+// maybe rolloutDuration, with labels.
+func (collector *flipperCollector) track(key types.NamespacedName, labels restartLabels) {
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+
+	if collector.restarts[key] == nil {
+		collector.restarts[key] = make(map[restartLabels]bool)
+	}
+	collector.restarts[key][labels] = true
+}
+
+// forget drops the state of the Flipper key, and deletes its series of
+// restartsTotal and rolloutDuration.
+func (collector *flipperCollector) forget(key types.NamespacedName) {
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+
+	delete(collector.flippers, key)
+
+	for labels := range collector.restarts[key] {
+		restartsTotal.DeleteLabelValues(key.String(), labels.namespace, labels.result)
+		rolloutDuration.DeleteLabelValues(key.String(), labels.namespace, labels.result)
+	}
+	delete(collector.restarts, key)
+}
+
+// Describe implements prometheus.Collector.
+func (collector *flipperCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- collector.nextRun
+	descs <- collector.targets
+	descs <- collector.sinceLastRestart
+}
+
+// Collect implements prometheus.Collector.
+func (collector *flipperCollector) Collect(values chan<- prometheus.Metric) {
+	collector.lock.Lock()
+	defer collector.lock.Unlock()
+
+	now := time.Now()
+	for key, state := range collector.flippers {
+		if !state.nextRun.IsZero() {
+			values <- prometheus.MustNewConstMetric(collector.nextRun, prometheus.GaugeValue, float64(state.nextRun.Unix()), key.String())
+		}
+
+		values <- prometheus.MustNewConstMetric(collector.targets, prometheus.GaugeValue, float64(state.targets), key.String())
+
+		for target, lastRestart := range state.lastRestarts {
+			values <- prometheus.MustNewConstMetric(collector.sinceLastRestart, prometheus.GaugeValue, now.Sub(lastRestart).Seconds(), key.String(), target.namespace, target.kind, target.name)
+		}
+	}
+}
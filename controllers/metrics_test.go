@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/clients"
+	"github.com/anmolbabu/kraft-controller/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// seriesOf counts the series collector reports for the Flipper key.
+func seriesOf(collector prometheus.Collector, key types.NamespacedName) int {
+	collected := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(collected)
+		close(collected)
+	}()
+
+	count := 0
+	for metric := range collected {
+		written := &dto.Metric{}
+		Expect(metric.Write(written)).To(Succeed())
+		for _, label := range written.Label {
+			if label.GetName() == "flipper" && label.GetValue() == key.String() {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+var _ = Describe("Flipper metrics", func() {
+	It("deletes every series of deleted flippers", func() {
+		key := types.NamespacedName{Namespace: "apps", Name: "forgotten"}
+		other := types.NamespacedName{Namespace: "apps", Name: "kept"}
+		web := models.Workload{Type: models.BuiltinWorkloadTypes[models.KindDeployment], Namespace: "apps", Name: "web"}
+
+		for _, flipper := range []types.NamespacedName{key, other} {
+			observeRestart(flipper, web, nil, time.Second)
+			observeRestart(flipper, web, fmt.Errorf("boom"), time.Second)
+			observeRestart(flipper, web, clients.ErrCoalesced, 0)
+			countRestart(flipper, "config", v1beta1.RestartSucceeded)
+
+			restartTime := metav1.Now()
+			nextRunTime := metav1.NewTime(time.Now().Add(time.Hour))
+			flipperMetrics.update(&v1beta1.Flipper{
+				ObjectMeta: metav1.ObjectMeta{Namespace: flipper.Namespace, Name: flipper.Name},
+				Status: v1beta1.FlipperStatus{
+					NextRunTime: &nextRunTime,
+					Targets:     []v1beta1.TargetStatus{{Kind: "Deployment", Namespace: "apps", Name: "web", LastRestartTime: &restartTime}},
+				},
+			})
+		}
+		Expect(seriesOf(restartsTotal, key)).To(Equal(4))
+		Expect(seriesOf(rolloutDuration, key)).To(Equal(2))
+		Expect(seriesOf(flipperMetrics, key)).To(Equal(3))
+
+		flipperMetrics.forget(key)
+
+		Expect(seriesOf(restartsTotal, key)).To(BeZero())
+		Expect(seriesOf(rolloutDuration, key)).To(BeZero())
+		Expect(seriesOf(flipperMetrics, key)).To(BeZero())
+
+		Expect(seriesOf(restartsTotal, other)).To(Equal(4))
+		Expect(seriesOf(rolloutDuration, other)).To(Equal(2))
+		Expect(seriesOf(flipperMetrics, other)).To(Equal(3))
+		flipperMetrics.forget(other)
+	})
+})
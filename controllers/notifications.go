@@ -0,0 +1,311 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/models"
+	"github.com/anmolbabu/kraft-controller/notify"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	reasonNotificationFailed = "NotificationFailed"
+
+	// The keys of notification Secrets.
+	notificationURLKey      = "url"
+	notificationTokenKey    = "token"
+	notificationTemplateKey = "template"
+
+	// notificationWorkers is how many notifications are sent at the same
+	// time.
+	notificationWorkers = 4
+	// notificationQueueSize is how many notifications can wait for each
+	// worker before further ones are dropped.
+	notificationQueueSize = 128
+)
+
+// errNotificationQueueFull is reported for notifications dropped as too many
+// are waiting to be sent already.
+var errNotificationQueueFull = errors.New("too many notifications are waiting to be sent")
+
+// Notifications reports the runs of Flippers to HTTP endpoints, the ones
+// each Flipper lists and the one the manager is configured with. It has to be
+// added to the manager, which runs the workers that send the notifications.
+type Notifications struct {
+	Notifier *notify.Notifier
+	// Secret holds the url, and optionally the token and the template, of
+	// the endpoint notified of the runs of every Flipper. None is when it has
+	// no name.
+	Secret types.NamespacedName
+
+	init   sync.Once
+	queues []chan queuedNotification
+}
+
+// queuedNotification is an event about a run of flipper waiting to be sent
+// to endpoint.
+type queuedNotification struct {
+	flipper  *v1beta1.Flipper
+	recorder record.EventRecorder
+	endpoint notify.Endpoint
+	event    notify.Event
+}
+
+// workerQueues returns the queues of the workers, one each.
+func (notifications *Notifications) workerQueues() []chan queuedNotification {
+	notifications.init.Do(func() {
+		notifications.queues = make([]chan queuedNotification, notificationWorkers)
+		for idx := range notifications.queues {
+			notifications.queues[idx] = make(chan queuedNotification, notificationQueueSize)
+		}
+	})
+
+	return notifications.queues
+}
+
+// Start implements manager.Runnable. It sends the queued notifications until
+// ctx is done, which also cancels the ones being sent.
+func (notifications *Notifications) Start(ctx context.Context) error {
+	var workers sync.WaitGroup
+	for _, queue := range notifications.workerQueues() {
+		workers.Add(1)
+		go func(queue <-chan queuedNotification) {
+			defer workers.Done()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case queued := <-queue:
+					notifications.deliver(ctx, queued)
+				}
+			}
+		}(queue)
+	}
+
+	workers.Wait()
+	return nil
+}
+
+// send queues event about a run of flipper for every endpoint that wants it.
+// The events for an endpoint are sent in the order they are queued in. Events
+// that do not fit in the queue, and failures to send them, are logged and
+// recorded in events on flipper.
+func (notifications *Notifications) send(ctx context.Context, c client.Reader, recorder record.EventRecorder, flipper *v1beta1.Flipper, event notify.Event) {
+	if notifications == nil || notifications.Notifier == nil {
+		return
+	}
+
+	logger := log.FromContext(ctx)
+
+	endpoints, err := notifications.endpoints(ctx, c, flipper, event.Type)
+	if err != nil {
+		logger.Error(err, "failed to read notification endpoints")
+		recorder.Eventf(flipper, corev1.EventTypeWarning, reasonNotificationFailed, "Failed to notify of %s in run %s: %s", event.Type, event.Run, err)
+	}
+
+	queues := notifications.workerQueues()
+	for _, endpoint := range endpoints {
+		// Each endpoint has a worker of its own, which keeps its events in
+		// order.
+		hash := fnv.New32a()
+		hash.Write([]byte(endpoint.URL))
+		queue := queues[hash.Sum32()%uint32(len(queues))]
+
+		select {
+		case queue <- queuedNotification{flipper: flipper.DeepCopy(), recorder: recorder, endpoint: endpoint, event: event}:
+		default:
+			logger.Error(errNotificationQueueFull, "failed to send notification", "event", event.Type, "run", event.Run)
+			recorder.Eventf(flipper, corev1.EventTypeWarning, reasonNotificationFailed, "Failed to notify of %s in run %s: %s", event.Type, event.Run, errNotificationQueueFull)
+		}
+	}
+}
+
+// deliver sends queued, logging failures and recording them in events on its
+// Flipper.
+func (notifications *Notifications) deliver(ctx context.Context, queued queuedNotification) {
+	err := notifications.Notifier.Send(ctx, queued.endpoint, queued.event)
+	if err != nil {
+		log.Log.Error(err, "failed to send notification", "flipper", client.ObjectKeyFromObject(queued.flipper), "event", queued.event.Type, "run", queued.event.Run)
+		queued.recorder.Eventf(queued.flipper, corev1.EventTypeWarning, reasonNotificationFailed, "Failed to notify of %s in run %s: %s", queued.event.Type, queued.event.Run, err)
+	}
+}
+
+// endpoints resolves the endpoints notified of events of eventType about the
+// runs of flipper from their Secrets. Endpoints that cannot be resolved are
+// left out, and the reasons returned along with the others.
+func (notifications *Notifications) endpoints(ctx context.Context, c client.Reader, flipper *v1beta1.Flipper, eventType string) ([]notify.Endpoint, error) {
+	var endpoints []notify.Endpoint
+	var errs []error
+
+	if notifications.Secret.Name != "" {
+		endpoint, err := secretEndpoint(ctx, c, notifications.Secret)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	for _, notification := range flipper.Spec.Notifications {
+		if !notifiesOf(notification, eventType) {
+			continue
+		}
+
+		endpoint, err := flipperEndpoint(ctx, c, flipper, notification)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints, utilerrors.NewAggregate(errs)
+}
+
+// secretEndpoint resolves the endpoint the manager notifies of the runs of
+// every Flipper from the Secret key.
+func secretEndpoint(ctx context.Context, c client.Reader, key types.NamespacedName) (notify.Endpoint, error) {
+	secret, err := notificationSecret(ctx, c, key)
+	if err != nil {
+		return notify.Endpoint{}, err
+	}
+
+	endpoint := notify.Endpoint{
+		URL:   string(secret.Data[notificationURLKey]),
+		Token: string(secret.Data[notificationTokenKey]),
+	}
+	if text, ok := secret.Data[notificationTemplateKey]; ok {
+		endpoint.Template, err = notify.ParseTemplate(string(text))
+		if err != nil {
+			return notify.Endpoint{}, fmt.Errorf("%w. invalid %s of secret: %s in namespace: %s", err, notificationTemplateKey, secret.Name, secret.Namespace)
+		}
+	}
+
+	return endpoint, nil
+}
+
+// flipperEndpoint resolves the endpoint of notification, one of flipper.
+func flipperEndpoint(ctx context.Context, c client.Reader, flipper *v1beta1.Flipper, notification v1beta1.Notification) (notify.Endpoint, error) {
+	secret, err := notificationSecret(ctx, c, types.NamespacedName{Namespace: flipper.Namespace, Name: notification.SecretName})
+	if err != nil {
+		return notify.Endpoint{}, err
+	}
+
+	endpoint := notify.Endpoint{
+		URL:   string(secret.Data[notificationURLKey]),
+		Token: string(secret.Data[notificationTokenKey]),
+	}
+	if notification.Format == v1beta1.NotificationTemplate {
+		endpoint.Template, err = notify.ParseTemplate(notification.Template)
+		if err != nil {
+			return notify.Endpoint{}, fmt.Errorf("%w. invalid template of notification with secret: %s of flipper: %s in namespace: %s", err, notification.SecretName, flipper.Name, flipper.Namespace)
+		}
+	}
+
+	return endpoint, nil
+}
+
+// notificationSecret fetches the Secret key, which has to hold a url.
+func notificationSecret(ctx context.Context, c client.Reader, key types.NamespacedName) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, key, secret)
+	if err != nil {
+		return nil, fmt.Errorf("%w. failed to fetch notification secret: %s in namespace: %s", err, key.Name, key.Namespace)
+	}
+
+	if len(secret.Data[notificationURLKey]) == 0 {
+		return nil, fmt.Errorf("notification secret: %s in namespace: %s has no %s", key.Name, key.Namespace, notificationURLKey)
+	}
+
+	return secret, nil
+}
+
+// notifiesOf reports whether notification wants events of eventType.
+func notifiesOf(notification v1beta1.Notification, eventType string) bool {
+	if len(notification.Events) == 0 {
+		return true
+	}
+
+	for _, event := range notification.Events {
+		if string(event) == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// notifyRunResults notifies of the outcome of the completed run record of
+// flipper, and of the targets it rolled back.
+func (r *FlipperReconciler) notifyRunResults(ctx context.Context, flipper *v1beta1.Flipper, record *v1beta1.FlipperRun, now time.Time) {
+	eventType := notify.RunSucceeded
+	if record.Status.Phase == v1beta1.RunFailed {
+		eventType = notify.RunFailed
+	}
+	r.Notifications.send(ctx, r.Client, r.Recorder, flipper, runNotification(eventType, record, record.Status.Targets, now))
+
+	var rolledBack []v1beta1.RunTarget
+	for _, target := range record.Status.Targets {
+		if target.Result == v1beta1.RestartRolledBack {
+			rolledBack = append(rolledBack, target)
+		}
+	}
+	if len(rolledBack) > 0 {
+		r.Notifications.send(ctx, r.Client, r.Recorder, flipper, runNotification(notify.RolledBack, record, rolledBack, now))
+	}
+}
+
+// runNotification describes the run record, of targets, as an event of
+// eventType that happened at now.
+func runNotification(eventType string, record *v1beta1.FlipperRun, targets []v1beta1.RunTarget, now time.Time) notify.Event {
+	event := notify.Event{
+		Type:      eventType,
+		Namespace: record.Namespace,
+		Flipper:   record.Spec.FlipperName,
+		Run:       record.Name,
+		Trigger:   string(record.Spec.Trigger),
+		DryRun:    record.Spec.DryRun,
+		Time:      now,
+		Message:   record.Status.Message,
+	}
+
+	for _, target := range targets {
+		event.Targets = append(event.Targets, notify.Target{
+			Kind:      target.Kind,
+			Namespace: target.Namespace,
+			Name:      target.Name,
+			Result:    target.Result,
+			Message:   target.Message,
+		})
+	}
+
+	return event
+}
+
+// pendingTargets lists workloads as the targets of a run that just started.
+func pendingTargets(workloads []models.Workload) []v1beta1.RunTarget {
+	targets := make([]v1beta1.RunTarget, 0, len(workloads))
+	for _, workload := range workloads {
+		targets = append(targets, v1beta1.RunTarget{
+			APIVersion: workload.Type.APIVersion,
+			Kind:       workload.Type.Kind,
+			Namespace:  workload.Namespace,
+			Name:       workload.Name,
+		})
+	}
+
+	return targets
+}
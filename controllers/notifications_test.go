@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/notify"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newNotificationSecret(namespace string, name string, url string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Data:       map[string][]byte{notificationURLKey: []byte(url)},
+	}
+}
+
+var _ = Describe("Notification endpoints", func() {
+	ctx := context.Background()
+	global := types.NamespacedName{Namespace: "flipper-system", Name: "notifications"}
+
+	for _, tc := range []struct {
+		name    string
+		objects []client.Object
+		mutate  func(*v1beta1.Flipper)
+		urls    []string
+		failed  bool
+	}{
+		{
+			name:    "resolves the endpoints of the manager and the flipper",
+			objects: []client.Object{newNotificationSecret("flipper-system", "notifications", "https://global"), newNotificationSecret("apps", "team", "https://team")},
+			urls:    []string{"https://global", "https://team"},
+		},
+		{
+			name:    "keeps the endpoints of the flipper when the one of the manager is missing",
+			objects: []client.Object{newNotificationSecret("apps", "team", "https://team")},
+			urls:    []string{"https://team"},
+			failed:  true,
+		},
+		{
+			name:    "keeps the other endpoints when one has no url",
+			objects: []client.Object{newNotificationSecret("flipper-system", "notifications", "https://global"), newNotificationSecret("apps", "team", "")},
+			urls:    []string{"https://global"},
+			failed:  true,
+		},
+		{
+			name:    "keeps the other endpoints when one has an invalid template",
+			objects: []client.Object{newNotificationSecret("flipper-system", "notifications", "https://global"), newNotificationSecret("apps", "team", "https://team")},
+			mutate: func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Notifications[0].Format = v1beta1.NotificationTemplate
+				flipper.Spec.Notifications[0].Template = "{{ .Run"
+			},
+			urls:   []string{"https://global"},
+			failed: true,
+		},
+		{
+			name:    "leaves out endpoints that do not want the event",
+			objects: []client.Object{newNotificationSecret("flipper-system", "notifications", "https://global"), newNotificationSecret("apps", "team", "https://team")},
+			mutate: func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Notifications[0].Events = []v1beta1.NotificationEvent{v1beta1.NotifyRunFailed}
+			},
+			urls: []string{"https://global"},
+		},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			flipper := newScheduledFlipper("nightly", func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Notifications = []v1beta1.Notification{{SecretName: "team"}}
+				if tc.mutate != nil {
+					tc.mutate(flipper)
+				}
+			})
+			notifications := &Notifications{Secret: global}
+
+			endpoints, err := notifications.endpoints(ctx, newFakeClient(tc.objects...), flipper, notify.RunStarted)
+			if tc.failed {
+				Expect(err).To(HaveOccurred())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			urls := []string{}
+			for _, endpoint := range endpoints {
+				urls = append(urls, endpoint.URL)
+			}
+			Expect(urls).To(Equal(tc.urls))
+		})
+	}
+})
+
+var _ = Describe("Notification queue", func() {
+	var (
+		lock     sync.Mutex
+		received []string
+		server   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		received = nil
+		server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			event := map[string]interface{}{}
+			Expect(json.NewDecoder(request.Body).Decode(&event)).To(Succeed())
+
+			lock.Lock()
+			defer lock.Unlock()
+			received = append(received, event["type"].(string))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	receivedTypes := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return append([]string{}, received...)
+	}
+
+	flipper := newScheduledFlipper("nightly", func(flipper *v1beta1.Flipper) {
+		flipper.Spec.Notifications = []v1beta1.Notification{{SecretName: "team"}}
+	})
+
+	It("sends the events for an endpoint in order until it is stopped", func() {
+		notifications := &Notifications{Notifier: notify.NewNotifier(nil, wait.Backoff{Duration: time.Millisecond, Steps: 1})}
+		fakeClient := newFakeClient(newNotificationSecret("apps", "team", server.URL))
+		recorder := record.NewFakeRecorder(10)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan error)
+		go func() {
+			stopped <- notifications.Start(ctx)
+		}()
+
+		for _, eventType := range []string{notify.RunStarted, notify.RunSucceeded, notify.RolledBack} {
+			notifications.send(ctx, fakeClient, recorder, flipper, notify.Event{Type: eventType, Namespace: "apps", Flipper: "nightly", Run: "nightly-1"})
+		}
+		Eventually(receivedTypes).Should(Equal([]string{"io.flipper.RunStarted", "io.flipper.RunSucceeded", "io.flipper.RolledBack"}))
+		Expect(recorder.Events).To(BeEmpty())
+
+		cancel()
+		Eventually(stopped).Should(Receive(BeNil()))
+	})
+
+	It("drops events that do not fit in the queue", func() {
+		notifications := &Notifications{Notifier: notify.NewNotifier(nil, wait.Backoff{Duration: time.Millisecond, Steps: 1})}
+		fakeClient := newFakeClient(newNotificationSecret("apps", "team", server.URL))
+		recorder := record.NewFakeRecorder(10)
+
+		// Nothing is sent before the notifications are started.
+		for i := 0; i <= notificationQueueSize; i++ {
+			notifications.send(context.Background(), fakeClient, recorder, flipper, notify.Event{Type: notify.RunStarted, Namespace: "apps", Flipper: "nightly", Run: "nightly-1"})
+		}
+
+		Expect(recorder.Events).To(HaveLen(1))
+		Expect(<-recorder.Events).To(ContainSubstring(reasonNotificationFailed))
+		Expect(receivedTypes()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/clients"
+	"github.com/anmolbabu/kraft-controller/models"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// createRunRecord creates the FlipperRun recording a run of flipper, owned by
+// flipper so that it goes away with it, and returns it.
+func createRunRecord(ctx context.Context, c client.Client, scheme *runtime.Scheme, flipper *v1beta1.Flipper, spec v1beta1.FlipperRunSpec, status v1beta1.FlipperRunStatus) (*v1beta1.FlipperRun, error) {
+	record := &v1beta1.FlipperRun{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: flipper.Name + "-",
+			Namespace:    flipper.Namespace,
+		},
+		Spec: spec,
+	}
+	record.Spec.FlipperName = flipper.Name
+
+	err := controllerutil.SetControllerReference(flipper, record, scheme)
+	if err != nil {
+		return nil, fmt.Errorf("%w. failed to own the run of flipper: %s in namespace: %s", err, flipper.Name, flipper.Namespace)
+	}
+
+	err = c.Create(ctx, record)
+	if err != nil {
+		return nil, fmt.Errorf("%w. failed to record the run of flipper: %s in namespace: %s", err, flipper.Name, flipper.Namespace)
+	}
+
+	record.Status = status
+	err = c.Status().Update(ctx, record)
+	if err != nil {
+		return nil, fmt.Errorf("%w. failed to record the run of flipper: %s in namespace: %s", err, flipper.Name, flipper.Namespace)
+	}
+
+	return record, nil
+}
+
+// startRunRecord records a run of flipper that starts at now.
+func (r *FlipperReconciler) startRunRecord(ctx context.Context, flipper *v1beta1.Flipper, trigger v1beta1.RunTrigger, token string, dryRun bool, now time.Time) (*v1beta1.FlipperRun, error) {
+	startTime := metav1.NewTime(now)
+	return createRunRecord(ctx, r.Client, r.Scheme, flipper, v1beta1.FlipperRunSpec{
+		Trigger: trigger,
+		Token:   token,
+		DryRun:  dryRun,
+	}, v1beta1.FlipperRunStatus{
+		Phase:     v1beta1.RunRunning,
+		StartTime: &startTime,
+	})
+}
+
+// completeRunRecord records the results of run, which ended at now, in its
+// FlipperRun and returns it, nil if it is gone.
+func (r *FlipperReconciler) completeRunRecord(ctx context.Context, namespace string, run *flipperRun, results map[string]error, now time.Time) (*v1beta1.FlipperRun, error) {
+	record := &v1beta1.FlipperRun{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: run.record}, record)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w. failed to fetch flipper run: %s in namespace: %s", err, run.record, namespace)
+	}
+
+	targets := make(map[string]models.Workload, len(run.workloads))
+	for _, workload := range run.workloads {
+		targets[workload.Key()] = workload
+	}
+
+	completionTime := metav1.NewTime(now)
+	record.Status.CompletionTime = &completionTime
+	record.Status.Targets = runTargets(run.workloads, results)
+	record.Status.Phase = runPhase(record.Status.Targets)
+	record.Status.Message = ""
+	if stuck := stuckTarget(targets, results); stuck != "" {
+		record.Status.Message = fmt.Sprintf("the run stopped as the rollout of %s did not complete", stuck)
+	}
+
+	err = r.Status().Update(ctx, record)
+	if err != nil {
+		return nil, fmt.Errorf("%w. failed to complete flipper run: %s in namespace: %s", err, run.record, namespace)
+	}
+
+	return record, nil
+}
+
+// runTargets lists the outcomes of workloads in results, in the order they
+// were restarted in.
+func runTargets(workloads []models.Workload, results map[string]error) []v1beta1.RunTarget {
+	targets := make([]v1beta1.RunTarget, 0, len(results))
+	for _, workload := range workloads {
+		err, ran := results[workload.Key()]
+		if !ran {
+			continue
+		}
+
+		result, message := restartResult(err)
+		targets = append(targets, v1beta1.RunTarget{
+			APIVersion: workload.Type.APIVersion,
+			Kind:       workload.Type.Kind,
+			Namespace:  workload.Namespace,
+			Name:       workload.Name,
+			Result:     result,
+			Message:    message,
+		})
+	}
+
+	return targets
+}
+
+// runPhase is Failed for runs with targets that failed, were rolled back,
+// were skipped or were blocked by a PodDisruptionBudget, and Succeeded
+// otherwise.
+func runPhase(targets []v1beta1.RunTarget) v1beta1.RunPhase {
+	for _, target := range targets {
+		switch target.Result {
+		case v1beta1.RestartFailed, v1beta1.RestartRolledBack, v1beta1.RestartSkipped, v1beta1.RestartBlocked:
+			return v1beta1.RunFailed
+		}
+	}
+
+	return v1beta1.RunSucceeded
+}
+
+// pruneRunRecords fails the FlipperRuns of flipper still marked Running
+// other than active, which a restart of the controller or a cancelled run cut
+// short, and deletes the oldest completed ones beyond the history limits of
+// flipper.
+func (r *FlipperReconciler) pruneRunRecords(ctx context.Context, flipper *v1beta1.Flipper, active string, now time.Time) error {
+	logger := log.FromContext(ctx)
+
+	records, err := flipperRunRecords(ctx, r.Client, flipper)
+	if err != nil {
+		return err
+	}
+
+	for idx := range records {
+		record := &records[idx]
+		if record.Status.Phase != v1beta1.RunRunning || record.Name == active {
+			continue
+		}
+
+		completionTime := metav1.NewTime(now)
+		record.Status.Phase = v1beta1.RunFailed
+		record.Status.CompletionTime = &completionTime
+		record.Status.Message = "the run was interrupted before it completed"
+
+		// A conflict means the run was completed in the meantime.
+		err = r.Status().Update(ctx, record)
+		if err != nil && !errors.IsConflict(err) && !errors.IsNotFound(err) {
+			logger.Error(err, "failed to fail interrupted flipper run", "run", record.Name)
+		}
+	}
+
+	return pruneCompletedRuns(ctx, r.Client, flipper, records)
+}
+
+// flipperRunRecords lists the FlipperRuns of flipper.
+func flipperRunRecords(ctx context.Context, c client.Client, flipper *v1beta1.Flipper) ([]v1beta1.FlipperRun, error) {
+	recordList := &v1beta1.FlipperRunList{}
+	err := c.List(ctx, recordList, client.InNamespace(flipper.Namespace))
+	if err != nil {
+		return nil, fmt.Errorf("%w. failed to list flipper runs in namespace: %s", err, flipper.Namespace)
+	}
+
+	var records []v1beta1.FlipperRun
+	for _, record := range recordList.Items {
+		if record.Spec.FlipperName == flipper.Name {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+// pruneCompletedRuns deletes the oldest of records, the FlipperRuns of
+// flipper, beyond its successful and failed runs history limits.
+func pruneCompletedRuns(ctx context.Context, c client.Client, flipper *v1beta1.Flipper, records []v1beta1.FlipperRun) error {
+	var succeeded, failed []v1beta1.FlipperRun
+	for _, record := range records {
+		switch record.Status.Phase {
+		case v1beta1.RunSucceeded:
+			succeeded = append(succeeded, record)
+		case v1beta1.RunFailed:
+			failed = append(failed, record)
+		}
+	}
+
+	stale := append(
+		oldestRuns(succeeded, historyLimit(flipper.Spec.SuccessfulRunsHistoryLimit, v1beta1.DefaultSuccessfulRunsHistoryLimit)),
+		oldestRuns(failed, historyLimit(flipper.Spec.FailedRunsHistoryLimit, v1beta1.DefaultFailedRunsHistoryLimit))...,
+	)
+
+	for idx := range stale {
+		err := c.Delete(ctx, &stale[idx])
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("%w. failed to delete flipper run: %s in namespace: %s", err, stale[idx].Name, stale[idx].Namespace)
+		}
+	}
+
+	return nil
+}
+
+// oldestRuns returns the runs beyond the newest limit of records.
+func oldestRuns(records []v1beta1.FlipperRun, limit int) []v1beta1.FlipperRun {
+	if len(records) <= limit {
+		return nil
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return runStartTime(records[i]).After(runStartTime(records[j]))
+	})
+
+	return records[limit:]
+}
+
+func runStartTime(record v1beta1.FlipperRun) time.Time {
+	if record.Status.StartTime != nil {
+		return record.Status.StartTime.Time
+	}
+
+	return record.CreationTimestamp.Time
+}
+
+func historyLimit(limit *int32, defaultLimit int) int {
+	if limit == nil {
+		return defaultLimit
+	}
+
+	return int(*limit)
+}
+
+// recordReload records the rollout of workload started by a change to its
+// config as an Event run of flipper, with the result and message of it, and
+// returns it.
+func recordReload(ctx context.Context, c client.Client, scheme *runtime.Scheme, flipper *v1beta1.Flipper, workload models.Workload, result string, message string, dryRun bool, now time.Time) (*v1beta1.FlipperRun, error) {
+	startTime := metav1.NewTime(now)
+	record, err := createRunRecord(ctx, c, scheme, flipper, v1beta1.FlipperRunSpec{
+		Trigger: v1beta1.TriggerEvent,
+		DryRun:  dryRun,
+	}, v1beta1.FlipperRunStatus{
+		Phase:          v1beta1.RunSucceeded,
+		StartTime:      &startTime,
+		CompletionTime: &startTime,
+		Targets: []v1beta1.RunTarget{{
+			APIVersion: workload.Type.APIVersion,
+			Kind:       workload.Type.Kind,
+			Namespace:  workload.Namespace,
+			Name:       workload.Name,
+			Result:     result,
+			Message:    message,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := flipperRunRecords(ctx, c, flipper)
+	if err != nil {
+		return record, err
+	}
+
+	return record, pruneCompletedRuns(ctx, c, flipper, records)
+}
+
+// restartResult is the TargetStatus result of a restart that returned err,
+// and the message explaining it.
+func restartResult(err error) (string, string) {
+	switch {
+	case clients.IsCoalesced(err):
+		return v1beta1.RestartCoalesced, err.Error()
+	case clients.IsRunStopped(err):
+		return v1beta1.RestartSkipped, err.Error()
+	case clients.IsNotDue(err):
+		return v1beta1.RestartNotDue, err.Error()
+	case clients.IsBlockedByDisruptionBudget(err):
+		return v1beta1.RestartBlocked, err.Error()
+	case clients.IsDryRun(err):
+		return v1beta1.RestartWouldRestart, err.Error()
+	case clients.IsRolledBack(err):
+		return v1beta1.RestartRolledBack, err.Error()
+	case err != nil:
+		return v1beta1.RestartFailed, err.Error()
+	default:
+		return v1beta1.RestartSucceeded, ""
+	}
+}
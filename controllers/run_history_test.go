@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/clients"
+	"github.com/anmolbabu/kraft-controller/models"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Run phases", func() {
+	web := models.Workload{Type: models.BuiltinWorkloadTypes[models.KindDeployment], Namespace: "apps", Name: "web"}
+	api := models.Workload{Type: models.BuiltinWorkloadTypes[models.KindDeployment], Namespace: "apps", Name: "api"}
+
+	for _, tc := range []struct {
+		name  string
+		err   error
+		phase v1beta1.RunPhase
+	}{
+		{name: "succeeds runs whose targets restarted", phase: v1beta1.RunSucceeded},
+		{name: "succeeds runs with coalesced targets", err: clients.ErrCoalesced, phase: v1beta1.RunSucceeded},
+		{name: "succeeds runs with targets that were not due", err: clients.ErrNotDue, phase: v1beta1.RunSucceeded},
+		{name: "succeeds dry runs", err: clients.ErrDryRun, phase: v1beta1.RunSucceeded},
+		{name: "fails runs with failed targets", err: fmt.Errorf("boom"), phase: v1beta1.RunFailed},
+		{name: "fails runs with rolled back targets", err: clients.ErrRolledBack, phase: v1beta1.RunFailed},
+		{name: "fails runs with skipped targets", err: clients.ErrRunStopped, phase: v1beta1.RunFailed},
+		{name: "fails runs with targets blocked by a disruption budget", err: clients.ErrDisruptionBudget, phase: v1beta1.RunFailed},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			results := map[string]error{web.Key(): nil, api.Key(): tc.err}
+			Expect(runPhase(runTargets([]models.Workload{web, api}, results))).To(Equal(tc.phase))
+		})
+	}
+
+	It("lists the targets that finished in the order they were restarted in", func() {
+		targets := runTargets([]models.Workload{web, api}, map[string]error{api.Key(): clients.ErrDisruptionBudget})
+		Expect(targets).To(HaveLen(1))
+		Expect(targets[0].Name).To(Equal("api"))
+		Expect(targets[0].Result).To(Equal(v1beta1.RestartBlocked))
+	})
+})
+
+var _ = Describe("Run history", func() {
+	ctx := context.Background()
+	now := time.Date(2021, 7, 1, 3, 30, 0, 0, time.UTC)
+
+	newRecord := func(flipper string, name string, phase v1beta1.RunPhase, age time.Duration) *v1beta1.FlipperRun {
+		startTime := metav1.NewTime(now.Add(-age))
+		return &v1beta1.FlipperRun{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: name},
+			Spec:       v1beta1.FlipperRunSpec{FlipperName: flipper, Trigger: v1beta1.TriggerSchedule},
+			Status:     v1beta1.FlipperRunStatus{Phase: phase, StartTime: &startTime},
+		}
+	}
+	limit := func(limit int32) *int32 { return &limit }
+
+	for _, tc := range []struct {
+		name      string
+		mutate    func(*v1beta1.Flipper)
+		records   []client.Object
+		active    string
+		remaining map[string]v1beta1.RunPhase
+	}{
+		{
+			name: "keeps the newest runs within the history limits",
+			mutate: func(flipper *v1beta1.Flipper) {
+				flipper.Spec.SuccessfulRunsHistoryLimit = limit(2)
+				flipper.Spec.FailedRunsHistoryLimit = limit(1)
+			},
+			records: []client.Object{
+				newRecord("nightly", "ok-1", v1beta1.RunSucceeded, 3*time.Hour),
+				newRecord("nightly", "ok-2", v1beta1.RunSucceeded, 2*time.Hour),
+				newRecord("nightly", "ok-3", v1beta1.RunSucceeded, time.Hour),
+				newRecord("nightly", "failed-1", v1beta1.RunFailed, 5*time.Hour),
+				newRecord("nightly", "failed-2", v1beta1.RunFailed, 4*time.Hour),
+			},
+			remaining: map[string]v1beta1.RunPhase{
+				"ok-2":     v1beta1.RunSucceeded,
+				"ok-3":     v1beta1.RunSucceeded,
+				"failed-2": v1beta1.RunFailed,
+			},
+		},
+		{
+			name: "keeps no runs with a history limit of zero",
+			mutate: func(flipper *v1beta1.Flipper) {
+				flipper.Spec.SuccessfulRunsHistoryLimit = limit(0)
+				flipper.Spec.FailedRunsHistoryLimit = limit(0)
+			},
+			records: []client.Object{
+				newRecord("nightly", "ok-1", v1beta1.RunSucceeded, time.Hour),
+				newRecord("nightly", "failed-1", v1beta1.RunFailed, time.Hour),
+			},
+			remaining: map[string]v1beta1.RunPhase{},
+		},
+		{
+			name: "leaves the runs of other flippers alone",
+			mutate: func(flipper *v1beta1.Flipper) {
+				flipper.Spec.SuccessfulRunsHistoryLimit = limit(0)
+			},
+			records: []client.Object{
+				newRecord("nightly", "ok-1", v1beta1.RunSucceeded, time.Hour),
+				newRecord("hourly", "hourly-1", v1beta1.RunSucceeded, time.Hour),
+			},
+			remaining: map[string]v1beta1.RunPhase{"hourly-1": v1beta1.RunSucceeded},
+		},
+		{
+			name: "fails interrupted runs and keeps the active one running",
+			records: []client.Object{
+				newRecord("nightly", "interrupted", v1beta1.RunRunning, 2*time.Hour),
+				newRecord("nightly", "active", v1beta1.RunRunning, time.Hour),
+			},
+			active: "active",
+			remaining: map[string]v1beta1.RunPhase{
+				"interrupted": v1beta1.RunFailed,
+				"active":      v1beta1.RunRunning,
+			},
+		},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			flipper := newScheduledFlipper("nightly", tc.mutate)
+			reconciler := &FlipperReconciler{Client: newFakeClient(tc.records...)}
+
+			Expect(reconciler.pruneRunRecords(ctx, flipper, tc.active, now)).To(Succeed())
+
+			recordList := &v1beta1.FlipperRunList{}
+			Expect(reconciler.List(ctx, recordList)).To(Succeed())
+			remaining := make(map[string]v1beta1.RunPhase, len(recordList.Items))
+			for _, record := range recordList.Items {
+				remaining[record.Name] = record.Status.Phase
+				if record.Name == "interrupted" {
+					Expect(record.Status.CompletionTime.Time.Equal(now)).To(BeTrue())
+					Expect(record.Status.Message).NotTo(BeEmpty())
+				}
+			}
+			Expect(remaining).To(Equal(tc.remaining))
+		})
+	}
+})
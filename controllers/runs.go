@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/models"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// flipperRun restarts the targets of a Flipper in the background, so that
+// long sequential runs do not hold up the reconciliation of other Flippers.
+type flipperRun struct {
+	startTime time.Time
+	workloads []models.Workload
+	cancel    func()
+	// record is the name of the FlipperRun recording the run.
+	record string
+	// onProgress is called whenever a restart starts or finishes.
+	onProgress func()
+	// onFinished is called with the result of every restart and how long it
+	// took.
+	onFinished func(workload models.Workload, err error, duration time.Duration)
+
+	lock       sync.Mutex
+	inProgress map[string]models.Workload
+	startTimes map[string]time.Time
+	results    map[string]error
+	done       bool
+}
+
+func newFlipperRun(startTime time.Time, workloads []models.Workload, record string, cancel func()) *flipperRun {
+	return &flipperRun{
+		startTime:  startTime,
+		workloads:  workloads,
+		cancel:     cancel,
+		record:     record,
+		inProgress: make(map[string]models.Workload),
+		startTimes: make(map[string]time.Time),
+		results:    make(map[string]error),
+	}
+}
+
+// Started implements clients.RestartProgress.
+func (run *flipperRun) Started(workload models.Workload) {
+	run.lock.Lock()
+
+	run.inProgress[workload.Key()] = workload
+	run.startTimes[workload.Key()] = time.Now()
+	run.lock.Unlock()
+
+	run.notifyProgress()
+}
+
+// Finished implements clients.RestartProgress.
+func (run *flipperRun) Finished(workload models.Workload, err error) {
+	run.lock.Lock()
+	delete(run.inProgress, workload.Key())
+	run.results[workload.Key()] = err
+	duration := time.Since(run.startTimes[workload.Key()])
+	run.lock.Unlock()
+
+	if run.onFinished != nil {
+		run.onFinished(workload, err, duration)
+	}
+
+	run.notifyProgress()
+}
+
+func (run *flipperRun) notifyProgress() {
+	if run.onProgress != nil {
+		run.onProgress()
+	}
+}
+
+// finish marks the run as done.
+func (run *flipperRun) finish() {
+	run.lock.Lock()
+	defer run.lock.Unlock()
+
+	run.done = true
+}
+
+// progress returns the results so far and whether the run is done.
+func (run *flipperRun) progress() (map[string]error, bool) {
+	run.lock.Lock()
+	defer run.lock.Unlock()
+
+	results := make(map[string]error, len(run.results))
+	for key, err := range run.results {
+		results[key] = err
+	}
+
+	return results, run.done
+}
+
+// status describes the run while it is active.
+func (run *flipperRun) status() *v1beta1.ActiveRun {
+	run.lock.Lock()
+	defer run.lock.Unlock()
+
+	var inProgress []string
+	for _, workload := range run.inProgress {
+		inProgress = append(inProgress, fmt.Sprintf("%s %s/%s", workload.Type.Kind, workload.Namespace, workload.Name))
+	}
+	sort.Strings(inProgress)
+
+	return &v1beta1.ActiveRun{
+		StartTime:  metav1.NewTime(run.startTime),
+		Total:      int32(len(run.workloads)),
+		Completed:  int32(len(run.results)),
+		InProgress: inProgress,
+	}
+}
+
+// orderTargets sorts targets in the order of strategy, carrying the last
+// restart of each over from previous.
+func orderTargets(targets map[string]models.Workload, strategy models.Strategy, previous []v1beta1.TargetStatus) []models.Workload {
+	lastRestarts := make(map[string]time.Time, len(previous))
+	for _, target := range previous {
+		if target.LastRestartTime != nil {
+			lastRestarts[targetKey(target)] = target.LastRestartTime.Time
+		}
+	}
+
+	ordered := make([]models.Workload, 0, len(targets))
+	for _, workload := range targets {
+		ordered = append(ordered, workload)
+	}
+
+	byName := func(i, j int) bool {
+		if ordered[i].Namespace != ordered[j].Namespace {
+			return ordered[i].Namespace < ordered[j].Namespace
+		}
+		if ordered[i].Name != ordered[j].Name {
+			return ordered[i].Name < ordered[j].Name
+		}
+		return ordered[i].Key() < ordered[j].Key()
+	}
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		switch strategy.Order {
+		case string(v1beta1.OrderOldestRestartFirst):
+			left, right := lastRestarts[ordered[i].Key()], lastRestarts[ordered[j].Key()]
+			if !left.Equal(right) {
+				return left.Before(right)
+			}
+		case string(v1beta1.OrderPriority):
+			left, right := restartPriority(ordered[i]), restartPriority(ordered[j])
+			if left != right {
+				return left > right
+			}
+		}
+
+		return byName(i, j)
+	})
+
+	return ordered
+}
+
+// restartPriority reads the priority annotation of workload. Workloads without
+// a valid one have a priority of 0.
+func restartPriority(workload models.Workload) int64 {
+	priority, err := strconv.ParseInt(workload.Annotations[v1beta1.PriorityAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return priority
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// flipperCRDName is the name of the CustomResourceDefinition of Flipper.
+const flipperCRDName = "flippers.flipper.flipper.io"
+
+// migrationBackoff is how long a failed migration waits before it is tried
+// again, until it succeeds or the manager stops.
+var migrationBackoff = wait.Backoff{
+	Duration: 10 * time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    math.MaxInt32,
+	Cap:      10 * time.Minute,
+}
+
+// StorageVersionMigrator rewrites every Flipper stored in an older version in
+// the storage version, and then drops the older versions from the stored
+// versions of the CRD, so that they can eventually stop being served.
+type StorageVersionMigrator struct {
+	// Client writes the Flippers and the CRD status.
+	Client client.Client
+	// Reader reads around the cache, which keeps the CRD from being watched.
+	Reader client.Reader
+}
+
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions/status,verbs=get;update;patch
+
+// Start runs the migration, and runs it again with a backoff until it
+// succeeds. Failures are logged rather than returned, as the controller works
+// fine without the migration.
+func (migrator *StorageVersionMigrator) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("storage-version-migrator")
+
+	err := wait.ExponentialBackoffWithContext(ctx, migrationBackoff, func() (bool, error) {
+		err := migrator.migrate(ctx)
+		if err != nil {
+			logger.Error(err, "failed to migrate flippers to the storage version, retrying")
+			return false, nil
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		return nil
+	}
+
+	logger.Info("flippers are stored in the storage version", "version", v1beta1.GroupVersion.Version)
+
+	return nil
+}
+
+// NeedLeaderElection makes only the leader migrate.
+func (migrator *StorageVersionMigrator) NeedLeaderElection() bool {
+	return true
+}
+
+func (migrator *StorageVersionMigrator) migrate(ctx context.Context) error {
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	err := migrator.Reader.Get(ctx, types.NamespacedName{Name: flipperCRDName}, crd)
+	if err != nil {
+		return fmt.Errorf("%w. failed to fetch crd: %s", err, flipperCRDName)
+	}
+
+	storageVersion := v1beta1.GroupVersion.Version
+	if len(crd.Status.StoredVersions) == 1 && crd.Status.StoredVersions[0] == storageVersion {
+		return nil
+	}
+
+	flipperList := &v1beta1.FlipperList{}
+	err = migrator.Reader.List(ctx, flipperList)
+	if err != nil {
+		return fmt.Errorf("%w. failed to list flippers", err)
+	}
+
+	// A patch without changes is enough for the API server to write the
+	// object again, in the storage version. It goes to the status, which
+	// leaves the spec and generation alone and skips the webhooks, as those
+	// may not be serving yet while the manager starts.
+	var errs []error
+	for idx := range flipperList.Items {
+		flipper := &flipperList.Items[idx]
+
+		err = migrator.Client.Status().Patch(ctx, flipper, client.RawPatch(types.MergePatchType, []byte("{}")))
+		if err != nil && !errors.IsNotFound(err) {
+			log.FromContext(ctx).Error(err, "failed to migrate flipper", "flipper", flipper.Name, "namespace", flipper.Namespace)
+			errs = append(errs, fmt.Errorf("%w. failed to migrate flipper: %s in namespace: %s", err, flipper.Name, flipper.Namespace))
+		}
+	}
+
+	// The older versions stay stored until every Flipper is migrated.
+	if len(errs) > 0 {
+		return fmt.Errorf("%w. failed to migrate %d of %d flippers", utilerrors.NewAggregate(errs), len(errs), len(flipperList.Items))
+	}
+
+	original := crd.DeepCopy()
+	crd.Status.StoredVersions = []string{storageVersion}
+
+	err = migrator.Client.Status().Patch(ctx, crd, client.MergeFrom(original))
+	if err != nil {
+		return fmt.Errorf("%w. failed to update the stored versions of crd: %s", err, flipperCRDName)
+	}
+
+	return nil
+}
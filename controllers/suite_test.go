@@ -17,63 +17,73 @@ limitations under the License.
 package controllers
 
 import (
-	"path/filepath"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	flipperv1beta1 "github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/clients"
+	"github.com/anmolbabu/kraft-controller/models"
 	//+kubebuilder:scaffold:imports
 )
 
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
 // http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
 
-var cfg *rest.Config
-var k8sClient client.Client
-var testEnv *envtest.Environment
+// The specs in files without a build tag run against fake clients only. The
+// ones that need an API server are built with the integration tag, which
+// starts envtest around the suite, see suite_integration_test.go.
 
-func TestAPIs(t *testing.T) {
-	RegisterFailHandler(Fail)
+// newFakeClient returns a client that serves objects from memory, for specs
+// that do not need an API server.
+func newFakeClient(objects ...client.Object) client.Client {
+	fakeScheme := runtime.NewScheme()
+	Expect(scheme.AddToScheme(fakeScheme)).To(Succeed())
+	Expect(flipperv1beta1.AddToScheme(fakeScheme)).To(Succeed())
 
-	RunSpecsWithDefaultAndCustomReporters(t,
-		"Controller Suite",
-		[]Reporter{printer.NewlineReporter{}})
+	return fake.NewClientBuilder().WithScheme(fakeScheme).WithObjects(objects...).Build()
 }
 
-var _ = BeforeSuite(func() {
-	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
-
-	By("bootstrapping test environment")
-	testEnv = &envtest.Environment{
-		CRDDirectoryPaths:     []string{filepath.Join("..", "config", "crd", "bases")},
-		ErrorIfCRDPathMissing: false,
+// newFakeKraftClients returns KraftClients that serve the Deployments, pods
+// and PodDisruptionBudgets among objects from memory.
+func newFakeKraftClients(objects ...client.Object) *clients.KraftClients {
+	var deployments, kubeObjects []runtime.Object
+	for _, object := range objects {
+		switch object.(type) {
+		case *appsv1.Deployment:
+			deployments = append(deployments, object.DeepCopyObject())
+		case *corev1.Pod, *policyv1.PodDisruptionBudget:
+			kubeObjects = append(kubeObjects, object.DeepCopyObject())
+		}
 	}
 
-	cfg, err := testEnv.Start()
-	Expect(err).NotTo(HaveOccurred())
-	Expect(cfg).NotTo(BeNil())
-
-	err = appsv1.AddToScheme(scheme.Scheme)
-	Expect(err).NotTo(HaveOccurred())
+	dynamicScheme := runtime.NewScheme()
+	Expect(appsv1.AddToScheme(dynamicScheme)).To(Succeed())
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(appsv1.SchemeGroupVersion.WithKind(models.KindDeployment), meta.RESTScopeNamespace)
 
-	//+kubebuilder:scaffold:scheme
-
-	k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
-	Expect(err).NotTo(HaveOccurred())
-	Expect(k8sClient).NotTo(BeNil())
+	return clients.NewKraftClients(kubefake.NewSimpleClientset(kubeObjects...), dynamicfake.NewSimpleDynamicClient(dynamicScheme, deployments...), restMapper)
+}
 
-}, 60)
+func TestAPIs(t *testing.T) {
+	RegisterFailHandler(Fail)
+	logf.SetLogger(zap.New(zap.WriteTo(GinkgoWriter), zap.UseDevMode(true)))
 
-var _ = AfterSuite(func() {
-	By("tearing down the test environment")
-	err := testEnv.Stop()
-	Expect(err).NotTo(HaveOccurred())
-})
+	RunSpecsWithDefaultAndCustomReporters(t,
+		"Controller Suite",
+		[]Reporter{printer.NewlineReporter{}})
+}
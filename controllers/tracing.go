@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/clients"
+	"github.com/anmolbabu/kraft-controller/models"
+	"github.com/anmolbabu/kraft-controller/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// runAttributes describe a run of flipper on its span.
+func runAttributes(flipper *v1beta1.Flipper, trigger v1beta1.RunTrigger, dryRun bool) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("flipper.namespace", flipper.Namespace),
+		attribute.String("flipper.name", flipper.Name),
+		attribute.String("flipper.trigger", string(trigger)),
+		attribute.Bool("flipper.dry_run", dryRun),
+	}
+}
+
+// startRunSpan starts the trace of the run of flipper that record records,
+// which begins with the selection of its targets between selectStart and
+// selected, out of matched targets. The restarts of its targets are traced in
+// child spans of the returned context, which endRunSpan ends.
+func startRunSpan(flipper *v1beta1.Flipper, record *v1beta1.FlipperRun, selectStart time.Time, selected time.Time, matched int, targets int) context.Context {
+	attributes := append(runAttributes(flipper, record.Spec.Trigger, record.Spec.DryRun), attribute.String("flipper.run", record.Name))
+	ctx, _ := tracing.Tracer().Start(context.Background(), "FlipperRun", trace.WithNewRoot(), trace.WithTimestamp(selectStart), trace.WithAttributes(attributes...))
+
+	_, span := tracing.Tracer().Start(ctx, "SelectTargets", trace.WithTimestamp(selectStart), trace.WithAttributes(
+		attribute.Int("flipper.targets.matched", matched),
+		attribute.Int("flipper.targets.selected", targets),
+	))
+	span.End(trace.WithTimestamp(selected))
+
+	return ctx
+}
+
+// endRunSpan ends the span of the run of workloads that ctx traces, which is
+// failed when any of results is.
+func endRunSpan(ctx context.Context, workloads []models.Workload, results map[string]error) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int("flipper.targets.finished", len(results)))
+
+	if runPhase(runTargets(workloads, results)) == v1beta1.RunFailed {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d of %d targets finished, with failures", len(results), len(workloads)))
+	}
+
+	span.End()
+}
+
+// startReloadSpans starts the trace of the rollout of deployment, whose config
+// changed, as an Event run of flipper with deployment as its only target. The
+// returned function ends it with the name of the FlipperRun that recorded it
+// and the error it failed with, if any.
+func startReloadSpans(ctx context.Context, flipper *v1beta1.Flipper, deployment *appsv1.Deployment, dryRun bool) (context.Context, func(run string, err error)) {
+	runCtx, runSpan := tracing.Tracer().Start(ctx, "FlipperRun", trace.WithNewRoot(), trace.WithAttributes(runAttributes(flipper, v1beta1.TriggerEvent, dryRun)...))
+	targetCtx, targetSpan := tracing.Tracer().Start(runCtx, "RestartWorkload", trace.WithAttributes(clients.WorkloadAttributes(deploymentWorkload(deployment))...))
+
+	return targetCtx, func(run string, err error) {
+		runSpan.SetAttributes(attribute.String("flipper.run", run))
+		tracing.End(targetSpan, err)
+		tracing.End(runSpan, err)
+	}
+}
@@ -17,26 +17,44 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
-	appsv1 "k8s.io/api/apps/v1"
 	"os"
+	"strings"
+	"time"
+	// Embed the IANA time zone database so Flipper schedules can use any
+	// time zone regardless of what the base image ships.
+	_ "time/tzdata"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	flipperv1alpha1 "github.com/anmolbabu/kraft-controller/api/v1alpha1"
+	flipperv1beta1 "github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/clients"
 	"github.com/anmolbabu/kraft-controller/controllers"
+	"github.com/anmolbabu/kraft-controller/notify"
+	"github.com/anmolbabu/kraft-controller/tracing"
+	"github.com/anmolbabu/kraft-controller/webhooks"
 	//+kubebuilder:scaffold:imports
 )
 
+// tracingFlushTimeout bounds how long the spans that are not exported yet are
+// flushed for on shutdown.
+const tracingFlushTimeout = 5 * time.Second
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -46,6 +64,8 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(flipperv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(flipperv1beta1.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -53,8 +73,22 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var dryRun bool
+	var notificationSecret string
+	var otlpEndpoint string
+	var otlpInsecure bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Only record which workloads would be restarted, for every Flipper, without restarting any.")
+	flag.StringVar(&notificationSecret, "notification-secret", "",
+		"The namespace/name of a Secret with the url, and optionally the token and the template, "+
+			"of an endpoint notified of the runs of every Flipper.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "",
+		"The host:port of an OTLP/HTTP collector that the traces of Flipper runs are exported to. "+
+			"Runs are not traced without one.")
+	flag.BoolVar(&otlpInsecure, "otlp-insecure", false,
+		"Export traces to the OTLP endpoint over plain HTTP.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -79,16 +113,81 @@ func main() {
 		os.Exit(1)
 	}
 
-	deployments := make(map[string]appsv1.Deployment)
+	notifications := &controllers.Notifications{
+		Notifier: notify.NewNotifier(nil, notify.DefaultBackoff),
+	}
+	if notificationSecret != "" {
+		parts := strings.SplitN(notificationSecret, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			setupLog.Error(nil, "notification secret has to be of the form namespace/name", "notification-secret", notificationSecret)
+			os.Exit(1)
+		}
+		notifications.Secret = types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+	}
+	if err = mgr.Add(notifications); err != nil {
+		setupLog.Error(err, "unable to add notifications")
+		os.Exit(1)
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create kubernetes clientset")
+		os.Exit(1)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create dynamic client")
+		os.Exit(1)
+	}
+
+	kraftClients := clients.NewKraftClients(kubeClient, dynamicClient, mgr.GetRESTMapper())
 
 	if err = (&controllers.DeploymentReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-		Deployments: deployments,
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		KraftClients:  kraftClients,
+		Recorder:      mgr.GetEventRecorderFor("flipper-controller"),
+		Notifications: notifications,
+		DryRun:        dryRun,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Deployment")
 		os.Exit(1)
 	}
+
+	if err = (&controllers.FlipperReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		KraftClients:  kraftClients,
+		Recorder:      mgr.GetEventRecorderFor("flipper-controller"),
+		Notifications: notifications,
+		DryRun:        dryRun,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Flipper")
+		os.Exit(1)
+	}
+	if err = mgr.Add(&controllers.StorageVersionMigrator{
+		Client: mgr.GetClient(),
+		Reader: mgr.GetAPIReader(),
+	}); err != nil {
+		setupLog.Error(err, "unable to add storage version migrator")
+		os.Exit(1)
+	}
+
+	// Webhooks need serving certificates, set ENABLE_WEBHOOKS=false to run the
+	// manager locally without them.
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&flipperv1beta1.Flipper{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Flipper")
+			os.Exit(1)
+		}
+		if err = (&webhooks.FlipperValidator{
+			Client: mgr.GetClient(),
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "FlipperValidator")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -100,11 +199,31 @@ func main() {
 		os.Exit(1)
 	}
 
-
-	go controllers.TimeTicker{Deployments: &deployments, Client: mgr.GetClient()}.Run()
+	ctx := ctrl.SetupSignalHandler()
+
+	flushTracing := func() {}
+	if otlpEndpoint != "" {
+		shutdownTracing, err := tracing.Setup(ctx, otlpEndpoint, otlpInsecure)
+		if err != nil {
+			setupLog.Error(err, "unable to set up tracing")
+			os.Exit(1)
+		}
+		flushTracing = func() {
+			// The manager's context is done by now, flush with a fresh one.
+			flushCtx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+			defer cancel()
+			err := shutdownTracing(flushCtx)
+			if err != nil {
+				setupLog.Error(err, "problem flushing traces")
+			}
+		}
+	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	err = mgr.Start(ctx)
+	// os.Exit skips deferred calls, so the traces are flushed before exiting.
+	flushTracing()
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
@@ -0,0 +1,262 @@
+// Package matching decides which workloads a Flipper restarts. The
+// controllers and the admission webhooks share it, so that both see the same
+// targets.
+package matching
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/anmolbabu/kraft-controller/models"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Selector decides which workloads a Flipper restarts.
+type Selector struct {
+	kinds             []models.WorkloadType
+	labels            labels.Selector
+	namespace         string
+	namespaceSelector labels.Selector
+	allNamespaces     bool
+	exclude           map[string]bool
+}
+
+// NewSelector builds the Selector described by config.
+func NewSelector(config *models.Config) (*Selector, error) {
+	scopes := 0
+	if config.Namespace != "" {
+		scopes++
+	}
+	if config.NamespaceSelector != nil {
+		scopes++
+	}
+	if config.AllNamespaces {
+		scopes++
+	}
+	if scopes > 1 {
+		return nil, fmt.Errorf("only one of namespace, namespaceSelector and allNamespaces may be set")
+	}
+
+	selector := &Selector{
+		labels:        labels.SelectorFromSet(config.Labels),
+		namespace:     config.Namespace,
+		allNamespaces: config.AllNamespaces,
+		exclude:       make(map[string]bool, len(config.Exclude)),
+	}
+
+	if config.Selector != nil {
+		labelSelector, err := metav1.LabelSelectorAsSelector(config.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("%w. invalid selector", err)
+		}
+
+		requirements, _ := labelSelector.Requirements()
+		selector.labels = selector.labels.Add(requirements...)
+	}
+
+	if config.NamespaceSelector != nil {
+		namespaceSelector, err := metav1.LabelSelectorAsSelector(config.NamespaceSelector)
+		if err != nil {
+			return nil, fmt.Errorf("%w. invalid namespaceSelector", err)
+		}
+
+		selector.namespaceSelector = namespaceSelector
+	}
+
+	for _, kind := range config.Kinds {
+		if kind.Kind == "" || kind.APIVersion == "" {
+			return nil, fmt.Errorf("unsupported workload kind: %s %s. apiVersion and kind must be set", kind.APIVersion, kind.Kind)
+		}
+
+		_, err := schema.ParseGroupVersion(kind.APIVersion)
+		if err != nil {
+			return nil, fmt.Errorf("%w. invalid apiVersion: %s", err, kind.APIVersion)
+		}
+
+		selector.kinds = append(selector.kinds, kind)
+	}
+
+	for _, name := range config.Exclude {
+		selector.exclude[name] = true
+	}
+
+	return selector, nil
+}
+
+// Excludes reports whether workload is explicitly excluded.
+func (selector *Selector) Excludes(workload models.Workload) bool {
+	return selector.exclude[workload.Name] || selector.exclude[fmt.Sprintf("%s/%s", workload.Namespace, workload.Name)]
+}
+
+// Namespaces returns the names of the namespaces selected by selector, or nil
+// when deployments are selected from a single namespace or all of them.
+func (selector *Selector) Namespaces(ctx context.Context, c client.Reader) (map[string]bool, error) {
+	if selector.namespaceSelector == nil {
+		return nil, nil
+	}
+
+	namespaceList := &corev1.NamespaceList{}
+	err := c.List(ctx, namespaceList, client.MatchingLabelsSelector{Selector: selector.namespaceSelector})
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := make(map[string]bool, len(namespaceList.Items))
+	for _, namespace := range namespaceList.Items {
+		namespaces[namespace.Name] = true
+	}
+
+	return namespaces, nil
+}
+
+// Matches reports whether selector matches workload, whose labels are
+// objectLabels.
+func (selector *Selector) Matches(ctx context.Context, c client.Reader, workload models.Workload, objectLabels map[string]string) (bool, error) {
+	namespaces, err := selector.Namespaces(ctx, c)
+	if err != nil {
+		return false, err
+	}
+
+	workload.Labels = objectLabels
+	return selector.Selects(workload, namespaces), nil
+}
+
+// Selects reports whether selector matches workload, given the namespaces it
+// selects as returned by Namespaces.
+func (selector *Selector) Selects(workload models.Workload, namespaces map[string]bool) bool {
+	kindMatches := false
+	for _, workloadType := range selector.kinds {
+		if workloadType.GroupVersionKind().GroupKind() == workload.Type.GroupVersionKind().GroupKind() {
+			kindMatches = true
+			break
+		}
+	}
+
+	if !kindMatches || !selector.labels.Matches(labels.Set(workload.Labels)) || selector.Excludes(workload) {
+		return false
+	}
+
+	switch {
+	case namespaces != nil:
+		return namespaces[workload.Namespace]
+	case selector.allNamespaces:
+		return true
+	default:
+		return workload.Namespace == selector.namespace
+	}
+}
+
+// Overlaps reports whether selector and other may match the same workloads.
+// Namespace selectors and label selectors other than equality ones are
+// assumed to overlap, as telling for sure would take a look at the cluster.
+func (selector *Selector) Overlaps(other *Selector) bool {
+	kindsOverlap := false
+	for _, kind := range selector.kinds {
+		for _, otherKind := range other.kinds {
+			if kind.GroupVersionKind().GroupKind() == otherKind.GroupVersionKind().GroupKind() {
+				kindsOverlap = true
+			}
+		}
+	}
+	if !kindsOverlap {
+		return false
+	}
+
+	if selector.namespaceSelector == nil && !selector.allNamespaces && other.namespaceSelector == nil && !other.allNamespaces && selector.namespace != other.namespace {
+		return false
+	}
+
+	requirements, _ := selector.labels.Requirements()
+	otherRequirements, _ := other.labels.Requirements()
+	for _, requirement := range requirements {
+		values, ok := requiredValues(requirement)
+		if !ok {
+			continue
+		}
+
+		for _, otherRequirement := range otherRequirements {
+			otherValues, ok := requiredValues(otherRequirement)
+			if ok && requirement.Key() == otherRequirement.Key() && !values.HasAny(otherValues.UnsortedList()...) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// requiredValues returns the values one of which requirement demands of its
+// label, and false when it does not demand any particular ones.
+func requiredValues(requirement labels.Requirement) (sets.String, bool) {
+	switch requirement.Operator() {
+	case selection.Equals, selection.DoubleEquals, selection.In:
+		return requirement.Values(), true
+	default:
+		return nil, false
+	}
+}
+
+// ListOptions narrows lists of workloads down to those that may match
+// selector, given the namespaces it selects as returned by Namespaces.
+func (selector *Selector) ListOptions(namespaces map[string]bool) []client.ListOption {
+	listOpts := []client.ListOption{client.MatchingLabelsSelector{Selector: selector.labels}}
+	if !selector.allNamespaces && namespaces == nil {
+		listOpts = append(listOpts, client.InNamespace(selector.namespace))
+	}
+
+	return listOpts
+}
+
+// Targets returns every workload matched by selector, keyed by
+// models.Workload.Key.
+func Targets(ctx context.Context, c client.Reader, selector *Selector) (map[string]models.Workload, error) {
+	namespaces, err := selector.Namespaces(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	listOpts := selector.ListOptions(namespaces)
+
+	workloads := make(map[string]models.Workload)
+	for _, workloadType := range selector.kinds {
+		gvk := workloadType.GroupVersionKind()
+		// Only the metadata is needed to select workloads, which keeps the
+		// cache small for kinds that are not watched otherwise.
+		objectList := &metav1.PartialObjectMetadataList{}
+		objectList.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+
+		err = c.List(ctx, objectList, listOpts...)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range objectList.Items {
+			if namespaces != nil && !namespaces[object.Namespace] {
+				continue
+			}
+
+			workload := models.Workload{
+				Type:        workloadType,
+				Namespace:   object.Namespace,
+				Name:        object.Name,
+				UID:         object.UID,
+				Labels:      object.Labels,
+				Annotations: object.Annotations,
+			}
+			if selector.Excludes(workload) {
+				continue
+			}
+
+			workloads[workload.Key()] = workload
+		}
+	}
+
+	return workloads, nil
+}
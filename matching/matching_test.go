@@ -0,0 +1,125 @@
+package matching
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/models"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Target matching", func() {
+	deploymentType := models.BuiltinWorkloadTypes[models.KindDeployment]
+	statefulSetType := models.BuiltinWorkloadTypes[models.KindStatefulSet]
+
+	objectMeta := func(namespace string, name string, app string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{"app": app, "tier": "frontend"}}
+	}
+
+	newClient := func() client.Client {
+		testScheme := runtime.NewScheme()
+		Expect(scheme.AddToScheme(testScheme)).To(Succeed())
+
+		return fake.NewClientBuilder().WithScheme(testScheme).WithObjects(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Labels: map[string]string{"team": "web"}}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "batch"}},
+			&appsv1.Deployment{ObjectMeta: objectMeta("apps", "web", "web")},
+			&appsv1.Deployment{ObjectMeta: objectMeta("apps", "api", "api")},
+			&appsv1.Deployment{ObjectMeta: objectMeta("batch", "web", "web")},
+			&appsv1.StatefulSet{ObjectMeta: objectMeta("apps", "db", "web")},
+		).Build()
+	}
+
+	for _, tc := range []struct {
+		name    string
+		config  models.Config
+		targets []string
+	}{
+		{
+			name:    "matches workloads by label in a single namespace",
+			config:  models.Config{Labels: map[string]string{"app": "web"}, Namespace: "apps", Kinds: []models.WorkloadType{deploymentType}},
+			targets: []string{"apps/web"},
+		},
+		{
+			name: "matches workloads by selector",
+			config: models.Config{
+				Selector:  &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"web", "api"}}}},
+				Namespace: "apps",
+				Kinds:     []models.WorkloadType{deploymentType},
+			},
+			targets: []string{"apps/api", "apps/web"},
+		},
+		{
+			name:    "matches workloads in every namespace",
+			config:  models.Config{Labels: map[string]string{"app": "web"}, AllNamespaces: true, Kinds: []models.WorkloadType{deploymentType}},
+			targets: []string{"apps/web", "batch/web"},
+		},
+		{
+			name: "matches workloads in the namespaces selected",
+			config: models.Config{
+				Labels:            map[string]string{"app": "web"},
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "web"}},
+				Kinds:             []models.WorkloadType{deploymentType},
+			},
+			targets: []string{"apps/web"},
+		},
+		{
+			name:    "matches workloads of every kind selected",
+			config:  models.Config{Labels: map[string]string{"app": "web"}, Namespace: "apps", Kinds: []models.WorkloadType{deploymentType, statefulSetType}},
+			targets: []string{"apps/db", "apps/web"},
+		},
+		{
+			name: "leaves out excluded workloads",
+			config: models.Config{
+				Labels:        map[string]string{"tier": "frontend"},
+				AllNamespaces: true,
+				Exclude:       []string{"api", "batch/web"},
+				Kinds:         []models.WorkloadType{deploymentType},
+			},
+			targets: []string{"apps/web"},
+		},
+	} {
+		tc := tc
+		It(tc.name, func() {
+			selector, err := NewSelector(&tc.config)
+			Expect(err).NotTo(HaveOccurred())
+
+			c := newClient()
+			targets, err := Targets(context.Background(), c, selector)
+			Expect(err).NotTo(HaveOccurred())
+
+			var names []string
+			for _, workload := range targets {
+				names = append(names, workload.Namespace+"/"+workload.Name)
+				Expect(workload.Labels).To(HaveKey("app"))
+			}
+			Expect(names).To(ConsistOf(tc.targets))
+
+			// Matches agrees with Targets on every workload there is.
+			namespaces, err := selector.Namespaces(context.Background(), c)
+			Expect(err).NotTo(HaveOccurred())
+			for _, workload := range []models.Workload{
+				{Type: deploymentType, Namespace: "apps", Name: "web", Labels: map[string]string{"app": "web", "tier": "frontend"}},
+				{Type: deploymentType, Namespace: "batch", Name: "web", Labels: map[string]string{"app": "web", "tier": "frontend"}},
+				{Type: statefulSetType, Namespace: "apps", Name: "db", Labels: map[string]string{"app": "web", "tier": "frontend"}},
+			} {
+				_, matched := targets[workload.Key()]
+				Expect(selector.Selects(workload, namespaces)).To(Equal(matched), workload.Key())
+			}
+		})
+	}
+
+	It("rejects more than one namespace scope", func() {
+		_, err := NewSelector(&models.Config{Namespace: "apps", AllNamespaces: true})
+		Expect(err).To(HaveOccurred())
+	})
+})
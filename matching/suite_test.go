@@ -0,0 +1,14 @@
+package matching
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMatching(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Matching Suite")
+}
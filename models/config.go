@@ -1,7 +1,56 @@
 package models
 
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 type Config struct {
-	Interval string `json:"interval"`
-	Labels    map[string]string `json:"labels"`
-	Namespace string            `json:"namespace"`
+	Interval             string                `json:"interval"`
+	Schedule             string                `json:"schedule"`
+	TimeZone             string                `json:"timeZone"`
+	Windows              []Window              `json:"windows"`
+	Blackouts            []Blackout            `json:"blackouts"`
+	Labels               map[string]string     `json:"labels"`
+	Selector             *metav1.LabelSelector `json:"selector"`
+	Namespace            string                `json:"namespace"`
+	NamespaceSelector    *metav1.LabelSelector `json:"namespaceSelector"`
+	AllNamespaces        bool                  `json:"allNamespaces"`
+	Exclude              []string              `json:"exclude"`
+	Kinds                []WorkloadType        `json:"kinds"`
+	Priority             int32                 `json:"priority"`
+	CoalesceWindow       string                `json:"coalesceWindow"`
+	Strategy             Strategy              `json:"strategy"`
+	MaxPodAge            string                `json:"maxPodAge"`
+	ReloadOnConfigChange bool                  `json:"reloadOnConfigChange"`
+	Suspend              bool                  `json:"suspend"`
+	CatchUpPolicy        string                `json:"catchUpPolicy"`
+	DryRun               bool                  `json:"dryRun"`
+}
+
+// Strategy decides how many targets of a run are restarted at the same time,
+// in which order, how long their rollouts may take, whether stuck ones are
+// rolled back, what happens to those blocked by a PodDisruptionBudget and how
+// they are restarted.
+type Strategy struct {
+	Type                   string `json:"type"`
+	MaxConcurrent          int    `json:"maxConcurrent"`
+	Order                  string `json:"order"`
+	RolloutTimeout         string `json:"rolloutTimeout"`
+	Rollback               bool   `json:"rollback"`
+	DisruptionBudgetPolicy string `json:"disruptionBudgetPolicy"`
+	RestartMethod          string `json:"restartMethod"`
+	OrderByPodDeletionCost bool   `json:"orderByPodDeletionCost"`
+}
+
+type Window struct {
+	Start    string `json:"start"`
+	Duration string `json:"duration"`
+}
+
+type Blackout struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason"`
 }
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+)
+
+// ConfigFromFlipper returns the config described by the spec of flipper.
+func ConfigFromFlipper(flipper v1beta1.Flipper) *Config {
+	config := &Config{
+		Interval:             flipper.Spec.Interval,
+		Schedule:             flipper.Spec.Schedule,
+		TimeZone:             flipper.Spec.TimeZone,
+		Labels:               flipper.Spec.Match.Labels,
+		Selector:             flipper.Spec.Match.Selector,
+		Namespace:            flipper.Spec.Match.Namespace,
+		NamespaceSelector:    flipper.Spec.Match.NamespaceSelector,
+		AllNamespaces:        flipper.Spec.Match.AllNamespaces,
+		Exclude:              flipper.Spec.Match.Exclude,
+		Priority:             flipper.Spec.Priority,
+		CoalesceWindow:       flipper.Spec.CoalesceWindow,
+		MaxPodAge:            flipper.Spec.MaxPodAge,
+		ReloadOnConfigChange: flipper.Spec.ReloadOnConfigChange,
+		Suspend:              flipper.Spec.Suspend,
+		CatchUpPolicy:        string(flipper.Spec.CatchUpPolicy),
+		DryRun:               flipper.Spec.DryRun,
+		Strategy: Strategy{
+			Type:                   string(flipper.Spec.Strategy.Type),
+			MaxConcurrent:          int(flipper.Spec.Strategy.MaxConcurrent),
+			Order:                  string(flipper.Spec.Strategy.Order),
+			RolloutTimeout:         flipper.Spec.Strategy.RolloutTimeout,
+			Rollback:               flipper.Spec.Strategy.RollbackOnFailure,
+			DisruptionBudgetPolicy: string(flipper.Spec.Strategy.DisruptionBudgetPolicy),
+			RestartMethod:          string(flipper.Spec.Strategy.RestartMethod),
+			OrderByPodDeletionCost: flipper.Spec.Strategy.OrderByPodDeletionCost,
+		},
+	}
+
+	// Flippers with a max pod age check their pods every hour by default.
+	if config.MaxPodAge != "" && config.Interval == "" && config.Schedule == "" {
+		config.Interval = v1beta1.DefaultPodAgeCheckInterval
+	}
+
+	if config.Namespace == "" && config.NamespaceSelector == nil && !config.AllNamespaces {
+		config.Namespace = flipper.Namespace
+	}
+
+	for _, kind := range flipper.Spec.Match.Kinds {
+		config.Kinds = append(config.Kinds, BuiltinWorkloadTypes[string(kind)])
+	}
+
+	for _, kind := range flipper.Spec.Match.CustomKinds {
+		workloadType := WorkloadType{
+			APIVersion:              kind.APIVersion,
+			Kind:                    kind.Kind,
+			TemplateAnnotationsPath: kind.TemplateAnnotationsPath,
+		}
+
+		if workloadType.TemplateAnnotationsPath == "" {
+			workloadType.TemplateAnnotationsPath = DefaultTemplateAnnotationsPath
+		}
+
+		if kind.Health != nil {
+			workloadType.Health = Health{
+				ConditionType: kind.Health.ConditionType,
+				ReadyPath:     kind.Health.ReadyPath,
+				DesiredPath:   kind.Health.DesiredPath,
+				UpdatedPath:   kind.Health.UpdatedPath,
+			}
+		}
+
+		config.Kinds = append(config.Kinds, workloadType)
+	}
+
+	if len(config.Kinds) == 0 {
+		config.Kinds = []WorkloadType{BuiltinWorkloadTypes[KindDeployment]}
+	}
+
+	for _, window := range flipper.Spec.Windows {
+		config.Windows = append(config.Windows, Window{
+			Start:    window.Start,
+			Duration: window.Duration,
+		})
+	}
+
+	for _, blackout := range flipper.Spec.Blackouts {
+		config.Blackouts = append(config.Blackouts, Blackout{
+			Start:  blackout.Start.Time,
+			End:    blackout.End.Time,
+			Reason: blackout.Reason,
+		})
+	}
+
+	return config
+}
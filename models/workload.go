@@ -0,0 +1,113 @@
+package models
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+	KindDaemonSet   = "DaemonSet"
+
+	// DefaultTemplateAnnotationsPath is where the pod template annotations of
+	// the built in kinds, and of most custom ones, live.
+	DefaultTemplateAnnotationsPath = ".spec.template.metadata.annotations"
+)
+
+// Health tells how to read the health of a workload from its status. A
+// workload is healthy when the condition of type ConditionType is True and
+// the values at ReadyPath and UpdatedPath have caught up with the value at
+// DesiredPath, while the value at TotalPath does not exceed it any more.
+// Checks that are not set are skipped.
+type Health struct {
+	ConditionType string `json:"conditionType"`
+	ReadyPath     string `json:"readyPath"`
+	DesiredPath   string `json:"desiredPath"`
+	UpdatedPath   string `json:"updatedPath"`
+	TotalPath     string `json:"totalPath"`
+}
+
+// Availability returns the checks of health that tell whether the workload is
+// available, leaving out those that tell whether its rollout is complete.
+func (health Health) Availability() Health {
+	health.UpdatedPath = ""
+	health.TotalPath = ""
+
+	return health
+}
+
+// WorkloadType describes a kind of workload a Flipper can restart.
+type WorkloadType struct {
+	APIVersion              string `json:"apiVersion"`
+	Kind                    string `json:"kind"`
+	TemplateAnnotationsPath string `json:"templateAnnotationsPath"`
+	Health                  Health `json:"health"`
+}
+
+// GroupVersionKind returns the API type of the workload type.
+func (workloadType WorkloadType) GroupVersionKind() schema.GroupVersionKind {
+	return schema.FromAPIVersionAndKind(workloadType.APIVersion, workloadType.Kind)
+}
+
+// BuiltinWorkloadTypes are the kinds of workloads known without any further
+// configuration.
+var BuiltinWorkloadTypes = map[string]WorkloadType{
+	KindDeployment: {
+		APIVersion:              "apps/v1",
+		Kind:                    KindDeployment,
+		TemplateAnnotationsPath: DefaultTemplateAnnotationsPath,
+		Health: Health{
+			ReadyPath:   ".status.availableReplicas",
+			DesiredPath: ".spec.replicas",
+			UpdatedPath: ".status.updatedReplicas",
+			TotalPath:   ".status.replicas",
+		},
+	},
+	KindStatefulSet: {
+		APIVersion:              "apps/v1",
+		Kind:                    KindStatefulSet,
+		TemplateAnnotationsPath: DefaultTemplateAnnotationsPath,
+		Health: Health{
+			ReadyPath:   ".status.readyReplicas",
+			DesiredPath: ".spec.replicas",
+			UpdatedPath: ".status.updatedReplicas",
+			TotalPath:   ".status.replicas",
+		},
+	},
+	KindDaemonSet: {
+		APIVersion:              "apps/v1",
+		Kind:                    KindDaemonSet,
+		TemplateAnnotationsPath: DefaultTemplateAnnotationsPath,
+		Health: Health{
+			ReadyPath:   ".status.numberAvailable",
+			DesiredPath: ".status.desiredNumberScheduled",
+			UpdatedPath: ".status.updatedNumberScheduled",
+		},
+	},
+}
+
+// Workload identifies an object restarted by a Flipper.
+type Workload struct {
+	Type        WorkloadType      `json:"type"`
+	Namespace   string            `json:"namespace"`
+	Name        string            `json:"name"`
+	UID         types.UID         `json:"uid"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// Key identifies the workload in maps of workloads.
+func (workload Workload) Key() string {
+	return WorkloadKey(workload.Type.APIVersion, workload.Type.Kind, workload.Namespace, workload.Name)
+}
+
+// WorkloadKey identifies a workload in maps of workloads. Workloads of the
+// same group and kind share keys across API versions.
+func WorkloadKey(apiVersion string, kind string, namespace string, name string) string {
+	groupKind := schema.FromAPIVersionAndKind(apiVersion, kind).GroupKind()
+
+	return fmt.Sprintf("%s/%s/%s", groupKind.String(), namespace, name)
+}
@@ -0,0 +1,195 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// The types of Events.
+const (
+	RunStarted   = "RunStarted"
+	RunSucceeded = "RunSucceeded"
+	RunFailed    = "RunFailed"
+	RolledBack   = "RolledBack"
+)
+
+const (
+	// cloudEventsContentType is the content type of structured CloudEvents.
+	cloudEventsContentType = "application/cloudevents+json"
+	// cloudEventsTypePrefix prefixes the type of an Event in its CloudEvent.
+	cloudEventsTypePrefix = "io.flipper."
+
+	// sendTimeout bounds a single attempt at delivering a notification.
+	sendTimeout = 10 * time.Second
+)
+
+// DefaultBackoff is how often and how far apart a notification is retried.
+var DefaultBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    5,
+}
+
+// errPermanent marks responses that retrying will not change.
+var errPermanent = errors.New("rejected by the endpoint")
+
+// Target is the outcome of a target of a run.
+type Target struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Result    string `json:"result,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// Event is something that happened to a run of a Flipper.
+type Event struct {
+	// Type is one of RunStarted, RunSucceeded, RunFailed and RolledBack.
+	Type      string    `json:"type"`
+	Namespace string    `json:"namespace"`
+	Flipper   string    `json:"flipper"`
+	Run       string    `json:"run"`
+	Trigger   string    `json:"trigger"`
+	DryRun    bool      `json:"dryRun,omitempty"`
+	Time      time.Time `json:"time"`
+	Targets   []Target  `json:"targets,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// Endpoint is where notifications are sent and how.
+type Endpoint struct {
+	URL string
+	// Token is sent as a bearer token when set.
+	Token string
+	// Template renders the body of notifications. They are sent as
+	// CloudEvents when it is nil.
+	Template *template.Template
+}
+
+// ParseTemplate parses text into a template that renders Events.
+func ParseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("notification").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w. invalid notification template", err)
+	}
+
+	return tmpl, nil
+}
+
+// Notifier POSTs Events to Endpoints.
+type Notifier struct {
+	client  *http.Client
+	backoff wait.Backoff
+}
+
+// NewNotifier returns a Notifier that retries with backoff.
+func NewNotifier(client *http.Client, backoff wait.Backoff) *Notifier {
+	if client == nil {
+		client = &http.Client{Timeout: sendTimeout}
+	}
+
+	return &Notifier{
+		client:  client,
+		backoff: backoff,
+	}
+}
+
+// Send delivers event to endpoint, retrying failed attempts with backoff.
+// Attempts that the endpoint answers with a client error other than 408 or
+// 429 are not retried.
+func (notifier *Notifier) Send(ctx context.Context, endpoint Endpoint, event Event) error {
+	body, contentType, err := Render(endpoint, event)
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	err = wait.ExponentialBackoffWithContext(ctx, notifier.backoff, func() (bool, error) {
+		lastErr = notifier.post(ctx, endpoint, body, contentType)
+		if errors.Is(lastErr, errPermanent) {
+			return false, lastErr
+		}
+
+		return lastErr == nil, nil
+	})
+	if errors.Is(err, wait.ErrWaitTimeout) {
+		return fmt.Errorf("%w. failed to notify %s of %s after %d attempts", lastErr, endpoint.URL, event.Type, notifier.backoff.Steps)
+	}
+
+	return err
+}
+
+// post makes a single attempt at delivering body.
+func (notifier *Notifier) post(ctx context.Context, endpoint Endpoint, body []byte, contentType string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w. %v. invalid notification url", errPermanent, err)
+	}
+
+	request.Header.Set("Content-Type", contentType)
+	if endpoint.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+endpoint.Token)
+	}
+
+	response, err := notifier.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+
+	switch {
+	case response.StatusCode < 300:
+		return nil
+	case response.StatusCode == http.StatusRequestTimeout, response.StatusCode == http.StatusTooManyRequests, response.StatusCode >= 500:
+		return fmt.Errorf("notification endpoint answered: %s", response.Status)
+	default:
+		return fmt.Errorf("%w. notification endpoint answered: %s", errPermanent, response.Status)
+	}
+}
+
+// Render returns the body of the notification of event for endpoint, and its
+// content type.
+func Render(endpoint Endpoint, event Event) ([]byte, string, error) {
+	if endpoint.Template != nil {
+		var body bytes.Buffer
+		err := endpoint.Template.Execute(&body, event)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w. failed to render the %s notification of flipper: %s in namespace: %s", err, event.Type, event.Flipper, event.Namespace)
+		}
+
+		return body.Bytes(), "application/json", nil
+	}
+
+	body, err := json.Marshal(cloudEvent(event))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w. failed to render the %s notification of flipper: %s in namespace: %s", err, event.Type, event.Flipper, event.Namespace)
+	}
+
+	return body, cloudEventsContentType, nil
+}
+
+// cloudEvent wraps event in a CloudEvents 1.0 envelope in the structured mode.
+func cloudEvent(event Event) map[string]interface{} {
+	return map[string]interface{}{
+		"specversion":     "1.0",
+		"id":              fmt.Sprintf("%s/%s/%s", event.Namespace, event.Run, event.Type),
+		"source":          fmt.Sprintf("/apis/flipper.flipper.io/v1beta1/namespaces/%s/flippers/%s", event.Namespace, event.Flipper),
+		"type":            cloudEventsTypePrefix + event.Type,
+		"subject":         event.Run,
+		"time":            event.Time.UTC().Format(time.RFC3339),
+		"datacontenttype": "application/json",
+		"data":            event,
+	}
+}
@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+var _ = Describe("Notifier", func() {
+	backoff := wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}
+
+	event := Event{
+		Type:      RunFailed,
+		Namespace: "apps",
+		Flipper:   "nightly",
+		Run:       "nightly-x7k2p",
+		Trigger:   "Schedule",
+		Time:      time.Date(2021, 7, 6, 3, 30, 0, 0, time.UTC),
+		Targets:   []Target{{Kind: "Deployment", Namespace: "apps", Name: "web", Result: "Failed", Message: "rollout timed out"}},
+	}
+
+	// serve answers requests with statuses in turn, the last one from then on,
+	// and counts them.
+	serve := func(statuses ...int) (*httptest.Server, *int32, chan *http.Request) {
+		var attempts int32
+		requests := make(chan *http.Request, 10)
+		server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			attempt := int(atomic.AddInt32(&attempts, 1))
+			requests <- request
+			if attempt > len(statuses) {
+				attempt = len(statuses)
+			}
+			writer.WriteHeader(statuses[attempt-1])
+		}))
+
+		return server, &attempts, requests
+	}
+
+	It("renders events as structured CloudEvents", func() {
+		body, contentType, err := Render(Endpoint{}, event)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(contentType).To(Equal("application/cloudevents+json"))
+
+		var cloudEvent map[string]interface{}
+		Expect(json.Unmarshal(body, &cloudEvent)).To(Succeed())
+		Expect(cloudEvent).To(HaveKeyWithValue("specversion", "1.0"))
+		Expect(cloudEvent).To(HaveKeyWithValue("type", "io.flipper.RunFailed"))
+		Expect(cloudEvent).To(HaveKeyWithValue("source", "/apis/flipper.flipper.io/v1beta1/namespaces/apps/flippers/nightly"))
+		Expect(cloudEvent).To(HaveKeyWithValue("subject", "nightly-x7k2p"))
+		Expect(cloudEvent).To(HaveKeyWithValue("time", "2021-07-06T03:30:00Z"))
+		Expect(cloudEvent["data"]).To(HaveKeyWithValue("flipper", "nightly"))
+	})
+
+	It("renders events with templates", func() {
+		tmpl, err := ParseTemplate(`{"text": "{{ .Type }} {{ .Namespace }}/{{ .Flipper }}{{ range .Targets }} {{ .Name }}={{ .Result }}{{ end }}"}`)
+		Expect(err).NotTo(HaveOccurred())
+
+		body, contentType, err := Render(Endpoint{Template: tmpl}, event)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(contentType).To(Equal("application/json"))
+		Expect(string(body)).To(Equal(`{"text": "RunFailed apps/nightly web=Failed"}`))
+
+		_, err = ParseTemplate("{{ .Type")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("sends the token as a bearer token", func() {
+		server, _, requests := serve(http.StatusAccepted)
+		defer server.Close()
+
+		notifier := NewNotifier(server.Client(), backoff)
+		Expect(notifier.Send(context.Background(), Endpoint{URL: server.URL, Token: "s3cr3t"}, event)).To(Succeed())
+
+		request := <-requests
+		Expect(request.Method).To(Equal(http.MethodPost))
+		Expect(request.Header.Get("Authorization")).To(Equal("Bearer s3cr3t"))
+		Expect(request.Header.Get("Content-Type")).To(Equal("application/cloudevents+json"))
+	})
+
+	It("retries server errors until they go away", func() {
+		server, attempts, _ := serve(http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+		defer server.Close()
+
+		notifier := NewNotifier(server.Client(), backoff)
+		Expect(notifier.Send(context.Background(), Endpoint{URL: server.URL}, event)).To(Succeed())
+		Expect(atomic.LoadInt32(attempts)).To(BeEquivalentTo(3))
+	})
+
+	It("gives up after the last retry", func() {
+		server, attempts, _ := serve(http.StatusInternalServerError)
+		defer server.Close()
+
+		notifier := NewNotifier(server.Client(), backoff)
+		err := notifier.Send(context.Background(), Endpoint{URL: server.URL}, event)
+		Expect(err).To(MatchError(ContainSubstring("after 3 attempts")))
+		Expect(atomic.LoadInt32(attempts)).To(BeEquivalentTo(3))
+	})
+
+	It("does not retry requests the endpoint rejects", func() {
+		server, attempts, _ := serve(http.StatusUnauthorized)
+		defer server.Close()
+
+		notifier := NewNotifier(server.Client(), backoff)
+		err := notifier.Send(context.Background(), Endpoint{URL: server.URL}, event)
+		Expect(err).To(MatchError(ContainSubstring("401")))
+		Expect(atomic.LoadInt32(attempts)).To(BeEquivalentTo(1))
+	})
+})
@@ -0,0 +1,159 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/models"
+	"github.com/robfig/cron/v3"
+)
+
+// intervalReference and intervalSamples are where and for how many runs
+// ShortestInterval samples a schedule.
+var (
+	intervalReference = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	intervalSamples   = 64
+)
+
+// Schedule computes the restart times of a Flipper.
+type Schedule interface {
+	// Next returns the first restart time strictly after t.
+	Next(t time.Time) time.Time
+}
+
+// intervalSchedule restarts at a fixed interval from the previous run.
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// Parse builds the Schedule described by config. Exactly one of Interval and
+// Schedule has to be set.
+func Parse(config *models.Config) (Schedule, error) {
+	switch {
+	case config.Interval != "" && config.Schedule != "":
+		return nil, fmt.Errorf("only one of interval and schedule may be set")
+	case config.Interval != "":
+		return parseInterval(config.Interval)
+	case config.Schedule != "":
+		return parseCron(config.Schedule, config.TimeZone)
+	default:
+		return nil, fmt.Errorf("one of interval and schedule must be set")
+	}
+}
+
+// ShortestInterval returns the shortest time between two consecutive runs of
+// s, sampled over a fixed stretch of time so that it does not depend on when
+// it is asked. It is used to compare how often Flippers restart.
+func ShortestInterval(s Schedule) time.Duration {
+	var shortest time.Duration
+
+	previous := s.Next(intervalReference)
+	for run := 0; run < intervalSamples && !previous.IsZero(); run++ {
+		next := s.Next(previous)
+		if next.IsZero() {
+			break
+		}
+
+		if interval := next.Sub(previous); shortest == 0 || interval < shortest {
+			shortest = interval
+		}
+		previous = next
+	}
+
+	return shortest
+}
+
+func parseInterval(interval string) (Schedule, error) {
+	duration, err := time.ParseDuration(interval)
+	if err != nil {
+		return nil, fmt.Errorf("%w. invalid interval: %s", err, interval)
+	}
+
+	if duration <= 0 {
+		return nil, fmt.Errorf("invalid interval: %s. interval must be positive", interval)
+	}
+
+	return intervalSchedule{interval: duration}, nil
+}
+
+func parseCron(spec string, timeZone string) (Schedule, error) {
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		return nil, fmt.Errorf("invalid schedule: %s. use timeZone instead of a TZ prefix", spec)
+	}
+
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("%w. invalid timeZone: %s", err, timeZone)
+	}
+
+	parsed, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%w. invalid schedule: %s", err, spec)
+	}
+
+	specSchedule, ok := parsed.(*cron.SpecSchedule)
+	if !ok {
+		return parsed, nil
+	}
+	specSchedule.Location = location
+
+	return cronSchedule{spec: specSchedule, location: location}, nil
+}
+
+// cronSchedule matches the cron fields against the wall clock of location, so
+// a 03:30 schedule keeps firing at 03:30 local time across DST changes. Every
+// wall clock time fires exactly once: a time skipped when the clocks go
+// forward fires right after the change, and a time repeated when the clocks
+// go back only fires on its first occurrence.
+type cronSchedule struct {
+	spec     *cron.SpecSchedule
+	location *time.Location
+}
+
+func (s cronSchedule) Next(t time.Time) time.Time {
+	next := s.spec.Next(t)
+	if s.isRepeated(next) {
+		next = s.spec.Next(next)
+	}
+
+	// Evaluating the fields with the UTC offset in effect at t finds wall
+	// clock times that never happen in location because the clocks skip them.
+	_, offset := t.In(s.location).Zone()
+	fixed := *s.spec
+	fixed.Location = time.FixedZone("", offset)
+
+	shifted := fixed.Next(t)
+	_, shiftedOffset := shifted.In(s.location).Zone()
+	if shifted.Before(next) && shiftedOffset > offset && !sameWallClock(shifted.In(fixed.Location), shifted.In(s.location)) {
+		return shifted
+	}
+
+	return next
+}
+
+// isRepeated reports whether the wall clock time of t already happened
+// earlier because the clocks went back shortly before t.
+func (s cronSchedule) isRepeated(t time.Time) bool {
+	t = t.In(s.location)
+	_, offset := t.Zone()
+	_, offsetBefore := t.Add(-maxDSTShift).Zone()
+	if offsetBefore <= offset {
+		return false
+	}
+
+	earlier := t.Add(-time.Duration(offsetBefore-offset) * time.Second)
+
+	return sameWallClock(earlier.In(s.location), t)
+}
+
+// maxDSTShift bounds how far a DST change moves the clocks.
+const maxDSTShift = 3 * time.Hour
+
+func sameWallClock(a time.Time, b time.Time) bool {
+	return a.Format("2006-01-02 15:04") == b.Format("2006-01-02 15:04")
+}
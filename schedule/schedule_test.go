@@ -0,0 +1,105 @@
+package schedule
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/models"
+)
+
+var _ = Describe("Parse", func() {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	It("rejects configs without exactly one of interval and schedule", func() {
+		_, err := Parse(&models.Config{})
+		Expect(err).To(HaveOccurred())
+
+		_, err = Parse(&models.Config{Interval: "1h", Schedule: "@daily"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects invalid intervals, schedules and time zones", func() {
+		for _, config := range []models.Config{
+			{Interval: "soon"},
+			{Interval: "-1h"},
+			{Schedule: "61 * * * *"},
+			{Schedule: "CRON_TZ=Europe/Berlin 30 3 * * *"},
+			{Schedule: "30 3 * * *", TimeZone: "Mars/Olympus_Mons"},
+		} {
+			config := config
+			_, err := Parse(&config)
+			Expect(err).To(HaveOccurred(), "%+v", config)
+		}
+	})
+
+	It("adds the interval to the previous run", func() {
+		schedule, err := Parse(&models.Config{Interval: "12h"})
+		Expect(err).NotTo(HaveOccurred())
+
+		from := time.Date(2021, 7, 1, 8, 0, 0, 0, time.UTC)
+		Expect(schedule.Next(from)).To(Equal(from.Add(12 * time.Hour)))
+	})
+
+	It("evaluates cron expressions in the configured time zone", func() {
+		schedule, err := Parse(&models.Config{Schedule: "30 3 * * 1-5", TimeZone: "Europe/Berlin"})
+		Expect(err).NotTo(HaveOccurred())
+
+		// Friday evening, so the next run is on Monday morning.
+		next := schedule.Next(time.Date(2021, 7, 2, 20, 0, 0, 0, berlin))
+		Expect(next).To(BeTemporally("==", time.Date(2021, 7, 5, 3, 30, 0, 0, berlin)))
+		Expect(next.UTC().Hour()).To(Equal(1))
+	})
+
+	It("keeps the wall clock time across DST changes", func() {
+		schedule, err := Parse(&models.Config{Schedule: "30 3 * * *", TimeZone: "Europe/Berlin"})
+		Expect(err).NotTo(HaveOccurred())
+
+		next := schedule.Next(time.Date(2021, 3, 27, 12, 0, 0, 0, berlin))
+		Expect(next).To(BeTemporally("==", time.Date(2021, 3, 28, 1, 30, 0, 0, time.UTC)))
+
+		next = schedule.Next(time.Date(2021, 10, 30, 12, 0, 0, 0, berlin))
+		Expect(next).To(BeTemporally("==", time.Date(2021, 10, 31, 2, 30, 0, 0, time.UTC)))
+	})
+
+	It("runs a time skipped by the clocks going forward right after the change", func() {
+		schedule, err := Parse(&models.Config{Schedule: "30 2 * * *", TimeZone: "Europe/Berlin"})
+		Expect(err).NotTo(HaveOccurred())
+
+		next := schedule.Next(time.Date(2021, 3, 27, 12, 0, 0, 0, berlin))
+		Expect(next).To(BeTemporally("==", time.Date(2021, 3, 28, 1, 30, 0, 0, time.UTC)))
+
+		next = schedule.Next(next)
+		Expect(next).To(BeTemporally("==", time.Date(2021, 3, 29, 2, 30, 0, 0, berlin)))
+	})
+
+	It("runs a time repeated by the clocks going back only once", func() {
+		schedule, err := Parse(&models.Config{Schedule: "30 2 * * *", TimeZone: "Europe/Berlin"})
+		Expect(err).NotTo(HaveOccurred())
+
+		next := schedule.Next(time.Date(2021, 10, 30, 12, 0, 0, 0, berlin))
+		Expect(next).To(BeTemporally("==", time.Date(2021, 10, 31, 0, 30, 0, 0, time.UTC)))
+
+		next = schedule.Next(next)
+		Expect(next).To(BeTemporally("==", time.Date(2021, 11, 1, 2, 30, 0, 0, berlin)))
+	})
+})
+
+var _ = Describe("ShortestInterval", func() {
+	It("returns the interval of interval schedules", func() {
+		schedule, err := Parse(&models.Config{Interval: "90m"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ShortestInterval(schedule)).To(Equal(90 * time.Minute))
+	})
+
+	It("returns the shortest gap between the runs of cron schedules", func() {
+		schedule, err := Parse(&models.Config{Schedule: "30 3 * * 1-5", TimeZone: "Europe/Berlin"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ShortestInterval(schedule)).To(Equal(24 * time.Hour))
+
+		schedule, err = Parse(&models.Config{Schedule: "0 0,6 * * *"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ShortestInterval(schedule)).To(Equal(6 * time.Hour))
+	})
+})
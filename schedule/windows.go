@@ -0,0 +1,147 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/models"
+)
+
+const (
+	// ReasonOutsideWindow defers a run that came due outside of every
+	// maintenance window.
+	ReasonOutsideWindow = "OutsideMaintenanceWindow"
+	// ReasonBlackout defers a run that came due during a blackout.
+	ReasonBlackout = "Blackout"
+
+	// maxDeferralSteps bounds the search for the next allowed slot, so that
+	// windows and blackouts that always overlap cannot loop forever.
+	maxDeferralSteps = 1000
+)
+
+// Deferral explains why a run cannot happen at the time it is due.
+type Deferral struct {
+	Until   time.Time
+	Reason  string
+	Message string
+}
+
+// window is a recurring period of time that opens at every start.
+type window struct {
+	start    Schedule
+	duration time.Duration
+}
+
+// contains reports whether t falls into an occurrence of the window.
+func (w window) contains(t time.Time) bool {
+	// If any occurrence is open at t, the first start after t-duration is at
+	// or before t.
+	start := w.start.Next(t.Add(-w.duration))
+
+	return !start.IsZero() && !start.After(t)
+}
+
+// Windows restricts when the runs of a Flipper are allowed to happen.
+type Windows struct {
+	windows   []window
+	blackouts []models.Blackout
+}
+
+// ParseWindows builds the maintenance windows and blackouts described by
+// config.
+func ParseWindows(config *models.Config) (*Windows, error) {
+	windows := &Windows{}
+
+	for _, currWindow := range config.Windows {
+		start, err := parseCron(currWindow.Start, config.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("%w. invalid window start", err)
+		}
+
+		duration, err := time.ParseDuration(currWindow.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("%w. invalid window duration: %s", err, currWindow.Duration)
+		}
+
+		if duration <= 0 {
+			return nil, fmt.Errorf("invalid window duration: %s. duration must be positive", currWindow.Duration)
+		}
+
+		windows.windows = append(windows.windows, window{start: start, duration: duration})
+	}
+
+	for _, blackout := range config.Blackouts {
+		if !blackout.End.After(blackout.Start) {
+			return nil, fmt.Errorf("invalid blackout %q. end must be after start", blackout.Reason)
+		}
+
+		windows.blackouts = append(windows.blackouts, blackout)
+	}
+
+	return windows, nil
+}
+
+// Defer returns nil when a run is allowed at t. Otherwise it returns the next
+// time the run is allowed at and the reason it has to wait until then.
+func (w *Windows) Defer(t time.Time) (*Deferral, error) {
+	var deferral *Deferral
+
+	until := t
+	for step := 0; step < maxDeferralSteps; step++ {
+		reason, message, next := w.blockedUntil(until)
+		if reason == "" {
+			if deferral != nil {
+				deferral.Until = until
+			}
+			return deferral, nil
+		}
+
+		if next.IsZero() {
+			return nil, fmt.Errorf("no allowed slot after %s. %s", until.Format(time.RFC3339), message)
+		}
+
+		if deferral == nil {
+			deferral = &Deferral{Reason: reason, Message: message}
+		}
+		until = next
+	}
+
+	return nil, fmt.Errorf("no allowed slot found after %s", t.Format(time.RFC3339))
+}
+
+// blockedUntil returns why no run is allowed at t and the earliest time that
+// might be allowed instead, or an empty reason when t is allowed.
+func (w *Windows) blockedUntil(t time.Time) (string, string, time.Time) {
+	for _, blackout := range w.blackouts {
+		if !t.Before(blackout.Start) && t.Before(blackout.End) {
+			message := fmt.Sprintf("blackout until %s", blackout.End.Format(time.RFC3339))
+			if blackout.Reason != "" {
+				message = fmt.Sprintf("%s: %s", message, blackout.Reason)
+			}
+			return ReasonBlackout, message, blackout.End
+		}
+	}
+
+	if len(w.windows) == 0 {
+		return "", "", time.Time{}
+	}
+
+	var next time.Time
+	for _, currWindow := range w.windows {
+		if currWindow.contains(t) {
+			return "", "", time.Time{}
+		}
+
+		start := currWindow.start.Next(t)
+		if !start.IsZero() && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+
+	message := "outside of the maintenance windows"
+	if !next.IsZero() {
+		message = fmt.Sprintf("%s, the next one opens at %s", message, next.Format(time.RFC3339))
+	}
+
+	return ReasonOutsideWindow, message, next
+}
@@ -0,0 +1,80 @@
+package schedule
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/models"
+)
+
+var _ = Describe("Windows", func() {
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+
+	It("rejects invalid windows and blackouts", func() {
+		for _, config := range []models.Config{
+			{Windows: []models.Window{{Start: "0 22 * *", Duration: "4h"}}},
+			{Windows: []models.Window{{Start: "0 22 * * *", Duration: "later"}}},
+			{Windows: []models.Window{{Start: "0 22 * * *", Duration: "0s"}}},
+			{Blackouts: []models.Blackout{{Start: time.Now(), End: time.Now().Add(-time.Hour)}}},
+		} {
+			config := config
+			_, err := ParseWindows(&config)
+			Expect(err).To(HaveOccurred(), "%+v", config)
+		}
+	})
+
+	It("allows runs at any time without windows and blackouts", func() {
+		windows, err := ParseWindows(&models.Config{})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(windows.Defer(time.Now())).To(BeNil())
+	})
+
+	It("defers runs outside of the windows to the next one", func() {
+		windows, err := ParseWindows(&models.Config{
+			TimeZone: "Europe/Berlin",
+			Windows:  []models.Window{{Start: "0 22 * * 1-5", Duration: "4h"}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		// Tuesday just after midnight is inside Monday's window.
+		Expect(windows.Defer(time.Date(2021, 7, 6, 1, 0, 0, 0, berlin))).To(BeNil())
+
+		// Saturday morning waits for Monday evening.
+		deferral, err := windows.Defer(time.Date(2021, 7, 3, 9, 0, 0, 0, berlin))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deferral.Reason).To(Equal(ReasonOutsideWindow))
+		Expect(deferral.Until).To(BeTemporally("==", time.Date(2021, 7, 5, 22, 0, 0, 0, berlin)))
+	})
+
+	It("defers runs during a blackout to the next allowed slot after it", func() {
+		windows, err := ParseWindows(&models.Config{
+			TimeZone: "Europe/Berlin",
+			Windows:  []models.Window{{Start: "0 22 * * *", Duration: "4h"}},
+			Blackouts: []models.Blackout{{
+				Start:  time.Date(2021, 7, 5, 0, 0, 0, 0, berlin),
+				End:    time.Date(2021, 7, 7, 23, 0, 0, 0, berlin),
+				Reason: "release freeze",
+			}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		deferral, err := windows.Defer(time.Date(2021, 7, 6, 22, 30, 0, 0, berlin))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deferral.Reason).To(Equal(ReasonBlackout))
+		Expect(deferral.Message).To(ContainSubstring("release freeze"))
+		Expect(deferral.Until).To(BeTemporally("==", time.Date(2021, 7, 7, 23, 0, 0, 0, berlin)))
+	})
+
+	It("fails when no slot is ever allowed", func() {
+		windows, err := ParseWindows(&models.Config{
+			Windows: []models.Window{{Start: "0 0 30 2 *", Duration: "1h"}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = windows.Defer(time.Now())
+		Expect(err).To(HaveOccurred())
+	})
+})
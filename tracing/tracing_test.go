@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var _ = Describe("Tracing", func() {
+	var recorder *tracetest.SpanRecorder
+	var provider *sdktrace.TracerProvider
+
+	BeforeEach(func() {
+		recorder = tracetest.NewSpanRecorder()
+		provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	})
+
+	It("finds the trace ID of traced contexts only", func() {
+		_, ok := TraceID(context.Background())
+		Expect(ok).To(BeFalse())
+
+		ctx, span := provider.Tracer(instrumentationName).Start(context.Background(), "FlipperRun")
+		defer span.End()
+
+		traceID, ok := TraceID(ctx)
+		Expect(ok).To(BeTrue())
+		Expect(traceID).To(Equal(span.SpanContext().TraceID().String()))
+		Expect(traceID).To(HaveLen(32))
+	})
+
+	It("marks spans that end with an error as failed", func() {
+		_, failed := provider.Tracer(instrumentationName).Start(context.Background(), "WaitForRollout")
+		End(failed, errors.New("rollout did not complete"))
+
+		_, succeeded := provider.Tracer(instrumentationName).Start(context.Background(), "PatchTemplate")
+		End(succeeded, nil)
+
+		spans := recorder.Ended()
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[0].Status().Code).To(Equal(codes.Error))
+		Expect(spans[0].Status().Description).To(Equal("rollout did not complete"))
+		Expect(spans[0].Events()).To(HaveLen(1))
+		Expect(spans[1].Status().Code).To(Equal(codes.Unset))
+	})
+})
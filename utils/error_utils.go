@@ -27,7 +27,7 @@ func (mErr *MultiError) AppendError(err error) {
 func (mErr *MultiError) Error() string {
 	var errMsg string
 
-	for _, currErrMsg := range strings.Split(mErr.Error(), "|") {
+	for _, currErrMsg := range strings.Split(mErr.err.Error(), "|") {
 		if errMsg == "" {
 			errMsg = currErrMsg
 			continue
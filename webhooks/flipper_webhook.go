@@ -0,0 +1,394 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+	"github.com/anmolbabu/kraft-controller/matching"
+	"github.com/anmolbabu/kraft-controller/models"
+	"github.com/anmolbabu/kraft-controller/notify"
+	"github.com/anmolbabu/kraft-controller/schedule"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// validateFlipperPath is where the API server sends Flippers to validate.
+	validateFlipperPath = "/validate-flipper-flipper-io-v1beta1-flipper"
+
+	// defaultProgressDeadlineSeconds is the progressDeadlineSeconds of
+	// Deployments that do not set it.
+	defaultProgressDeadlineSeconds = 600
+)
+
+var flipperlog = logf.Log.WithName("flipper-resource")
+
+// FlipperValidator validates Flippers as they are created and updated.
+type FlipperValidator struct {
+	// Client reads the workloads a Flipper matches, to warn about those its
+	// schedule does not suit. Those checks are skipped while it is nil.
+	Client client.Reader
+
+	decoder *admission.Decoder
+}
+
+// SetupWebhookWithManager registers the validating webhook of Flippers.
+func (v *FlipperValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(validateFlipperPath, &webhook.Admission{Handler: v})
+
+	return nil
+}
+
+// InjectDecoder implements admission.DecoderInjector.
+func (v *FlipperValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+
+	return nil
+}
+
+//+kubebuilder:webhook:path=/validate-flipper-flipper-io-v1beta1-flipper,mutating=false,failurePolicy=fail,sideEffects=None,groups=flipper.flipper.io,resources=flippers,verbs=create;update,versions=v1beta1,name=vflipper.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ admission.Handler = &FlipperValidator{}
+
+// Handle rejects Flippers with an invalid spec, and warns about those whose
+// schedule does not suit the workloads they match. Updates that leave the
+// spec alone, such as run requests, are always let through, so that they do
+// not depend on the state of the cluster.
+func (v *FlipperValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	flipper := &v1beta1.Flipper{}
+	err := v.decoder.Decode(req, flipper)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	flipperlog.Info("validate "+strings.ToLower(string(req.Operation)), "name", flipper.Name)
+
+	if req.Operation == admissionv1.Update {
+		old := &v1beta1.Flipper{}
+		err = v.decoder.DecodeRaw(req.OldObject, old)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		if equality.Semantic.DeepEqual(old.Spec, flipper.Spec) {
+			return admission.Allowed("")
+		}
+	}
+
+	allErrs := validateSpec(flipper)
+	if len(allErrs) > 0 {
+		status := apierrors.NewInvalid(v1beta1.GroupVersion.WithKind("Flipper").GroupKind(), flipper.Name, allErrs).Status()
+		return admission.Response{AdmissionResponse: admissionv1.AdmissionResponse{Allowed: false, Result: &status}}
+	}
+
+	return admission.Allowed("").WithWarnings(v.progressDeadlineWarnings(ctx, flipper)...)
+}
+
+// validateSpec checks the spec of flipper.
+func validateSpec(flipper *v1beta1.Flipper) field.ErrorList {
+	var allErrs field.ErrorList
+
+	specPath := field.NewPath("spec")
+
+	err := validateSchedule(flipper, specPath)
+	if err != nil {
+		allErrs = append(allErrs, err)
+	}
+
+	for idx, window := range flipper.Spec.Windows {
+		_, parseErr := schedule.ParseWindows(&models.Config{
+			TimeZone: flipper.Spec.TimeZone,
+			Windows:  []models.Window{{Start: window.Start, Duration: window.Duration}},
+		})
+		if parseErr != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("windows").Index(idx), window, parseErr.Error()))
+		}
+	}
+
+	for idx, blackout := range flipper.Spec.Blackouts {
+		if !blackout.End.After(blackout.Start.Time) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("blackouts").Index(idx).Child("end"), blackout.End, "must be after start"))
+		}
+	}
+
+	if flipper.Spec.CoalesceWindow != "" {
+		coalesceWindow, parseErr := time.ParseDuration(flipper.Spec.CoalesceWindow)
+		if parseErr != nil || coalesceWindow < 0 {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("coalesceWindow"), flipper.Spec.CoalesceWindow, "must be a non-negative duration such as \"5m\""))
+		}
+	}
+
+	strategy := flipper.Spec.Strategy
+	strategyPath := specPath.Child("strategy")
+	switch {
+	case strategy.Type == v1beta1.StrategyMaxConcurrent && strategy.MaxConcurrent < 1:
+		allErrs = append(allErrs, field.Required(strategyPath.Child("maxConcurrent"), "must be at least 1 with the MaxConcurrent type"))
+	case strategy.Type != v1beta1.StrategyMaxConcurrent && strategy.MaxConcurrent != 0:
+		allErrs = append(allErrs, field.Forbidden(strategyPath.Child("maxConcurrent"), "may only be set with the MaxConcurrent type"))
+	}
+
+	if flipper.Spec.MaxPodAge != "" {
+		maxPodAge, parseErr := time.ParseDuration(flipper.Spec.MaxPodAge)
+		if parseErr != nil || maxPodAge <= 0 {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("maxPodAge"), flipper.Spec.MaxPodAge, "must be a positive duration such as \"168h\""))
+		}
+	}
+
+	if flipper.Spec.ReloadOnConfigChange {
+		// Config changes only roll out Deployments, other kinds would be
+		// matched without ever being reloaded.
+		kindsPath := specPath.Child("match", "kinds")
+		for idx, kind := range flipper.Spec.Match.Kinds {
+			if string(kind) != models.KindDeployment {
+				allErrs = append(allErrs, field.Forbidden(kindsPath.Index(idx), "only Deployments are reloaded on config changes"))
+			}
+		}
+		if len(flipper.Spec.Match.CustomKinds) > 0 {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("match", "customKinds"), "only Deployments are reloaded on config changes"))
+		}
+	}
+
+	if strategy.RestartMethod != v1beta1.RestartMethodEvict && strategy.OrderByPodDeletionCost {
+		allErrs = append(allErrs, field.Forbidden(strategyPath.Child("orderByPodDeletionCost"), "may only be set with the Evict restart method"))
+	}
+
+	if strategy.RestartMethod == v1beta1.RestartMethodEvict && strategy.RollbackOnFailure {
+		allErrs = append(allErrs, field.Forbidden(strategyPath.Child("rollbackOnFailure"), "has nothing to roll back with the Evict restart method"))
+	}
+
+	if strategy.RolloutTimeout != "" {
+		rolloutTimeout, parseErr := time.ParseDuration(strategy.RolloutTimeout)
+		if parseErr != nil || rolloutTimeout <= 0 {
+			allErrs = append(allErrs, field.Invalid(strategyPath.Child("rolloutTimeout"), strategy.RolloutTimeout, "must be a positive duration such as \"15m\""))
+		}
+	}
+
+	for idx, notification := range flipper.Spec.Notifications {
+		notificationPath := specPath.Child("notifications").Index(idx)
+		if notification.SecretName == "" {
+			allErrs = append(allErrs, field.Required(notificationPath.Child("secretName"), "must name the Secret holding the url of the endpoint"))
+		}
+
+		switch {
+		case notification.Format == v1beta1.NotificationTemplate && notification.Template == "":
+			allErrs = append(allErrs, field.Required(notificationPath.Child("template"), "must be set with the Template format"))
+		case notification.Format != v1beta1.NotificationTemplate && notification.Template != "":
+			allErrs = append(allErrs, field.Forbidden(notificationPath.Child("template"), "may only be set with the Template format"))
+		case notification.Template != "":
+			_, parseErr := notify.ParseTemplate(notification.Template)
+			if parseErr != nil {
+				allErrs = append(allErrs, field.Invalid(notificationPath.Child("template"), notification.Template, parseErr.Error()))
+			}
+		}
+	}
+
+	allErrs = append(allErrs, validateMatch(flipper, specPath.Child("match"))...)
+
+	return allErrs
+}
+
+// validateSchedule checks that exactly one of Interval and Schedule is set and
+// that it parses.
+func validateSchedule(flipper *v1beta1.Flipper, specPath *field.Path) *field.Error {
+	switch {
+	case flipper.Spec.Interval != "" && flipper.Spec.Schedule != "":
+		return field.Forbidden(specPath.Child("schedule"), "must not be set together with interval")
+	case flipper.Spec.Interval == "" && flipper.Spec.Schedule == "" && flipper.Spec.MaxPodAge == "":
+		if flipper.Spec.ReloadOnConfigChange {
+			return nil
+		}
+		return field.Required(specPath.Child("schedule"), "either schedule or interval must be set")
+	}
+
+	_, err := schedule.Parse(models.ConfigFromFlipper(*flipper))
+	if err == nil {
+		return nil
+	}
+
+	switch {
+	case flipper.Spec.Interval != "":
+		return field.Invalid(specPath.Child("interval"), flipper.Spec.Interval, err.Error())
+	case strings.Contains(err.Error(), "timeZone"):
+		return field.Invalid(specPath.Child("timeZone"), flipper.Spec.TimeZone, err.Error())
+	default:
+		return field.Invalid(specPath.Child("schedule"), flipper.Spec.Schedule, err.Error())
+	}
+}
+
+func validateMatch(flipper *v1beta1.Flipper, matchPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	match := flipper.Spec.Match
+
+	scopes := 0
+	for _, set := range []bool{match.Namespace != "", match.NamespaceSelector != nil, match.AllNamespaces} {
+		if set {
+			scopes++
+		}
+	}
+	if scopes > 1 {
+		allErrs = append(allErrs, field.Forbidden(matchPath, "only one of namespace, namespaceSelector and allNamespaces may be set"))
+	}
+
+	allErrs = append(allErrs, metav1validation.ValidateLabels(match.Labels, matchPath.Child("labels"))...)
+
+	if match.Selector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(match.Selector, matchPath.Child("selector"))...)
+	}
+
+	if match.NamespaceSelector != nil {
+		allErrs = append(allErrs, metav1validation.ValidateLabelSelector(match.NamespaceSelector, matchPath.Child("namespaceSelector"))...)
+	}
+
+	emptySelector := match.Selector == nil || len(match.Selector.MatchLabels) == 0 && len(match.Selector.MatchExpressions) == 0
+	if len(match.Labels) == 0 && emptySelector {
+		allErrs = append(allErrs, field.Required(matchPath.Child("selector"), "labels or selector must be set, an empty selector would restart every workload in the selected namespaces"))
+	}
+
+	seenKinds := make(map[schema.GroupKind]bool)
+	for _, kind := range match.Kinds {
+		seenKinds[schema.GroupKind{Group: appsv1.GroupName, Kind: string(kind)}] = true
+	}
+
+	for idx, kind := range match.CustomKinds {
+		kindPath := matchPath.Child("customKinds").Index(idx)
+
+		groupVersion, err := schema.ParseGroupVersion(kind.APIVersion)
+		if err != nil || kind.APIVersion == "" {
+			allErrs = append(allErrs, field.Invalid(kindPath.Child("apiVersion"), kind.APIVersion, "must be a group and version such as \"argoproj.io/v1alpha1\""))
+			continue
+		}
+
+		if kind.Kind == "" {
+			allErrs = append(allErrs, field.Required(kindPath.Child("kind"), ""))
+			continue
+		}
+
+		groupKind := groupVersion.WithKind(kind.Kind).GroupKind()
+		if seenKinds[groupKind] {
+			allErrs = append(allErrs, field.Duplicate(kindPath, groupKind.String()))
+		}
+		seenKinds[groupKind] = true
+
+		if kind.Health != nil && (kind.Health.ReadyPath == "") != (kind.Health.DesiredPath == "") {
+			allErrs = append(allErrs, field.Invalid(kindPath.Child("health"), kind.Health, "readyPath and desiredPath must be set together"))
+		}
+		if kind.Health != nil && kind.Health.UpdatedPath != "" && kind.Health.DesiredPath == "" {
+			allErrs = append(allErrs, field.Invalid(kindPath.Child("health"), kind.Health, "updatedPath needs desiredPath"))
+		}
+	}
+
+	for idx, name := range match.Exclude {
+		if name == "" || strings.Count(name, "/") > 1 || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") {
+			allErrs = append(allErrs, field.Invalid(matchPath.Child("exclude").Index(idx), name, "must be either \"name\" or \"namespace/name\""))
+		}
+	}
+
+	return allErrs
+}
+
+// progressDeadlineWarnings warns about matched Deployments that the schedule
+// of flipper comes due for again before they are given up on, which would
+// stack up rollouts. The check depends on the Deployments at the time, so it
+// only ever warns.
+func (v *FlipperValidator) progressDeadlineWarnings(ctx context.Context, flipper *v1beta1.Flipper) []string {
+	if v.Client == nil {
+		return nil
+	}
+
+	config := models.ConfigFromFlipper(*flipper)
+	if config.Interval == "" && config.Schedule == "" {
+		return nil
+	}
+
+	restartSchedule, err := schedule.Parse(config)
+	if err != nil {
+		return nil
+	}
+
+	selector, err := matching.NewSelector(config)
+	if err != nil {
+		return nil
+	}
+
+	deployments, err := matchedDeployments(ctx, v.Client, selector)
+	if err != nil {
+		flipperlog.Error(err, "failed to list matched deployments", "name", flipper.Name)
+		return nil
+	}
+
+	var warnings []string
+	interval := schedule.ShortestInterval(restartSchedule)
+	for _, deployment := range deployments {
+		deadline := int32(defaultProgressDeadlineSeconds)
+		if deployment.Spec.ProgressDeadlineSeconds != nil {
+			deadline = *deployment.Spec.ProgressDeadlineSeconds
+		}
+
+		if interval < time.Duration(deadline)*time.Second {
+			warnings = append(warnings, fmt.Sprintf("restarts every %s, which is shorter than the progressDeadlineSeconds of %ds of deployment %s/%s", interval, deadline, deployment.Namespace, deployment.Name))
+		}
+	}
+
+	return warnings
+}
+
+// matchedDeployments lists the Deployments selector matches.
+func matchedDeployments(ctx context.Context, c client.Reader, selector *matching.Selector) ([]appsv1.Deployment, error) {
+	namespaces, err := selector.Namespaces(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	deploymentList := &appsv1.DeploymentList{}
+	err = c.List(ctx, deploymentList, selector.ListOptions(namespaces)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var deployments []appsv1.Deployment
+	for _, deployment := range deploymentList.Items {
+		workload := models.Workload{
+			Type:      models.BuiltinWorkloadTypes[models.KindDeployment],
+			Namespace: deployment.Namespace,
+			Name:      deployment.Name,
+			Labels:    deployment.Labels,
+		}
+		if selector.Selects(workload, namespaces) {
+			deployments = append(deployments, deployment)
+		}
+	}
+
+	return deployments, nil
+}
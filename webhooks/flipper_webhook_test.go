@@ -0,0 +1,193 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/anmolbabu/kraft-controller/api/v1beta1"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newFlipper() *v1beta1.Flipper {
+	return &v1beta1.Flipper{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1beta1.GroupVersion.String(), Kind: "Flipper"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "nightly"},
+		Spec: v1beta1.FlipperSpec{
+			Schedule: "30 3 * * *",
+			Match:    v1beta1.Match{Labels: map[string]string{"app": "web"}},
+		},
+	}
+}
+
+var _ = Describe("Flipper validation", func() {
+	testScheme := runtime.NewScheme()
+	Expect(scheme.AddToScheme(testScheme)).To(Succeed())
+	Expect(v1beta1.AddToScheme(testScheme)).To(Succeed())
+
+	newValidator := func(deployments ...appsv1.Deployment) *FlipperValidator {
+		builder := fake.NewClientBuilder().WithScheme(testScheme)
+		for idx := range deployments {
+			builder = builder.WithObjects(&deployments[idx])
+		}
+
+		validator := &FlipperValidator{Client: builder.Build()}
+		decoder, err := admission.NewDecoder(testScheme)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(validator.InjectDecoder(decoder)).To(Succeed())
+
+		return validator
+	}
+
+	request := func(operation admissionv1.Operation, flipper *v1beta1.Flipper, old *v1beta1.Flipper) admission.Request {
+		req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: operation}}
+
+		raw, err := json.Marshal(flipper)
+		Expect(err).NotTo(HaveOccurred())
+		req.Object = runtime.RawExtension{Raw: raw}
+
+		if old != nil {
+			raw, err = json.Marshal(old)
+			Expect(err).NotTo(HaveOccurred())
+			req.OldObject = runtime.RawExtension{Raw: raw}
+		}
+
+		return req
+	}
+
+	It("accepts valid flippers", func() {
+		flipper := newFlipper()
+		flipper.Default()
+
+		Expect(validateSpec(flipper)).To(BeEmpty())
+	})
+
+	It("accepts flippers with a max pod age and no schedule", func() {
+		flipper := newFlipper()
+		flipper.Spec.Schedule = ""
+		flipper.Spec.MaxPodAge = "168h"
+		flipper.Default()
+
+		Expect(validateSpec(flipper)).To(BeEmpty())
+	})
+
+	It("accepts flippers that only reload on config changes without a schedule", func() {
+		flipper := newFlipper()
+		flipper.Spec.Schedule = ""
+		flipper.Spec.ReloadOnConfigChange = true
+		flipper.Default()
+
+		Expect(validateSpec(flipper)).To(BeEmpty())
+	})
+
+	It("rejects invalid flippers with the offending field", func() {
+		for field, mutate := range map[string]func(*v1beta1.Flipper){
+			"spec.schedule": func(flipper *v1beta1.Flipper) { flipper.Spec.Interval = "1h" },
+			"spec.interval": func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Schedule = ""
+				flipper.Spec.Interval = "every hour"
+			},
+			"spec.timeZone": func(flipper *v1beta1.Flipper) { flipper.Spec.TimeZone = "Mars/Olympus_Mons" },
+			"spec.windows[0]": func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Windows = []v1beta1.MaintenanceWindow{{Start: "0 22 * * *", Duration: "-1h"}}
+			},
+			"spec.blackouts[0].end": func(flipper *v1beta1.Flipper) {
+				start := metav1.NewTime(time.Date(2021, 12, 24, 0, 0, 0, 0, time.UTC))
+				flipper.Spec.Blackouts = []v1beta1.Blackout{{Start: start, End: start}}
+			},
+			"spec.coalesceWindow": func(flipper *v1beta1.Flipper) { flipper.Spec.CoalesceWindow = "soon" },
+			"spec.maxPodAge":      func(flipper *v1beta1.Flipper) { flipper.Spec.MaxPodAge = "a week" },
+			"spec.strategy.maxConcurrent": func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Strategy = v1beta1.RestartStrategy{Type: v1beta1.StrategyMaxConcurrent}
+			},
+			"spec.strategy.rolloutTimeout": func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Strategy.RolloutTimeout = "0s"
+			},
+			"spec.strategy.orderByPodDeletionCost": func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Strategy.OrderByPodDeletionCost = true
+			},
+			"spec.match": func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Match.Namespace = "apps"
+				flipper.Spec.Match.AllNamespaces = true
+			},
+			"spec.match.selector": func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Match.Labels = nil
+				flipper.Spec.Match.Selector = &metav1.LabelSelector{}
+			},
+			"spec.match.customKinds[0].apiVersion": func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Match.CustomKinds = []v1beta1.CustomKind{{APIVersion: "a/b/c", Kind: "Rollout"}}
+			},
+			"spec.match.kinds[1]": func(flipper *v1beta1.Flipper) {
+				flipper.Spec.ReloadOnConfigChange = true
+				flipper.Spec.Match.Kinds = []v1beta1.WorkloadKind{"Deployment", "StatefulSet"}
+			},
+			"spec.match.customKinds": func(flipper *v1beta1.Flipper) {
+				flipper.Spec.ReloadOnConfigChange = true
+				flipper.Spec.Match.CustomKinds = []v1beta1.CustomKind{{APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout"}}
+			},
+			"spec.match.exclude[0]": func(flipper *v1beta1.Flipper) { flipper.Spec.Match.Exclude = []string{"a/b/c"} },
+			"spec.notifications[0].secretName": func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Notifications = []v1beta1.Notification{{Format: v1beta1.NotificationCloudEvents}}
+			},
+			"spec.notifications[0].template": func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Notifications = []v1beta1.Notification{{SecretName: "slack", Format: v1beta1.NotificationTemplate, Template: "{{ .Flipper"}}
+			},
+			"spec.notifications[1].template": func(flipper *v1beta1.Flipper) {
+				flipper.Spec.Notifications = []v1beta1.Notification{
+					{SecretName: "slack", Format: v1beta1.NotificationTemplate, Template: `{"text": "{{ .Flipper }}"}`},
+					{SecretName: "hook", Format: v1beta1.NotificationCloudEvents, Template: "{{ .Flipper }}"},
+				}
+			},
+		} {
+			flipper := newFlipper()
+			mutate(flipper)
+
+			response := newValidator().Handle(context.Background(), request(admissionv1.Create, flipper, nil))
+			Expect(response.Allowed).To(BeFalse(), field)
+			Expect(response.Result.Reason).To(Equal(metav1.StatusReasonInvalid), field)
+			Expect(response.Result.Message).To(ContainSubstring(field))
+		}
+	})
+
+	It("warns about deployments that are given up on after the next run is due", func() {
+		deadline := int32(7200)
+		deployments := []appsv1.Deployment{
+			{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "web", Labels: map[string]string{"app": "web"}}},
+			{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "slow", Labels: map[string]string{"app": "web"}}, Spec: appsv1.DeploymentSpec{ProgressDeadlineSeconds: &deadline}},
+			{ObjectMeta: metav1.ObjectMeta{Namespace: "batch", Name: "slow", Labels: map[string]string{"app": "web"}}, Spec: appsv1.DeploymentSpec{ProgressDeadlineSeconds: &deadline}},
+		}
+
+		flipper := newFlipper()
+		flipper.Spec.Schedule = ""
+		flipper.Spec.Interval = "1h"
+		flipper.Default()
+
+		response := newValidator(deployments...).Handle(context.Background(), request(admissionv1.Create, flipper, nil))
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Warnings).To(ConsistOf(ContainSubstring("deployment apps/slow")))
+	})
+
+	It("lets updates through that leave the spec alone", func() {
+		old := newFlipper()
+		old.Spec.Interval = "1h"
+
+		flipper := old.DeepCopy()
+		flipper.Annotations = map[string]string{v1beta1.RunNowAnnotation: "now"}
+
+		validator := newValidator()
+		Expect(validator.Handle(context.Background(), request(admissionv1.Update, flipper, old)).Allowed).To(BeTrue())
+
+		flipper.Spec.Priority = 1
+		Expect(validator.Handle(context.Background(), request(admissionv1.Update, flipper, old)).Allowed).To(BeFalse())
+	})
+})